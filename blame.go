@@ -0,0 +1,360 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BlameOptions configures Blame.
+type BlameOptions struct {
+	// IgnoreWhitespace ignores changes in whitespace, like git blame -w.
+	IgnoreWhitespace bool
+
+	// FollowRenames continues with the old file where a file was renamed
+	// or copied.
+	FollowRenames bool
+
+	// IgnoreRevs are commits whose changes are attributed to the commits
+	// before them, like git blame --ignore-rev. As in git, a changed line
+	// is taken to come from the most similar of the lines it replaced,
+	// compared by their pairs of adjacent characters; lines without a
+	// clearly most similar one stay with the ignored commit.
+	IgnoreRevs []string
+}
+
+// BlameLine is the origin of a line of a file.
+type BlameLine struct {
+	Commit *Commit // the commit which last changed the line
+	Path   string  // the path of the file in Commit
+	Line   int     // the 1-based line number in that version of the file
+}
+
+// Blame returns the origin of each line of the file at path in the commit.
+func (repo *Repository) Blame(commitId, path string, opts BlameOptions) ([]*BlameLine, error) {
+	id, err := NewIdFromString(commitId)
+	if err != nil {
+		return nil, err
+	}
+	return repo.blame(id, path, opts)
+}
+
+// Blame returns the origin of each line of the file at path in the commit.
+func (c *Commit) Blame(path string, opts BlameOptions) ([]*BlameLine, error) {
+	return c.repo.blame(c.Id, path, opts)
+}
+
+// ReadIgnoreRevs reads a list of commits to ignore in the format of
+// blame.ignoreRevsFile: one commit id per line, with comments starting
+// with "#".
+func ReadIgnoreRevs(r io.Reader) ([]string, error) {
+	var revs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if _, err := NewIdFromString(line); err != nil {
+			return nil, fmt.Errorf("invalid commit %q in ignored revisions", line)
+		}
+		revs = append(revs, line)
+	}
+	return revs, scanner.Err()
+}
+
+// blameLine is a line of the blamed file which is not attributed yet, at
+// its position in an older version of the file.
+type blameLine struct {
+	final int // index in the result
+	line  int // 0-based line in the older version
+
+	// the blame if the older version is not reached by the walk
+	fallback *BlameLine
+}
+
+// blamer passes the lines of a file down the history until a commit which
+// changed them. The history of each path is walked separately.
+type blamer struct {
+	repo   *Repository
+	opts   BlameOptions
+	ignore map[sha1]bool
+	result []*BlameLine
+
+	// lines to attribute by path and blob
+	pending map[string]map[sha1][]blameLine
+	walks   []blameWalk
+}
+
+type blameWalk struct {
+	start *Commit
+	path  string
+}
+
+func (repo *Repository) blame(id sha1, path string, opts BlameOptions) ([]*BlameLine, error) {
+	commit, err := repo.getCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	te, err := commit.GetTreeEntryByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if te.IsDir() || te.mode == ModeCommit {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	data, err := repo.blobData(te.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blamer{
+		repo:    repo,
+		opts:    opts,
+		ignore:  make(map[sha1]bool),
+		pending: make(map[string]map[sha1][]blameLine),
+		walks:   []blameWalk{{commit, path}},
+	}
+	for _, idStr := range opts.IgnoreRevs {
+		id, err := NewIdFromString(idStr)
+		if err != nil {
+			return nil, err
+		}
+		b.ignore[id] = true
+	}
+
+	n := len(splitLines(data))
+	b.result = make([]*BlameLine, n)
+	lines := make([]blameLine, n)
+	for i := range lines {
+		lines[i] = blameLine{final: i, line: i, fallback: &BlameLine{Commit: commit, Path: path, Line: i + 1}}
+	}
+	b.pending[path] = map[sha1][]blameLine{te.Id: lines}
+
+	for len(b.walks) > 0 {
+		w := b.walks[0]
+		b.walks = b.walks[1:]
+		if _, err := walkFilteredHistory(w.start, b.callback(w.path), makePathComparator(w.path)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, blobs := range b.pending {
+		for _, lines := range blobs {
+			for _, l := range lines {
+				if b.result[l.final] == nil {
+					b.result[l.final] = l.fallback
+				}
+			}
+		}
+	}
+	return b.result, nil
+}
+
+// callback takes the lines pending for the version of path in a commit and
+// passes those the commit did not change to its parents.
+func (b *blamer) callback(path string) CommitWalkCallback {
+	return func(c *Commit) (HistoryWalkerAction, error) {
+		te, err := c.GetTreeEntryByPath(path)
+		if err == ErrNotExist {
+			return HWDrop, nil
+		} else if err != nil {
+			return HWStop, err
+		}
+		lines := b.pending[path][te.Id]
+		if len(lines) == 0 {
+			return HWDrop, nil
+		}
+		delete(b.pending[path], te.Id)
+
+		data, err := b.repo.blobData(te.Id)
+		if err != nil {
+			return HWStop, err
+		}
+		follow := false
+		for i := 0; i < c.ParentCount() && len(lines) > 0; i++ {
+			parent, err := c.Parent(i)
+			if err != nil {
+				return HWStop, err
+			}
+			var passed bool
+			if lines, passed, err = b.passToParent(c, path, data, lines, parent); err != nil {
+				return HWStop, err
+			}
+			follow = follow || passed
+		}
+
+		for _, l := range lines {
+			b.result[l.final] = &BlameLine{Commit: c, Path: path, Line: l.line + 1}
+		}
+		if follow {
+			return HWFollowParents, nil
+		}
+		return HWDrop, nil
+	}
+}
+
+// passToParent moves the lines which the parent already had to its version
+// of the file and returns the others. followed reports whether the parent
+// continues the walk of path, rather than a new walk for its old path.
+func (b *blamer) passToParent(c *Commit, path string, data []byte, lines []blameLine, parent *Commit) (rest []blameLine, followed bool, err error) {
+	parentPath := path
+	te, err := parent.GetTreeEntryByPath(path)
+	if err == ErrNotExist || err == nil && (te.IsDir() || te.mode == ModeCommit) {
+		if !b.opts.FollowRenames {
+			return lines, false, nil
+		}
+		if parentPath, te, err = b.renameSource(parent, c, path); te == nil {
+			return lines, false, err
+		}
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	parentData, err := b.repo.blobData(te.Id)
+	if err != nil {
+		return nil, false, err
+	}
+	origins := blameOrigins(splitLines(parentData), splitLines(data), b.opts.IgnoreWhitespace, b.ignore[c.Id])
+
+	var passed []blameLine
+	for _, l := range lines {
+		o := origins[l.line]
+		if o < 0 {
+			rest = append(rest, l)
+			continue
+		}
+		passed = append(passed, blameLine{
+			final:    l.final,
+			line:     o,
+			fallback: &BlameLine{Commit: c, Path: path, Line: l.line + 1},
+		})
+	}
+	if len(passed) == 0 {
+		return rest, false, nil
+	}
+
+	if b.pending[parentPath] == nil {
+		b.pending[parentPath] = make(map[sha1][]blameLine)
+	}
+	b.pending[parentPath][te.Id] = append(b.pending[parentPath][te.Id], passed...)
+	if parentPath != path {
+		b.walks = append(b.walks, blameWalk{parent, parentPath})
+		return rest, false, nil
+	}
+	return rest, true, nil
+}
+
+// renameSource finds the file of the parent which was renamed or copied to
+// path in the commit.
+func (b *blamer) renameSource(parent, c *Commit, path string) (string, *TreeEntry, error) {
+	changes, err := b.repo.diffTrees(&parent.Tree, &c.Tree, TreeDiffOptions{DetectRenames: true})
+	if err != nil {
+		return "", nil, err
+	}
+	for _, change := range changes {
+		if change.NewPath != path || change.Type != ChangeRenamed && change.Type != ChangeCopied {
+			continue
+		}
+		te, err := parent.GetTreeEntryByPath(change.OldPath)
+		if err != nil {
+			return "", nil, err
+		}
+		return change.OldPath, te, nil
+	}
+	return "", nil, nil
+}
+
+// blameOrigins returns for each new line the index of the old line it
+// comes from, -1 for changed lines. With guess, changed lines are matched
+// to similar lines among those they replaced.
+func blameOrigins(oldLines, newLines []string, ignoreWhitespace, guess bool) []int {
+	oldChanged, newChanged := diffLines(oldLines, newLines, LineDiffOptions{IgnoreAllSpace: ignoreWhitespace})
+
+	origins := make([]int, len(newLines))
+	i, j := 0, 0
+	for j < len(newLines) {
+		if !newChanged[j] && i < len(oldLines) && !oldChanged[i] {
+			origins[j] = i
+			i++
+			j++
+			continue
+		}
+
+		start := i
+		for i < len(oldLines) && oldChanged[i] {
+			i++
+		}
+		if !newChanged[j] {
+			continue
+		}
+		changed := j
+		for j < len(newLines) && newChanged[j] {
+			origins[j] = -1
+			j++
+		}
+		if guess {
+			guessOrigins(oldLines[start:i], newLines[changed:j], origins[changed:j], start)
+		}
+	}
+	return origins
+}
+
+// guessOrigins matches the changed new lines to the old lines they
+// replaced, like git blame does for ignored commits: each new line comes
+// from the most similar old line after the one the previous line matched,
+// if there is a single most similar one. origins are offset by start.
+func guessOrigins(oldLines, newLines []string, origins []int, start int) {
+	fingerprints := make([]map[string]int, len(oldLines))
+	for i, line := range oldLines {
+		fingerprints[i] = lineFingerprint(line)
+	}
+
+	next := 0
+	for j, line := range newLines {
+		fp := lineFingerprint(line)
+		best, bestSimilarity, unique := -1, 0, false
+		for i := next; i < len(oldLines); i++ {
+			similarity := fingerprintSimilarity(fp, fingerprints[i])
+			if similarity > bestSimilarity {
+				best, bestSimilarity, unique = i, similarity, true
+			} else if similarity == bestSimilarity {
+				unique = false
+			}
+		}
+		if best >= 0 && unique {
+			origins[j] = start + best
+			next = best + 1
+		}
+	}
+}
+
+// lineFingerprint counts the pairs of adjacent characters of a line,
+// ignoring case.
+func lineFingerprint(line string) map[string]int {
+	line = strings.ToLower(line)
+	fp := make(map[string]int)
+	for i := 0; i+1 < len(line); i++ {
+		fp[line[i:i+2]]++
+	}
+	return fp
+}
+
+// fingerprintSimilarity returns the number of character pairs two lines
+// have in common.
+func fingerprintSimilarity(a, b map[string]int) int {
+	similarity := 0
+	for pair, n := range a {
+		if m := b[pair]; m < n {
+			similarity += m
+		} else {
+			similarity += n
+		}
+	}
+	return similarity
+}
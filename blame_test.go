@@ -0,0 +1,98 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// storeTestCommit writes a commit of the tree with the given parents,
+// committed the given number of minutes after a fixed time.
+func storeTestCommit(t *testing.T, r *Repository, tree sha1, minutes int, parents ...sha1) *Commit {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(&buf, "parent %s\n", p)
+	}
+	when := 1600000000 + 60*minutes
+	fmt.Fprintf(&buf, "author A <a@example.com> %d +0000\n", when)
+	fmt.Fprintf(&buf, "committer A <a@example.com> %d +0000\n\ncommit %d\n", when, minutes)
+	id, err := r.StoreObjectLoose(ObjectCommit, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.getCommit(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBlame(t *testing.T) {
+	r := copyTestRepo(t)
+	file := func(name, content string) sha1 {
+		id, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storeTestTree(t, r, []*TreeEntry{{name: name, mode: ModeBlob, Id: id}})
+	}
+
+	c1 := storeTestCommit(t, r, file("f", "a\nb\nc\n"), 1)
+	c2 := storeTestCommit(t, r, file("f", "a\nB\nc\nd\n"), 2, c1.Id)
+	c3 := storeTestCommit(t, r, file("g", "a\nB\nc\nd\ne\n"), 3, c2.Id)
+	c4 := storeTestCommit(t, r, file("g", "a\n  B\nc\nd\ne\n"), 4, c3.Id)
+	names := map[sha1]string{c1.Id: "c1", c2.Id: "c2", c3.Id: "c3", c4.Id: "c4"}
+
+	blame := func(opts BlameOptions) string {
+		lines, err := r.Blame(c4.Id.String(), "g", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, l := range lines {
+			s = append(s, fmt.Sprintf("%s %s:%d", names[l.Commit.Id], l.Path, l.Line))
+		}
+		return strings.Join(s, ", ")
+	}
+
+	tests := []struct {
+		opts     BlameOptions
+		expected string
+	}{
+		{BlameOptions{}, "c3 g:1, c4 g:2, c3 g:3, c3 g:4, c3 g:5"},
+		{BlameOptions{FollowRenames: true}, "c1 f:1, c4 g:2, c1 f:3, c2 f:4, c3 g:5"},
+		{BlameOptions{FollowRenames: true, IgnoreWhitespace: true}, "c1 f:1, c2 f:2, c1 f:3, c2 f:4, c3 g:5"},
+		{BlameOptions{FollowRenames: true, IgnoreRevs: []string{c4.Id.String()}}, "c1 f:1, c2 f:2, c1 f:3, c2 f:4, c3 g:5"},
+	}
+	for _, test := range tests {
+		if got := blame(test.opts); got != test.expected {
+			t.Errorf("unexpected blame with %+v:\n%s", test.opts, got)
+		}
+	}
+
+	revs, err := ReadIgnoreRevs(strings.NewReader("# formatting\n" + c4.Id.String() + " # reindent\n\n"))
+	if err != nil || len(revs) != 1 || revs[0] != c4.Id.String() {
+		t.Errorf("unexpected ignored revisions %v: %v", revs, err)
+	}
+}
+
+func TestBlameOriginsGuess(t *testing.T) {
+	tests := []struct {
+		old, new []string
+		expected []int
+	}{
+		// replaced lines are matched by similarity, not position
+		{[]string{"keep\n", "alpha one\n", "beta two\n"}, []string{"keep\n", "completely new\n", "beta  two!\n"}, []int{0, -1, 2}},
+		// matches keep the order of the lines
+		{[]string{"aaa\n", "bbb\n"}, []string{"bbbx\n", "aaax\n"}, []int{1, -1}},
+		{[]string{"x = 1\n"}, []string{"X = 1;\n", "y = 2\n"}, []int{0, -1}},
+	}
+	for _, test := range tests {
+		origins := blameOrigins(test.old, test.new, false, true)
+		if fmt.Sprint(origins) != fmt.Sprint(test.expected) {
+			t.Errorf("unexpected origins of %q: %v", test.new, origins)
+		}
+	}
+}
@@ -123,7 +123,7 @@ func (repo *Repository) StoreObjectLoose(
 	objectType ObjectType,
 	r io.ReadSeeker,
 ) (sha1, error) {
-	fd, err := ioutil.TempFile(filepath.Join(repo.Path, "objects"), ".gogit_")
+	fd, err := ioutil.TempFile(filepath.Join(repo.CommonDir, "objects"), ".gogit_")
 	if err != nil {
 		return [20]byte{}, fmt.Errorf("failed to make tmpfile: %v", err)
 	}
@@ -135,7 +135,7 @@ func (repo *Repository) StoreObjectLoose(
 	}
 	fd.Close() // Not deferred, intentionally.
 
-	objectPath := filepathFromSHA1(repo.Path, id.String())
+	objectPath := filepathFromSHA1(repo.CommonDir, id.String())
 	if _, err = os.Stat(objectPath); err == nil {
 		// Object already exists. Delete the temporary file.
 		err = os.Remove(fd.Name())
@@ -0,0 +1,159 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckoutOptions configures Tree.Checkout.
+type CheckoutOptions struct {
+	// Gitlinks creates an empty directory for every submodule, like git
+	// does for submodules which are not initialized. By default no
+	// directory is created for submodules.
+	Gitlinks bool
+
+	// UpdateIndex replaces the index of the repository with the checked
+	// out files, so that dir becomes a clean work tree of the repository.
+	// Submodules are recorded in the index either way, those without a
+	// directory as skip-worktree entries.
+	UpdateIndex bool
+}
+
+// Checkout writes the files of the tree to the directory dir, which is
+// created if needed. Existing files are replaced, other files in dir are
+// left alone.
+func (t *Tree) Checkout(dir string, opts CheckoutOptions) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	var idx *Index
+	if opts.UpdateIndex {
+		idx = NewIndex()
+	}
+
+	err := t.walkFiles("", func(name string, te *TreeEntry) error {
+		if !verifyCheckoutPath(name) {
+			return fmt.Errorf("invalid path %q", name)
+		}
+		if te.mode == ModeCommit && !opts.Gitlinks {
+			if idx != nil {
+				idx.Add(&IndexEntry{Name: name, Id: te.Id, Mode: te.mode, SkipWorktree: true})
+			}
+			return nil
+		}
+
+		if err := mkdirParents(dir, name); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := t.repo.checkoutEntry(path, te); err != nil {
+			return err
+		}
+		if idx == nil {
+			return nil
+		}
+
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		e := &IndexEntry{Name: name, Id: te.Id, Mode: te.mode}
+		if te.mode != ModeCommit {
+			e.setStat(fi)
+		}
+		idx.Add(e)
+		return nil
+	})
+	if err != nil || idx == nil {
+		return err
+	}
+	return t.repo.WriteIndex(idx)
+}
+
+// verifyCheckoutPath rejects paths which would escape the checkout
+// directory or write into a git directory.
+func verifyCheckoutPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		switch {
+		case len(elem) == 0, elem == ".", elem == "..", strings.EqualFold(elem, ".git"):
+			return false
+		}
+	}
+	return true
+}
+
+// mkdirParents creates the leading directories of name below dir. Files and
+// symbolic links in the way are removed, so nothing is written outside of
+// dir.
+func mkdirParents(dir, name string) error {
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		dir = filepath.Join(dir, elem)
+		fi, err := os.Lstat(dir)
+		if err == nil && fi.IsDir() {
+			continue
+		}
+		if err == nil {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Mkdir(dir, 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkoutEntry writes a single blob, symbolic link or submodule directory
+// to path.
+func (repo *Repository) checkoutEntry(path string, te *TreeEntry) error {
+	// replace whatever is in the way, never write through an old link
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.IsDir() && te.mode == ModeCommit {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	if te.mode == ModeCommit {
+		return os.Mkdir(path, 0777)
+	}
+
+	_, _, r, err := repo.getRawObject(te.Id, false)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if te.mode == ModeSymlink {
+		target, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(target), path)
+	}
+
+	perm := os.FileMode(0666)
+	if te.mode == ModeExec {
+		perm = 0777
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,145 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// storeTestTree writes a tree object with the given entries, which must be
+// sorted.
+func storeTestTree(t *testing.T, r *Repository, entries []*TreeEntry) sha1 {
+	var buf bytes.Buffer
+	for _, te := range entries {
+		fmt.Fprintf(&buf, "%o %s\x00", te.mode, te.name)
+		buf.Write(te.Id[:])
+	}
+	id, err := r.StoreObjectLoose(ObjectTree, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestCheckout(t *testing.T) {
+	r := copyTestRepo(t)
+	blob := func(content string) sha1 {
+		id, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	gitlink, _ := NewIdFromString("0123456789abcdef0123456789abcdef01234567")
+	sub := storeTestTree(t, r, []*TreeEntry{
+		{name: "module", mode: ModeCommit, Id: gitlink},
+		{name: "run.sh", mode: ModeExec, Id: blob("#!/bin/sh\n")},
+	})
+	root := storeTestTree(t, r, []*TreeEntry{
+		{name: "file", mode: ModeBlob, Id: blob("content\n")},
+		{name: "link", mode: ModeSymlink, Id: blob("file")},
+		{name: "sub", mode: ModeTree, Id: sub},
+	})
+	tree, err := r.getTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	// a link in the way must be replaced, not followed
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Checkout(dir, CheckoutOptions{Gitlinks: true, UpdateIndex: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, "sub", "run.sh")); err != nil || fi.Mode()&0100 == 0 {
+		t.Fatalf("run.sh not executable: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "file" {
+		t.Fatalf("unexpected link %q: %v", target, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "sub", "module")); err != nil || !fi.IsDir() {
+		t.Fatalf("gitlink directory missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "run.sh")); err == nil {
+		t.Fatal("wrote through symbolic link")
+	}
+
+	idx, err := r.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range idx.Entries {
+		names = append(names, fmt.Sprintf("%o %s", e.Mode, e.Name))
+		path := filepath.Join(dir, filepath.FromSlash(e.Name))
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Mode == ModeCommit {
+			continue
+		}
+		if id, _ := hashWorktreeFile(path, fi); id != e.Id {
+			t.Errorf("%s: id %s, want %s", e.Name, id, e.Id)
+		}
+		if e.Size != uint32(fi.Size()) || !e.MTime.Equal(fi.ModTime()) {
+			t.Errorf("%s: stat data not recorded", e.Name)
+		}
+	}
+	expected := "[100644 file 120000 link 160000 sub/module 100755 sub/run.sh]"
+	if fmt.Sprint(names) != expected {
+		t.Fatalf("unexpected index %v", names)
+	}
+}
+
+func TestCheckoutSkippedGitlinks(t *testing.T) {
+	r, err := InitRepository(t.TempDir(), false, InitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte("content\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitlink, _ := NewIdFromString("0123456789abcdef0123456789abcdef01234567")
+	root := storeTestTree(t, r, []*TreeEntry{
+		{name: "file", mode: ModeBlob, Id: file},
+		{name: "module", mode: ModeCommit, Id: gitlink},
+	})
+	tree, err := r.getTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Checkout(r.WorkTree, CheckoutOptions{UpdateIndex: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.WorkTree, "module")); !os.IsNotExist(err) {
+		t.Fatalf("gitlink directory created: %v", err)
+	}
+
+	idx, err := r.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := idx.Entry("module", 0); e == nil || e.Id != gitlink || !e.SkipWorktree {
+		t.Fatalf("unexpected index entry %+v", e)
+	}
+
+	// the submodule is in the index, but not missing from the work tree
+	status, err := r.Status(StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := status.String(); s != "A  file\nA  module\n" {
+		t.Fatalf("unexpected status:\n%s", s)
+	}
+}
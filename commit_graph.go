@@ -0,0 +1,145 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// generationInfinity is the generation of commits not in the commit-graph.
+// It is larger than any stored one.
+const generationInfinity = ^uint32(0)
+
+var errCommitGraphCorrupt = errors.New("corrupt commit-graph file")
+
+// commitGraphLayer is one commit-graph file. Only the generation numbers
+// are read from it.
+type commitGraphLayer struct {
+	fanout []byte // OIDF chunk
+	ids    []byte // OIDL chunk
+	data   []byte // CDAT chunk
+}
+
+// readCommitGraph reads a commit-graph file, see
+// Documentation/gitformat-commit-graph.txt of git.
+func readCommitGraph(path string) (*commitGraphLayer, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 8 || !bytes.Equal(buf[:4], []byte("CGPH")) || buf[4] != 1 || buf[5] != 1 {
+		return nil, errCommitGraphCorrupt
+	}
+
+	layer := &commitGraphLayer{}
+	chunks := int(buf[6])
+	table := buf[8:]
+	if len(table) < 12*(chunks+1) {
+		return nil, errCommitGraphCorrupt
+	}
+	for i := 0; i < chunks; i++ {
+		entry := table[12*i:]
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[16:])
+		if start > end || end > uint64(len(buf)) {
+			return nil, errCommitGraphCorrupt
+		}
+		switch string(entry[:4]) {
+		case "OIDF":
+			layer.fanout = buf[start:end]
+		case "OIDL":
+			layer.ids = buf[start:end]
+		case "CDAT":
+			layer.data = buf[start:end]
+		}
+	}
+
+	if len(layer.fanout) != 256*4 {
+		return nil, errCommitGraphCorrupt
+	}
+	n := int(binary.BigEndian.Uint32(layer.fanout[255*4:]))
+	if len(layer.ids) != 20*n || len(layer.data) != 36*n {
+		return nil, errCommitGraphCorrupt
+	}
+	return layer, nil
+}
+
+// generation returns the generation number of id, false if the commit is
+// not in this layer.
+func (layer *commitGraphLayer) generation(id sha1) (uint32, bool) {
+	var lo int
+	if id[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(layer.fanout[4*(int(id[0])-1):]))
+	}
+	hi := int(binary.BigEndian.Uint32(layer.fanout[4*int(id[0]):]))
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(layer.ids[20*(lo+i):20*(lo+i+1)], id[:]) >= 0
+	})
+	if i == hi || !bytes.Equal(layer.ids[20*i:20*(i+1)], id[:]) {
+		return 0, false
+	}
+	// the topological level is stored in the upper 30 bits
+	return binary.BigEndian.Uint32(layer.data[36*i+28:]) >> 2, true
+}
+
+// loadCommitGraph reads objects/info/commit-graph or the layers of a split
+// commit-graph. It is not an error if there is none.
+func (repo *Repository) loadCommitGraph() ([]*commitGraphLayer, error) {
+	info := filepath.Join(repo.CommonDir, "objects", "info")
+	layer, err := readCommitGraph(filepath.Join(info, "commit-graph"))
+	if err == nil {
+		return []*commitGraphLayer{layer}, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(info, "commit-graphs", "commit-graph-chain"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var layers []*commitGraphLayer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if len(name) == 0 {
+			continue
+		}
+		layer, err := readCommitGraph(filepath.Join(info, "commit-graphs", "graph-"+name+".graph"))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return layers, scanner.Err()
+}
+
+// generation returns the generation number of the commit from the
+// commit-graph, generationInfinity if it is unknown.
+func (repo *Repository) generation(c *Commit) uint32 {
+	if !repo.commitGraphLoaded {
+		// without a usable commit-graph every commit has an unknown
+		// generation, which only makes walks explore more
+		repo.commitGraph, _ = repo.loadCommitGraph()
+		repo.commitGraphLoaded = true
+	}
+	for _, layer := range repo.commitGraph {
+		if gen, ok := layer.generation(c.Id); ok {
+			if gen == 0 {
+				// written by a git that did not compute them
+				return generationInfinity
+			}
+			return gen
+		}
+	}
+	return generationInfinity
+}
@@ -0,0 +1,54 @@
+package git
+
+// CommitIter returns the commits of a history walk while walking, so the
+// walk can be stopped early:
+//
+//	for it.Next() {
+//		commit := it.Commit()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type CommitIter struct {
+	w      historyWalker
+	commit *Commit
+	err    error
+}
+
+// Next walks to the next commit and reports whether there is one.
+func (it *CommitIter) Next() bool {
+	if it.w == nil {
+		return false
+	}
+	it.commit, it.err = it.w.next()
+	if it.commit == nil {
+		it.w = nil
+		return false
+	}
+	return true
+}
+
+// Commit returns the current commit.
+func (it *CommitIter) Commit() *Commit {
+	return it.commit
+}
+
+// Err returns the error which ended the walk, if any.
+func (it *CommitIter) Err() error {
+	return it.err
+}
+
+// sliceWalker hands out commits which are already known.
+type sliceWalker struct {
+	commits []*Commit
+}
+
+func (w *sliceWalker) next() (*Commit, error) {
+	if len(w.commits) == 0 {
+		return nil, nil
+	}
+	c := w.commits[0]
+	w.commits = w.commits[1:]
+	return c, nil
+}
@@ -0,0 +1,898 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrConfigKeyNotExist = errors.New("config key does not exist")
+	ErrConfigMultiValue  = errors.New("config key has multiple values")
+)
+
+// Git stops following include.path at the same depth.
+const maxConfigIncludeDepth = 10
+
+type ConfigLevel int
+
+const (
+	ConfigSystem ConfigLevel = iota
+	ConfigGlobal
+	ConfigLocal
+)
+
+// ConfigEntry is a single variable assignment in a config file.
+type ConfigEntry struct {
+	Section    string // lower case
+	Subsection string // case sensitive, may be empty
+	Name       string // lower case
+	Value      string
+
+	// Implicit is set for a variable without "=", which is true as a
+	// boolean and empty otherwise.
+	Implicit bool
+
+	Level ConfigLevel
+	File  string
+
+	// position of the assignment in its file, from the name to the end
+	// of the value, and where the value starts; valueStart is zero for
+	// implicit values
+	start, end, valueStart int
+}
+
+// Key returns the full name of the variable, e.g. "remote.origin.url".
+func (e *ConfigEntry) Key() string {
+	if len(e.Subsection) == 0 {
+		return e.Section + "." + e.Name
+	}
+	return e.Section + "." + e.Subsection + "." + e.Name
+}
+
+func (e *ConfigEntry) matches(section, subsection, name string) bool {
+	return e.Section == section && e.Subsection == subsection && e.Name == name
+}
+
+// parseConfigKey splits a key like "branch.master.remote" into its parts.
+// Section and variable name are case insensitive and returned in lower
+// case.
+func parseConfigKey(key string) (section, subsection, name string, err error) {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", fmt.Errorf("invalid config key %q", key)
+	}
+
+	section, name = strings.ToLower(key[:first]), strings.ToLower(key[last+1:])
+	if first < last {
+		subsection = key[first+1 : last]
+	}
+	if !isConfigSectionName(section) || !isConfigName(name) || strings.ContainsAny(subsection, "\n\x00") {
+		return "", "", "", fmt.Errorf("invalid config key %q", key)
+	}
+	return section, subsection, name, nil
+}
+
+func isConfigSectionName(s string) bool {
+	for _, c := range []byte(s) {
+		if !isConfigNameChar(c) && c != '.' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func isConfigName(s string) bool {
+	if len(s) == 0 || !isAlpha(s[0]) {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if !isConfigNameChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isConfigNameChar(c byte) bool {
+	return isAlpha(c) || '0' <= c && c <= '9' || c == '-'
+}
+
+// ParseConfigBool interprets value as a git boolean: "true", "yes", "on"
+// and non-zero numbers are true, "false", "no", "off", "0" and the empty
+// string are false.
+func ParseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	n, err := ParseConfigInt(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", value)
+	}
+	return n != 0, nil
+}
+
+// ParseConfigInt interprets value as a git integer, which may end in one of
+// the unit suffixes "k", "m" or "g".
+func ParseConfigInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	var unit int64 = 1
+	if len(value) > 0 {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", value)
+	}
+	if n > (1<<63-1)/unit || n < -(1<<63)/unit {
+		return 0, fmt.Errorf("integer %q out of range", value)
+	}
+	return n * unit, nil
+}
+
+// configSection is a section header in a config file.
+type configSection struct {
+	name, subsection string
+	start, end       int // the header itself
+}
+
+// configParser parses a config file, remembering where each header and
+// assignment is so the file can be edited in place.
+type configParser struct {
+	file     string
+	data     []byte
+	pos      int
+	line     int
+	sections []*configSection
+	entries  []*ConfigEntry
+}
+
+func parseConfig(file string, data []byte) ([]*configSection, []*ConfigEntry, error) {
+	p := &configParser{file: file, data: data, line: 1}
+	if err := p.parse(); err != nil {
+		return nil, nil, err
+	}
+	return p.sections, p.entries, nil
+}
+
+func (p *configParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("bad config line %d in file %s: %s", p.line, p.file, fmt.Sprintf(format, args...))
+}
+
+func (p *configParser) next() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c, true
+}
+
+func (p *configParser) skipLine() {
+	for {
+		if c, ok := p.next(); !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+func (p *configParser) parse() error {
+	// skip a UTF-8 byte order mark
+	if bytes.HasPrefix(p.data, []byte("\xef\xbb\xbf")) {
+		p.pos = 3
+	}
+
+	var section *configSection
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.next()
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			s, err := p.parseHeader()
+			if err != nil {
+				return err
+			}
+			section = s
+			p.sections = append(p.sections, s)
+		case isAlpha(c):
+			if section == nil {
+				return p.errorf("variable outside of a section")
+			}
+			entry, err := p.parseVariable()
+			if err != nil {
+				return err
+			}
+			entry.Section, entry.Subsection = section.name, section.subsection
+			p.entries = append(p.entries, entry)
+		default:
+			return p.errorf("unexpected character %q", c)
+		}
+	}
+	return nil
+}
+
+// parseHeader parses "[section]", `[section "subsection"]` or the
+// deprecated "[section.subsection]".
+func (p *configParser) parseHeader() (*configSection, error) {
+	s := &configSection{start: p.pos}
+	p.next()
+
+	nameStart := p.pos
+	for p.pos < len(p.data) && (isConfigNameChar(p.data[p.pos]) || p.data[p.pos] == '.') {
+		p.pos++
+	}
+	s.name = strings.ToLower(string(p.data[nameStart:p.pos]))
+	if dot := strings.IndexByte(s.name, '.'); dot >= 0 {
+		s.name, s.subsection = s.name[:dot], s.name[dot+1:]
+	}
+	if len(s.name) == 0 {
+		return nil, p.errorf("invalid section name")
+	}
+
+	c, _ := p.next()
+	if c == ' ' || c == '\t' {
+		for c == ' ' || c == '\t' {
+			c, _ = p.next()
+		}
+		if c != '"' || len(s.subsection) > 0 {
+			return nil, p.errorf("invalid section header")
+		}
+
+		var sub []byte
+		for {
+			c, ok := p.next()
+			if !ok || c == '\n' {
+				return nil, p.errorf("unterminated subsection")
+			}
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				// any escaped character stands for itself
+				if c, ok = p.next(); !ok || c == '\n' {
+					return nil, p.errorf("unterminated subsection")
+				}
+			}
+			sub = append(sub, c)
+		}
+		s.subsection = string(sub)
+		c, _ = p.next()
+	}
+
+	if c != ']' {
+		return nil, p.errorf("invalid section header")
+	}
+	s.end = p.pos
+	return s, nil
+}
+
+func (p *configParser) parseVariable() (*ConfigEntry, error) {
+	entry := &ConfigEntry{File: p.file, start: p.pos}
+	for p.pos < len(p.data) && isConfigNameChar(p.data[p.pos]) {
+		p.pos++
+	}
+	entry.Name = strings.ToLower(string(p.data[entry.start:p.pos]))
+	entry.end = p.pos
+
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos == len(p.data) {
+		entry.Implicit = true
+		return entry, nil
+	}
+	switch p.data[p.pos] {
+	case '=':
+		p.pos++
+		for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+			p.pos++
+		}
+		entry.valueStart, entry.end = p.pos, p.pos
+		return entry, p.parseValue(entry)
+	case '\r', '\n', '#', ';':
+		entry.Implicit = true
+		return entry, nil
+	}
+	return nil, p.errorf("invalid variable name")
+}
+
+// parseValue reads the rest of an assignment after the "=". Unquoted
+// whitespace at either end is dropped, quotes and escapes are removed and
+// a backslash at the end of a line continues the value on the next one.
+func (p *configParser) parseValue(entry *ConfigEntry) error {
+	var value []byte
+	quoted := false
+	spaces := 0
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			if quoted {
+				return p.errorf("unterminated quote")
+			}
+			break
+		}
+		if !quoted && (c == '#' || c == ';') {
+			p.skipLine()
+			break
+		}
+		if !quoted && (c == ' ' || c == '\t' || c == '\r') {
+			if len(value) > 0 {
+				spaces++
+			}
+			continue
+		}
+
+		for ; spaces > 0; spaces-- {
+			value = append(value, ' ')
+		}
+		entry.end = p.pos
+
+		switch c {
+		case '"':
+			quoted = !quoted
+			continue
+		case '\\':
+			c, ok = p.next()
+			switch c {
+			case '\n':
+				continue
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case '\\', '"':
+			default:
+				return p.errorf("invalid escape sequence")
+			}
+			entry.end = p.pos
+		}
+		value = append(value, c)
+	}
+
+	entry.Value = string(value)
+	return nil
+}
+
+// encodeConfigValue quotes and escapes value so that it parses back
+// unchanged.
+func encodeConfigValue(value string) string {
+	quote := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\b", `\b`).Replace(value)
+	if quote {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+func encodeConfigHeader(section, subsection string) string {
+	if len(subsection) == 0 {
+		return "[" + section + "]"
+	}
+	return "[" + section + " \"" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection) + "\"]"
+}
+
+// ConfigFile is a single config file which can be edited without losing
+// comments or formatting.
+type ConfigFile struct {
+	Path  string
+	Level ConfigLevel
+
+	data     []byte
+	sections []*configSection
+	entries  []*ConfigEntry
+}
+
+// OpenConfigFile reads the config file at path. A missing file is treated
+// as empty, so it is created on Save.
+func OpenConfigFile(path string) (*ConfigFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f := &ConfigFile{Path: path, Level: ConfigLocal}
+	return f, f.setData(data)
+}
+
+func (f *ConfigFile) setData(data []byte) error {
+	sections, entries, err := parseConfig(f.Path, data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entry.Level = f.Level
+	}
+	f.data, f.sections, f.entries = data, sections, entries
+	return nil
+}
+
+// Entries returns all assignments in the file, in order. Includes are not
+// followed.
+func (f *ConfigFile) Entries() []*ConfigEntry {
+	return f.entries
+}
+
+// Get returns the last value of key in the file.
+func (f *ConfigFile) Get(key string) (string, error) {
+	return (&Config{Entries: f.entries}).Get(key)
+}
+
+// Bytes returns the current content of the file.
+func (f *ConfigFile) Bytes() []byte {
+	return f.data
+}
+
+func (f *ConfigFile) splice(start, end int, s string) []byte {
+	data := make([]byte, 0, len(f.data)-(end-start)+len(s))
+	data = append(data, f.data[:start]...)
+	data = append(data, s...)
+	return append(data, f.data[end:]...)
+}
+
+// lineEnd returns the position after the newline ending the line pos is
+// in.
+func (f *ConfigFile) lineEnd(pos int) int {
+	if i := bytes.IndexByte(f.data[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+	return len(f.data)
+}
+
+// Set sets key to value. Only the value of an existing assignment is
+// replaced, the spelling of its name is kept. ErrConfigMultiValue is
+// returned if the key has several values, use ReplaceAll for those. A new
+// key is added to the end of the last matching section, or to a new
+// section at the end of the file.
+func (f *ConfigFile) Set(key, value string) error {
+	section, subsection, name, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	matched := f.matching(section, subsection, name)
+	switch len(matched) {
+	case 0:
+		return f.add(section, subsection, name, value)
+	case 1:
+		return f.setData(f.replaceValue(matched[0], value))
+	}
+	return ErrConfigMultiValue
+}
+
+// ReplaceAll sets key to value, replacing all of its values: all but the
+// last are removed and the last one is replaced in place.
+func (f *ConfigFile) ReplaceAll(key, value string) error {
+	section, subsection, name, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	matched := f.matching(section, subsection, name)
+	if len(matched) == 0 {
+		return f.add(section, subsection, name, value)
+	}
+
+	f.data = f.replaceValue(matched[len(matched)-1], value)
+	for i := len(matched) - 2; i >= 0; i-- {
+		start, end := f.removalRange(matched[i])
+		f.data = f.splice(start, end, "")
+	}
+	return f.setData(f.data)
+}
+
+func (f *ConfigFile) matching(section, subsection, name string) []*ConfigEntry {
+	var matched []*ConfigEntry
+	for _, entry := range f.entries {
+		if entry.matches(section, subsection, name) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// replaceValue returns the data with the value of entry replaced.
+func (f *ConfigFile) replaceValue(entry *ConfigEntry, value string) []byte {
+	if entry.Implicit {
+		return f.splice(entry.end, entry.end, " = "+encodeConfigValue(value))
+	}
+	return f.splice(entry.valueStart, entry.end, encodeConfigValue(value))
+}
+
+// Add adds another value to the multi-valued key.
+func (f *ConfigFile) Add(key, value string) error {
+	section, subsection, name, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	return f.add(section, subsection, name, value)
+}
+
+func (f *ConfigFile) add(section, subsection, name, value string) error {
+	line := "\t" + name + " = " + encodeConfigValue(value) + "\n"
+
+	// append after the last assignment of the last matching section
+	pos := -1
+	for _, s := range f.sections {
+		if s.name == section && s.subsection == subsection {
+			pos = f.lineEnd(s.end)
+		}
+	}
+	if pos >= 0 {
+		for _, entry := range f.entries {
+			if entry.Section == section && entry.Subsection == subsection && entry.start >= pos {
+				pos = f.lineEnd(entry.end)
+			}
+		}
+		if pos > 0 && f.data[pos-1] != '\n' {
+			line = "\n" + line
+		}
+		return f.setData(f.splice(pos, pos, line))
+	}
+
+	var add string
+	if len(f.data) > 0 && f.data[len(f.data)-1] != '\n' {
+		add = "\n"
+	}
+	add += encodeConfigHeader(section, subsection) + "\n" + line
+	return f.setData(f.splice(len(f.data), len(f.data), add))
+}
+
+// Unset removes every value of key.
+func (f *ConfigFile) Unset(key string) error {
+	section, subsection, name, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := len(f.entries) - 1; i >= 0; i-- {
+		if entry := f.entries[i]; entry.matches(section, subsection, name) {
+			start, end := f.removalRange(entry)
+			f.data = f.splice(start, end, "")
+			found = true
+		}
+	}
+	if !found {
+		return ErrConfigKeyNotExist
+	}
+	return f.setData(f.data)
+}
+
+// removalRange returns what to cut out of the file to remove entry: its
+// whole line if nothing else is on it, otherwise only the assignment.
+func (f *ConfigFile) removalRange(entry *ConfigEntry) (int, int) {
+	start := bytes.LastIndexByte(f.data[:entry.start], '\n') + 1
+	end := f.lineEnd(entry.end)
+
+	before := bytes.TrimSpace(f.data[start:entry.start])
+	after := bytes.TrimSpace(f.data[entry.end:end])
+	if len(before) == 0 && (len(after) == 0 || after[0] == '#' || after[0] == ';') {
+		return start, end
+	}
+	return entry.start, entry.end
+}
+
+// RemoveSection removes every section called name with the given
+// subsection, together with its variables and the comments inside it.
+func (f *ConfigFile) RemoveSection(name, subsection string) error {
+	name = strings.ToLower(name)
+	found := false
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		s := f.sections[i]
+		if s.name != name || s.subsection != subsection {
+			continue
+		}
+		end := len(f.data)
+		if i+1 < len(f.sections) {
+			end = f.sections[i+1].start
+		}
+		start := bytes.LastIndexByte(f.data[:s.start], '\n') + 1
+		if len(bytes.TrimSpace(f.data[start:s.start])) > 0 {
+			start = s.start
+		}
+		f.data = f.splice(start, end, "")
+		found = true
+	}
+	if !found {
+		return ErrConfigKeyNotExist
+	}
+	return f.setData(f.data)
+}
+
+// Save writes the file back under a lock.
+func (f *ConfigFile) Save() error {
+	lock, err := newLockFile(f.Path)
+	if err != nil {
+		return err
+	}
+	if err = lock.Write(f.data); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// Config is the combined configuration of all levels, with includes
+// resolved. Later entries override earlier ones.
+type Config struct {
+	Entries []*ConfigEntry
+}
+
+// Get returns the last value of key.
+func (c *Config) Get(key string) (string, error) {
+	entry, err := c.entry(key)
+	if err != nil {
+		return "", err
+	}
+	return entry.Value, nil
+}
+
+func (c *Config) entry(key string) (*ConfigEntry, error) {
+	section, subsection, name, err := parseConfigKey(key)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		if c.Entries[i].matches(section, subsection, name) {
+			return c.Entries[i], nil
+		}
+	}
+	return nil, ErrConfigKeyNotExist
+}
+
+// GetAll returns every value of the multi-valued key, in order.
+func (c *Config) GetAll(key string) []string {
+	section, subsection, name, err := parseConfigKey(key)
+	if err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, entry := range c.Entries {
+		if entry.matches(section, subsection, name) {
+			values = append(values, entry.Value)
+		}
+	}
+	return values
+}
+
+// GetBool returns the last value of key as a boolean.
+func (c *Config) GetBool(key string) (bool, error) {
+	entry, err := c.entry(key)
+	if err != nil {
+		return false, err
+	}
+	if entry.Implicit {
+		return true, nil
+	}
+	return ParseConfigBool(entry.Value)
+}
+
+// GetInt returns the last value of key as an integer.
+func (c *Config) GetInt(key string) (int64, error) {
+	entry, err := c.entry(key)
+	if err != nil {
+		return 0, err
+	}
+	return ParseConfigInt(entry.Value)
+}
+
+// Subsections returns the distinct subsections of section in order of
+// appearance, e.g. the names of all remotes for "remote".
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	seen := make(map[string]bool)
+	var subs []string
+	for _, entry := range c.Entries {
+		if entry.Section == section && len(entry.Subsection) > 0 && !seen[entry.Subsection] {
+			seen[entry.Subsection] = true
+			subs = append(subs, entry.Subsection)
+		}
+	}
+	return subs
+}
+
+// SystemConfigPath returns the path of the system wide config file.
+func SystemConfigPath() string {
+	if path := os.Getenv("GIT_CONFIG_SYSTEM"); len(path) > 0 {
+		return path
+	}
+	return "/etc/gitconfig"
+}
+
+// GlobalConfigPaths returns the paths of the user's config files, the XDG
+// one first.
+func GlobalConfigPaths() []string {
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); len(path) > 0 {
+		return []string{path}
+	}
+
+	var paths []string
+	home := os.Getenv("HOME")
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); len(xdg) > 0 {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	} else if len(home) > 0 {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+	}
+	if len(home) > 0 {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// LoadConfig reads the system, global and repository config of the git
+// directory gitDir, following includes. Missing files are skipped; the
+// system config is skipped if GIT_CONFIG_NOSYSTEM is set.
+func LoadConfig(gitDir string) (*Config, error) {
+	if len(gitDir) == 0 {
+		return loadConfig("", "")
+	}
+	return loadConfig(gitDir, readCommonDir(gitDir))
+}
+
+// loadConfig is LoadConfig for a work tree whose common directory is
+// commonDir, where the repository config is kept.
+func loadConfig(gitDir, commonDir string) (*Config, error) {
+	l := &configLoader{gitDir: gitDir}
+
+	if noSystem, _ := ParseConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); !noSystem {
+		if err := l.load(SystemConfigPath(), ConfigSystem, 0); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range GlobalConfigPaths() {
+		if err := l.load(path, ConfigGlobal, 0); err != nil {
+			return nil, err
+		}
+	}
+	if len(commonDir) > 0 {
+		if err := l.load(filepath.Join(commonDir, "config"), ConfigLocal, 0); err != nil {
+			return nil, err
+		}
+	}
+	return &Config{Entries: l.entries}, nil
+}
+
+// Config returns the configuration of the repository, including the global
+// and system config.
+func (repo *Repository) Config() (*Config, error) {
+	return loadConfig(repo.Path, repo.CommonDir)
+}
+
+// LocalConfig opens the config file of the repository for editing.
+func (repo *Repository) LocalConfig() (*ConfigFile, error) {
+	return OpenConfigFile(filepath.Join(repo.CommonDir, "config"))
+}
+
+type configLoader struct {
+	gitDir  string
+	entries []*ConfigEntry
+}
+
+func (l *configLoader) load(path string, level ConfigLevel, depth int) error {
+	if depth > maxConfigIncludeDepth {
+		return fmt.Errorf("exceeded maximum include depth (%d) while including %s", maxConfigIncludeDepth, path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	_, entries, err := parseConfig(path, data)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entry.Level = level
+		l.entries = append(l.entries, entry)
+
+		if entry.Name != "path" || entry.Implicit || len(entry.Value) == 0 {
+			continue
+		}
+		include := entry.Section == "include" && len(entry.Subsection) == 0
+		if entry.Section == "includeif" {
+			if include, err = l.includeCondition(entry.Subsection, path); err != nil {
+				return err
+			}
+		}
+		if include {
+			if err := l.load(l.includePath(entry.Value, path), level, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includePath resolves an included path, which may start with "~/" or be
+// relative to the including file.
+func (l *configLoader) includePath(path, from string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(filepath.Dir(from), path)
+	}
+	return path
+}
+
+// includeCondition evaluates the condition of an includeIf section. The
+// conditions "gitdir:", "gitdir/i:" and "onbranch:" are supported, any
+// other condition is false.
+func (l *configLoader) includeCondition(cond, from string) (bool, error) {
+	colon := strings.IndexByte(cond, ':')
+	if colon < 0 || len(l.gitDir) == 0 {
+		return false, nil
+	}
+	kind, pattern := cond[:colon], cond[colon+1:]
+
+	switch kind {
+	case "gitdir", "gitdir/i":
+		if strings.HasPrefix(pattern, "~/") {
+			pattern = filepath.ToSlash(os.Getenv("HOME")) + pattern[1:]
+		} else if strings.HasPrefix(pattern, "./") {
+			pattern = filepath.ToSlash(filepath.Dir(from)) + pattern[1:]
+		} else if !strings.HasPrefix(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+
+		gitDir, err := filepath.Abs(l.gitDir)
+		if err != nil {
+			return false, err
+		}
+		gitDir = filepath.ToSlash(gitDir)
+		if kind == "gitdir/i" {
+			pattern, gitDir = strings.ToLower(pattern), strings.ToLower(gitDir)
+		}
+		return wildmatch(pattern, gitDir), nil
+
+	case "onbranch":
+		head, err := openRefStore(l.gitDir).Ref("HEAD")
+		if err != nil || !strings.HasPrefix(head.Target, "refs/heads/") {
+			return false, nil
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return wildmatch(pattern, strings.TrimPrefix(head.Target, "refs/heads/")), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,170 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `# top comment
+[core]
+	bare = true ; trailing comment
+	autocrlf
+	bigFileThreshold = 2m
+[remote "origin"]
+	url = "https://example.com/repo.git"
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[user]
+	name = "  Jane \"JD\" Doe"  # comment
+	note = line one\n\tline two \
+continued
+[Branch.Master]
+	remote = origin
+`
+
+func TestConfigParse(t *testing.T) {
+	_, entries, err := parseConfig("config", []byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Entries: entries}
+
+	if bare, err := cfg.GetBool("core.bare"); err != nil || !bare {
+		t.Fatalf("core.bare = %v, %v", bare, err)
+	}
+	if crlf, err := cfg.GetBool("Core.AutoCRLF"); err != nil || !crlf {
+		t.Fatalf("implicit boolean = %v, %v", crlf, err)
+	}
+	if n, err := cfg.GetInt("core.bigfilethreshold"); err != nil || n != 2<<20 {
+		t.Fatalf("core.bigFileThreshold = %v, %v", n, err)
+	}
+	if fetch := cfg.GetAll("remote.origin.fetch"); len(fetch) != 2 || fetch[1] != "+refs/tags/*:refs/tags/*" {
+		t.Fatalf("unexpected multivar %q", fetch)
+	}
+	if name, _ := cfg.Get("user.name"); name != `  Jane "JD" Doe` {
+		t.Fatalf("unexpected user.name %q", name)
+	}
+	if note, _ := cfg.Get("user.note"); note != "line one\n\tline two continued" {
+		t.Fatalf("unexpected user.note %q", note)
+	}
+	if remote, _ := cfg.Get("branch.master.remote"); remote != "origin" {
+		t.Fatalf("legacy subsection not parsed: %q", remote)
+	}
+	if subs := cfg.Subsections("remote"); len(subs) != 1 || subs[0] != "origin" {
+		t.Fatalf("unexpected subsections %q", subs)
+	}
+	if _, err := cfg.Get("core.missing"); err != ErrConfigKeyNotExist {
+		t.Fatalf("expected ErrConfigKeyNotExist, got %v", err)
+	}
+
+	if _, _, err := parseConfig("config", []byte("[core]\n\tbare = \"true\n")); err == nil {
+		t.Fatal("unterminated quote accepted")
+	}
+}
+
+func TestConfigEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("remote.origin.fetch", "+refs/heads/main:refs/remotes/origin/main"); err != ErrConfigMultiValue {
+		t.Fatalf("expected ErrConfigMultiValue, got %v", err)
+	}
+	steps := []error{
+		f.Set("core.bare", "false"),
+		f.Set("core.bigfilethreshold", "4m"),
+		f.Add("remote.origin.fetch", "+refs/notes/*:refs/notes/*"),
+		f.Unset("core.autocrlf"),
+		f.Set("user.email", "jane@example.com"),
+		f.Set("remote.upstream.url", "git@example.com:x.git"),
+		f.RemoveSection("branch", "master"),
+		f.Save(),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# top comment
+[core]
+	bare = false ; trailing comment
+	bigFileThreshold = 4m
+[remote "origin"]
+	url = "https://example.com/repo.git"
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+	fetch = +refs/notes/*:refs/notes/*
+[user]
+	name = "  Jane \"JD\" Doe"  # comment
+	note = line one\n\tline two \
+continued
+	email = jane@example.com
+[remote "upstream"]
+	url = git@example.com:x.git
+`
+	if string(data) != expected {
+		t.Fatalf("unexpected config:\n%s", data)
+	}
+}
+
+func TestConfigReplaceAll(t *testing.T) {
+	f := &ConfigFile{Path: "config"}
+	data := "[remote \"origin\"]\n\tFetch = a\n\tfetch=b\n\tprune\n"
+	if err := f.setData([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ReplaceAll("remote.origin.fetch", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("remote.origin.prune", "false"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[remote \"origin\"]\n\tfetch=c\n\tprune = false\n"; string(f.Bytes()) != expected {
+		t.Fatalf("unexpected config:\n%s", f.Bytes())
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, "work", "repo.git")
+	files := map[string]string{
+		filepath.Join(gitDir, "config"): "[include]\n\tpath = ../extra\n[includeIf \"gitdir:work/\"]\n\tpath = " +
+			filepath.Join(dir, "work.inc") + "\n[includeIf \"gitdir:other/\"]\n\tpath = " + filepath.Join(dir, "other.inc") + "\n",
+		filepath.Join(dir, "work", "extra"): "[user]\n\tname = Extra\n",
+		filepath.Join(dir, "work.inc"):      "[user]\n\temail = work@example.com\n",
+		filepath.Join(dir, "other.inc"):     "[user]\n\temail = other@example.com\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, "missing"))
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := cfg.Get("user.name"); name != "Extra" {
+		t.Fatalf("include.path not followed: %q", name)
+	}
+	if email, _ := cfg.Get("user.email"); email != "work@example.com" {
+		t.Fatalf("includeIf not evaluated: %q", email)
+	}
+}
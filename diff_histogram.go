@@ -0,0 +1,91 @@
+package git
+
+// histogramMaxChain is the number of occurrences above which a line is not
+// used to anchor a histogram diff, like in git.
+const histogramMaxChain = 64
+
+// histogram anchors the comparison at the longest common region around the
+// lines which occur least often in a, then compares the ranges before and
+// after it. It falls back to Myers' algorithm if all common lines are too
+// frequent.
+func (d *lineDiffer) histogram(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi || bLo < bHi {
+		if aLo == aHi || bLo == bHi {
+			d.mark(aLo, aHi, bLo, bHi)
+			return
+		}
+
+		as, ae, bs, found, common := d.histogramRegion(aLo, aHi, bLo, bHi)
+		switch {
+		case !found && common:
+			d.myers(aLo, aHi, bLo, bHi)
+			return
+		case !found:
+			d.mark(aLo, aHi, bLo, bHi)
+			return
+		}
+
+		d.histogram(aLo, as, bLo, bs)
+		aLo, bLo = ae, bs+(ae-as)
+	}
+}
+
+// histogramRegion finds the common region [as, ae) of a starting at bs in
+// b. common reports whether there are common lines at all.
+func (d *lineDiffer) histogramRegion(aLo, aHi, bLo, bHi int) (as, ae, bs int, found, common bool) {
+	// the positions of each line in a, and how often it occurs
+	positions := make(map[int][]int)
+	for i := aLo; i < aHi; i++ {
+		positions[d.a[i]] = append(positions[d.a[i]], i)
+	}
+	count := func(i int) int {
+		return len(positions[d.a[i]])
+	}
+
+	bestCount := histogramMaxChain + 1
+	bestLen := -1
+	for j := bLo; j < bHi; {
+		next := j + 1
+		occurrences := positions[d.b[j]]
+		if len(occurrences) > bestCount {
+			common = common || len(occurrences) > 0
+			j = next
+			continue
+		}
+
+		for k := 0; k < len(occurrences); {
+			common = true
+			s, t := occurrences[k], j
+			e, u := s+1, t+1
+			rc := len(occurrences)
+			for s > aLo && t > bLo && d.a[s-1] == d.b[t-1] {
+				s--
+				t--
+				if rc > 1 && count(s) < rc {
+					rc = count(s)
+				}
+			}
+			for e < aHi && u < bHi && d.a[e] == d.b[u] {
+				if rc > 1 && count(e) < rc {
+					rc = count(e)
+				}
+				e++
+				u++
+			}
+			if u > next {
+				next = u
+			}
+			if bestLen < e-s || rc < bestCount {
+				as, ae, bs, bestLen, bestCount = s, e, t, e-s, rc
+				found = true
+			}
+
+			// skip the occurrences inside the region
+			for k < len(occurrences) && occurrences[k] < e {
+				k++
+			}
+		}
+		j = next
+	}
+	return
+}
@@ -0,0 +1,481 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+)
+
+// DiffAlgorithm selects how the lines of two files are matched.
+type DiffAlgorithm int
+
+const (
+	DiffMyers DiffAlgorithm = iota
+	DiffPatience
+	DiffHistogram
+)
+
+const (
+	// DefaultDiffContext is the number of context lines of git diff.
+	DefaultDiffContext = 3
+
+	// like git, only this many bytes are checked for a NUL to detect binary
+	// content
+	binaryCheckSize = 8000
+)
+
+// LineDiffOptions configures DiffBytes and DiffBlobs.
+type LineDiffOptions struct {
+	Algorithm DiffAlgorithm
+
+	// Context is the number of unchanged lines around changes.
+	// DefaultDiffContext is used if zero, a negative value means none.
+	Context int
+
+	IgnoreAllSpace    bool // git diff -w
+	IgnoreSpaceChange bool // git diff -b
+	IgnoreSpaceAtEOL  bool // git diff --ignore-space-at-eol
+}
+
+// DiffOp is the kind of a line in a hunk, its prefix in a unified diff.
+type DiffOp byte
+
+const (
+	DiffEqual  DiffOp = ' '
+	DiffDelete DiffOp = '-'
+	DiffInsert DiffOp = '+'
+)
+
+// HunkLine is a line of a hunk. Content includes the line ending, which the
+// last line of a file may lack.
+type HunkLine struct {
+	Op      DiffOp
+	Content string
+}
+
+// Hunk is a group of changes with their context. Starts are 1-based line
+// numbers; for an empty side they are the line before the hunk.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []HunkLine
+
+	// Section is the closest line before the hunk which starts with a
+	// letter, "_" or "$", like a function definition. git diff shows it
+	// after the hunk header.
+	Section string
+}
+
+// BlobDiff is the line diff of two files.
+type BlobDiff struct {
+	Binary bool // no hunks are computed for binary files
+	Hunks  []*Hunk
+}
+
+// Stats returns the number of added and deleted lines.
+func (d *BlobDiff) Stats() (added, deleted int) {
+	for _, h := range d.Hunks {
+		for _, l := range h.Lines {
+			switch l.Op {
+			case DiffInsert:
+				added++
+			case DiffDelete:
+				deleted++
+			}
+		}
+	}
+	return
+}
+
+// isBinary reports whether data looks binary to git.
+func isBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// DiffBlobs compares the blobs oldIdStr and newIdStr. An empty id stands for
+// an empty file.
+func (repo *Repository) DiffBlobs(oldIdStr, newIdStr string, opts LineDiffOptions) (*BlobDiff, error) {
+	var ids [2]sha1
+	for i, idStr := range []string{oldIdStr, newIdStr} {
+		if len(idStr) == 0 {
+			continue
+		}
+		id, err := NewIdFromString(idStr)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return repo.diffBlobs(ids[0], ids[1], opts)
+}
+
+func (repo *Repository) diffBlobs(oldId, newId sha1, opts LineDiffOptions) (*BlobDiff, error) {
+	oldData, err := repo.blobData(oldId)
+	if err != nil {
+		return nil, err
+	}
+	newData, err := repo.blobData(newId)
+	if err != nil {
+		return nil, err
+	}
+	return DiffBytes(oldData, newData, opts), nil
+}
+
+// blobData reads a blob, the zero id is empty.
+func (repo *Repository) blobData(id sha1) ([]byte, error) {
+	if id == (sha1{}) {
+		return nil, nil
+	}
+	_, _, rc, err := repo.getRawObject(id, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// DiffBytes compares the lines of two files.
+func DiffBytes(oldData, newData []byte, opts LineDiffOptions) *BlobDiff {
+	if isBinary(oldData) || isBinary(newData) {
+		return &BlobDiff{Binary: !bytes.Equal(oldData, newData)}
+	}
+
+	oldLines, newLines := splitLines(oldData), splitLines(newData)
+	oldChanged, newChanged := diffLines(oldLines, newLines, opts)
+
+	context := opts.Context
+	if context == 0 {
+		context = DefaultDiffContext
+	} else if context < 0 {
+		context = 0
+	}
+	return &BlobDiff{Hunks: makeHunks(oldLines, newLines, oldChanged, newChanged, context)}
+}
+
+// diffLines marks the lines which differ between both files.
+func diffLines(oldLines, newLines []string, opts LineDiffOptions) (oldChanged, newChanged []bool) {
+	a, b := internLines(oldLines, newLines, opts)
+
+	oldChanged = make([]bool, len(a))
+	newChanged = make([]bool, len(b))
+	d := &lineDiffer{a: a, b: b, ca: oldChanged, cb: newChanged}
+	switch opts.Algorithm {
+	case DiffPatience:
+		d.patience(0, len(a), 0, len(b))
+	case DiffHistogram:
+		d.histogram(0, len(a), 0, len(b))
+	default:
+		d.myers(0, len(a), 0, len(b))
+	}
+	compactChanges(a, oldChanged, b, newChanged)
+	compactChanges(b, newChanged, a, oldChanged)
+	return oldChanged, newChanged
+}
+
+// splitLines splits data after each newline.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i:]
+	}
+	return lines
+}
+
+// internLines numbers the lines of both files such that lines which are
+// equal under the whitespace options get the same number.
+func internLines(oldLines, newLines []string, opts LineDiffOptions) ([]int, []int) {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		r := make([]int, len(lines))
+		for i, line := range lines {
+			key := whitespaceKey(line, opts)
+			id, ok := ids[key]
+			if !ok {
+				id = len(ids)
+				ids[key] = id
+			}
+			r[i] = id
+		}
+		return r
+	}
+	return intern(oldLines), intern(newLines)
+}
+
+func isDiffSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// whitespaceKey returns the line as it is compared. Without whitespace
+// options the newline is kept, so that a missing newline at the end of the
+// file is a change. Like git, any whitespace option ignores it.
+func whitespaceKey(line string, opts LineDiffOptions) string {
+	if !opts.IgnoreAllSpace && !opts.IgnoreSpaceChange && !opts.IgnoreSpaceAtEOL {
+		return line
+	}
+	line = strings.TrimSuffix(line, "\n")
+
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if !isDiffSpace(c) {
+			b.WriteByte(c)
+			continue
+		}
+		j := i
+		for j < len(line) && isDiffSpace(line[j]) {
+			j++
+		}
+		switch {
+		case j == len(line), opts.IgnoreAllSpace:
+			// trailing or ignored whitespace
+		case opts.IgnoreSpaceChange:
+			b.WriteByte(' ')
+		default:
+			b.WriteString(line[i:j])
+		}
+		i = j - 1
+	}
+	return b.String()
+}
+
+// lineDiffer marks the lines of a and b which are not part of the common
+// subsequence found by one of the algorithms.
+type lineDiffer struct {
+	a, b   []int
+	ca, cb []bool
+}
+
+// mark marks the ranges as changed.
+func (d *lineDiffer) mark(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.ca[i] = true
+	}
+	for j := bLo; j < bHi; j++ {
+		d.cb[j] = true
+	}
+}
+
+// changeGroup is a run of changed lines [start, end) of one file. Between
+// any two unchanged lines there is a possibly empty group, the groups of
+// both files correspond to each other.
+type changeGroup struct {
+	lines   []int
+	changed []bool
+	start   int
+	end     int
+}
+
+func newChangeGroup(lines []int, changed []bool) *changeGroup {
+	g := &changeGroup{lines: lines, changed: changed}
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return g
+}
+
+func (g *changeGroup) next() bool {
+	if g.end == len(g.lines) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for g.end < len(g.changed) && g.changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+func (g *changeGroup) previous() bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	g.start = g.end
+	for g.start > 0 && g.changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+// slideDown moves the group one line down if the line after it equals its
+// first line, merging it with the group which follows.
+func (g *changeGroup) slideDown() bool {
+	if g.end == len(g.lines) || g.lines[g.start] != g.lines[g.end] {
+		return false
+	}
+	g.changed[g.start], g.changed[g.end] = false, true
+	g.start++
+	g.end++
+	for g.end < len(g.changed) && g.changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+func (g *changeGroup) slideUp() bool {
+	if g.start == 0 || g.lines[g.start-1] != g.lines[g.end-1] {
+		return false
+	}
+	g.start--
+	g.end--
+	g.changed[g.start], g.changed[g.end] = true, false
+	for g.start > 0 && g.changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+// compactChanges moves the groups of changed lines of a file, which may be
+// placed anywhere among equal lines, to where git shows them: aligned with
+// a change in the other file if possible, else as far down as possible. The
+// indent heuristic of git is not applied.
+func compactChanges(lines []int, changed []bool, otherLines []int, otherChanged []bool) {
+	g := newChangeGroup(lines, changed)
+	o := newChangeGroup(otherLines, otherChanged)
+	for {
+		if g.end != g.start {
+			var size, earliestEnd int
+			endMatchingOther := -1
+			// sliding may merge groups, repeat until the group is stable
+			for size != g.end-g.start {
+				size = g.end - g.start
+				endMatchingOther = -1
+				for g.slideUp() {
+					o.previous()
+				}
+				earliestEnd = g.end
+				if o.end > o.start {
+					endMatchingOther = g.end
+				}
+				for g.slideDown() {
+					o.next()
+					if o.end > o.start {
+						endMatchingOther = g.end
+					}
+				}
+			}
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				for o.end == o.start {
+					g.slideUp()
+					o.previous()
+				}
+			}
+		}
+		if !g.next() {
+			return
+		}
+		o.next()
+	}
+}
+
+// makeHunks groups the changes with context lines into hunks. Changes which
+// are separated by at most twice the context share a hunk.
+func makeHunks(oldLines, newLines []string, oldChanged, newChanged []bool, context int) []*Hunk {
+	var ops []HunkLine
+	for i, j := 0, 0; i < len(oldLines) || j < len(newLines); {
+		switch {
+		case i < len(oldLines) && oldChanged[i]:
+			ops = append(ops, HunkLine{DiffDelete, oldLines[i]})
+			i++
+		case j < len(newLines) && newChanged[j]:
+			ops = append(ops, HunkLine{DiffInsert, newLines[j]})
+			j++
+		default:
+			// context is taken from the new file like in git, it may differ
+			// in ignored whitespace
+			ops = append(ops, HunkLine{DiffEqual, newLines[j]})
+			i++
+			j++
+		}
+	}
+
+	// ranges of ops covered by hunks
+	var ranges [][2]int
+	for k := 0; k < len(ops); k++ {
+		if ops[k].Op == DiffEqual {
+			continue
+		}
+		e := k
+		for e < len(ops) && ops[e].Op != DiffEqual {
+			e++
+		}
+		start, end := k-context, e+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if n := len(ranges); n > 0 && start <= ranges[n-1][1] {
+			ranges[n-1][1] = end
+		} else {
+			ranges = append(ranges, [2]int{start, end})
+		}
+		k = e
+	}
+
+	var hunks []*Hunk
+	oldLine, newLine, pos := 1, 1, 0
+	for _, r := range ranges {
+		for ; pos < r[0]; pos++ {
+			oldLine, newLine = advanceLines(ops[pos].Op, oldLine, newLine)
+		}
+		h := &Hunk{
+			OldStart: oldLine,
+			NewStart: newLine,
+			Lines:    ops[r[0]:r[1]],
+			Section:  hunkSection(oldLines, oldLine-2),
+		}
+		for _, l := range h.Lines {
+			if l.Op != DiffInsert {
+				h.OldLines++
+			}
+			if l.Op != DiffDelete {
+				h.NewLines++
+			}
+		}
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// hunkSection finds the section line at or before the line i.
+func hunkSection(lines []string, i int) string {
+	for ; i >= 0; i-- {
+		line := lines[i]
+		if len(line) == 0 {
+			continue
+		}
+		if c := line[0]; c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' {
+			if len(line) > hunkSectionSize {
+				line = line[:hunkSectionSize]
+			}
+			return strings.TrimRight(line, " \t\n\v\f\r")
+		}
+	}
+	return ""
+}
+
+func advanceLines(op DiffOp, oldLine, newLine int) (int, int) {
+	if op != DiffInsert {
+		oldLine++
+	}
+	if op != DiffDelete {
+		newLine++
+	}
+	return oldLine, newLine
+}
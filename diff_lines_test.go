@@ -0,0 +1,75 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func formatHunks(d *BlobDiff) string {
+	var b strings.Builder
+	for _, h := range d.Hunks {
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+		for _, l := range h.Lines {
+			b.WriteString(string(l.Op) + l.Content)
+		}
+	}
+	return b.String()
+}
+
+func TestDiffBytes(t *testing.T) {
+	oldData := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newData := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+
+	// expected output as produced by git diff
+	expected := "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -8,3 +8,4 @@\n h\n i\n j\n+k\n"
+	for _, alg := range []DiffAlgorithm{DiffMyers, DiffPatience, DiffHistogram} {
+		d := DiffBytes([]byte(oldData), []byte(newData), LineDiffOptions{Algorithm: alg})
+		if got := formatHunks(d); got != expected {
+			t.Errorf("algorithm %d:\n%s", alg, got)
+		}
+		if added, deleted := d.Stats(); added != 2 || deleted != 1 {
+			t.Errorf("algorithm %d: stats %d %d", alg, added, deleted)
+		}
+	}
+
+	d := DiffBytes([]byte(oldData), []byte(newData), LineDiffOptions{Context: 10})
+	if len(d.Hunks) != 1 || d.Hunks[0].OldLines != 10 || d.Hunks[0].NewLines != 11 {
+		t.Errorf("unexpected hunks with more context:\n%s", formatHunks(d))
+	}
+
+	// patience keeps the unique lines together where Myers does not
+	oldData = "x\n{\nfoo\n}\n{\nbar\n}\n"
+	newData = "x\n{\nbar\n}\n{\nfoo\n}\n"
+	expected = "@@ -1,7 +1,7 @@\n x\n {\n-foo\n-}\n-{\n bar\n }\n+{\n+foo\n+}\n"
+	d = DiffBytes([]byte(oldData), []byte(newData), LineDiffOptions{Algorithm: DiffPatience})
+	if got := formatHunks(d); got != expected {
+		t.Errorf("unexpected patience diff:\n%s", got)
+	}
+}
+
+func TestDiffBytesOptions(t *testing.T) {
+	d := DiffBytes([]byte("a  b\nc\n"), []byte("a b \nc\n"), LineDiffOptions{IgnoreSpaceChange: true})
+	if len(d.Hunks) != 0 {
+		t.Errorf("whitespace change not ignored:\n%s", formatHunks(d))
+	}
+	d = DiffBytes([]byte("a  b\nc\n"), []byte("ab\nd"), LineDiffOptions{IgnoreAllSpace: true})
+	if got := formatHunks(d); got != "@@ -1,2 +1,2 @@\n ab\n-c\n+d" {
+		t.Errorf("unexpected diff ignoring all space:\n%s", got)
+	}
+
+	// a missing newline at the end is whitespace as well
+	for _, opts := range []LineDiffOptions{{IgnoreAllSpace: true}, {IgnoreSpaceChange: true}, {IgnoreSpaceAtEOL: true}} {
+		if d := DiffBytes([]byte("e\nf"), []byte("e\nf\n"), opts); len(d.Hunks) != 0 {
+			t.Errorf("missing newline not ignored with %+v:\n%s", opts, formatHunks(d))
+		}
+	}
+	if d := DiffBytes([]byte("e\nf"), []byte("e\nf\n"), LineDiffOptions{}); len(d.Hunks) != 1 {
+		t.Errorf("missing newline ignored without whitespace options")
+	}
+
+	d = DiffBytes([]byte("text\n"), []byte("bin\x00ary"), LineDiffOptions{})
+	if !d.Binary || len(d.Hunks) != 0 {
+		t.Error("binary content not detected")
+	}
+}
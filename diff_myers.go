@@ -0,0 +1,363 @@
+package git
+
+import (
+	"math"
+)
+
+// Tuning of Myers' algorithm, the values of git's xdiff.
+const (
+	myersMaxEqualLimit  = 1024 // lines occurring more often are not discarded
+	myersSimScanWindow  = 100
+	myersKeepRun        = 4
+	myersMinMaxCost     = 256
+	myersSnakeCount     = 20
+	myersHeuristicCost  = 256
+	myersHeuristicScale = 4
+)
+
+// bogoSqrt approximates the square root of n by a power of two.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// myers compares the ranges with Myers' algorithm in linear space like
+// git's xdiff, including its heuristics: common ends are skipped, lines
+// without a match in the other range are discarded up front and the search
+// for a shortest edit script is cut short when it gets expensive.
+func (d *lineDiffer) myers(aLo, aHi, bLo, bHi int) {
+	countA := make(map[int]int)
+	countB := make(map[int]int)
+	for i := aLo; i < aHi; i++ {
+		countA[d.a[i]]++
+	}
+	for j := bLo; j < bHi; j++ {
+		countB[d.b[j]]++
+	}
+
+	aStart, bStart := aLo, bLo
+	for aStart < aHi && bStart < bHi && d.a[aStart] == d.b[bStart] {
+		aStart++
+		bStart++
+	}
+	aEnd, bEnd := aHi, bHi
+	for aEnd > aStart && bEnd > bStart && d.a[aEnd-1] == d.b[bEnd-1] {
+		aEnd--
+		bEnd--
+	}
+
+	m := &myersSplitter{ca: d.ca, cb: d.cb}
+	m.a, m.ia = discardLines(d.a, d.ca, aStart, aEnd, countB, aHi-aLo)
+	m.b, m.ib = discardLines(d.b, d.cb, bStart, bEnd, countA, bHi-bLo)
+
+	size := len(m.a) + len(m.b) + 3
+	m.kvdf = make([]int, size)
+	m.kvdb = make([]int, size)
+	m.offset = len(m.b) + 1
+	m.maxCost = bogoSqrt(size)
+	if m.maxCost < myersMinMaxCost {
+		m.maxCost = myersMinMaxCost
+	}
+	m.compare(0, len(m.a), 0, len(m.b), false)
+}
+
+// discardLines marks the lines of [lo, hi) which cannot be matched as
+// changed and returns the others with their positions. A line is discarded
+// if it does not occur in the other file, or occurs very often and is
+// surrounded by such lines.
+func discardLines(lines []int, changed []bool, lo, hi int, otherCount map[int]int, nrec int) ([]int, []int) {
+	limit := bogoSqrt(nrec)
+	if limit > myersMaxEqualLimit {
+		limit = myersMaxEqualLimit
+	}
+
+	dis := make([]byte, hi-lo)
+	for i := range dis {
+		switch n := otherCount[lines[lo+i]]; {
+		case n == 0:
+			dis[i] = 0
+		case n >= limit:
+			dis[i] = 2
+		default:
+			dis[i] = 1
+		}
+	}
+
+	var kept, index []int
+	for i := range dis {
+		if dis[i] == 1 || dis[i] == 2 && !cleanMultiMatch(dis, i) {
+			kept = append(kept, lines[lo+i])
+			index = append(index, lo+i)
+		} else {
+			changed[lo+i] = true
+		}
+	}
+	return kept, index
+}
+
+// cleanMultiMatch reports whether the frequent line i is surrounded mostly
+// by lines without a match, and can be discarded with them.
+func cleanMultiMatch(dis []byte, i int) bool {
+	s, e := 0, len(dis)-1
+	if i-s > myersSimScanWindow {
+		s = i - myersSimScanWindow
+	}
+	if e-i > myersSimScanWindow {
+		e = i + myersSimScanWindow
+	}
+
+	var before, multiBefore int
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			before++
+		} else if dis[i-r] == 2 {
+			multiBefore++
+		} else {
+			break
+		}
+	}
+	if before == 0 {
+		return false
+	}
+	var after, multiAfter int
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			after++
+		} else if dis[i+r] == 2 {
+			multiAfter++
+		} else {
+			break
+		}
+	}
+	if after == 0 {
+		return false
+	}
+	multi := multiBefore + multiAfter + 2
+	return multi*myersKeepRun < multi+before+after
+}
+
+// myersSplitter runs the divide and conquer of Myers' algorithm on the
+// lines which were not discarded. ia and ib map them to the original lines.
+type myersSplitter struct {
+	a, b       []int
+	ia, ib     []int
+	ca, cb     []bool
+	kvdf, kvdb []int // furthest reaching paths by diagonal + offset
+	offset     int
+	maxCost    int
+}
+
+func (m *myersSplitter) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	for off1 < lim1 && off2 < lim2 && m.a[off1] == m.b[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && m.a[lim1-1] == m.b[lim2-1] {
+		lim1--
+		lim2--
+	}
+
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			m.cb[m.ib[off2]] = true
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			m.ca[m.ia[off1]] = true
+		}
+	default:
+		i1, i2, minLo, minHi := m.split(off1, lim1, off2, lim2, needMin)
+		m.compare(off1, i1, off2, i2, minLo)
+		m.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+// split finds the point where the forward and backward searches for a
+// shortest edit script meet. Unless needMin is set, an expensive search is
+// cut short at a good enough point; the returned flags tell whether the
+// halves still need a minimal diff.
+func (m *myersSplitter) split(off1, lim1, off2, lim2 int, needMin bool) (int, int, bool, bool) {
+	a, b, o := m.a, m.b, m.offset
+	kvdf, kvdb := m.kvdf, m.kvdb
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	kvdf[fmid+o] = off1
+	kvdb[bmid+o] = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		// extend the range of diagonals, or shrink it at the borders
+		if fmin > dmin {
+			fmin--
+			kvdf[fmin-1+o] = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			kvdf[fmax+1+o] = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if kvdf[d-1+o] >= kvdf[d+1+o] {
+				i1 = kvdf[d-1+o] + 1
+			} else {
+				i1 = kvdf[d+1+o]
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && a[i1] == b[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > myersSnakeCount {
+				gotSnake = true
+			}
+			kvdf[d+o] = i1
+			if odd && bmin <= d && d <= bmax && kvdb[d+o] <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			kvdb[bmin-1+o] = math.MaxInt32
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			kvdb[bmax+1+o] = math.MaxInt32
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if kvdb[d-1+o] < kvdb[d+1+o] {
+				i1 = kvdb[d-1+o]
+			} else {
+				i1 = kvdb[d+1+o] - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && a[i1-1] == b[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > myersSnakeCount {
+				gotSnake = true
+			}
+			kvdb[d+o] = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= kvdf[d+o] {
+				return i1, i2, true, true
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		// after a long snake, take a diagonal which got far enough with
+		// few edits
+		if gotSnake && ec > myersHeuristicCost {
+			best, s1, s2 := 0, 0, 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := kvdf[d+o]
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > myersHeuristicScale*ec && v > best &&
+					off1+myersSnakeCount <= i1 && i1 < lim1 &&
+					off2+myersSnakeCount <= i2 && i2 < lim2 {
+					for k := 1; a[i1-k] == b[i2-k]; k++ {
+						if k == myersSnakeCount {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, true, false
+			}
+
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := kvdb[d+o]
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > myersHeuristicScale*ec && v > best &&
+					off1 < i1 && i1 <= lim1-myersSnakeCount &&
+					off2 < i2 && i2 <= lim2-myersSnakeCount {
+					for k := 0; a[i1+k] == b[i2+k]; k++ {
+						if k == myersSnakeCount-1 {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, false, true
+			}
+		}
+
+		// enough is enough, take the furthest reaching path
+		if ec >= m.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := kvdf[d+o]
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+
+			bbest, bbest1 := math.MaxInt32, math.MaxInt32
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := kvdb[d+o]
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
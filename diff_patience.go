@@ -0,0 +1,108 @@
+package git
+
+// patience matches the lines which occur exactly once in both ranges, in
+// the longest order they share, then compares the gaps between them. Without
+// such lines it falls back to Myers' algorithm.
+func (d *lineDiffer) patience(aLo, aHi, bLo, bHi int) {
+	if aLo == aHi || bLo == bHi {
+		d.mark(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	type occurrence struct {
+		countA, countB int
+		posA, posB     int
+	}
+	occ := make(map[int]*occurrence)
+	for i := aLo; i < aHi; i++ {
+		o := occ[d.a[i]]
+		if o == nil {
+			o = &occurrence{posA: i}
+			occ[d.a[i]] = o
+		}
+		o.countA++
+	}
+	matches := false
+	for j := bLo; j < bHi; j++ {
+		if o := occ[d.b[j]]; o != nil {
+			matches = true
+			o.countB++
+			o.posB = j
+		}
+	}
+	if !matches {
+		d.mark(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	// unique lines in the order of a, with their position in b
+	var uniq [][2]int
+	for i := aLo; i < aHi; i++ {
+		if o := occ[d.a[i]]; o.countA == 1 && o.countB == 1 {
+			uniq = append(uniq, [2]int{i, o.posB})
+		}
+	}
+	if len(uniq) == 0 {
+		d.myers(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	// longest increasing subsequence of the positions in b by patience
+	// sorting
+	var tops []int
+	prev := make([]int, len(uniq))
+	for i, u := range uniq {
+		lo, hi := 0, len(tops)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if uniq[tops[mid]][1] < u[1] {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		prev[i] = -1
+		if lo > 0 {
+			prev[i] = tops[lo-1]
+		}
+		if lo == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[lo] = i
+		}
+	}
+	var anchors [][2]int
+	for i := tops[len(tops)-1]; i >= 0; i = prev[i] {
+		anchors = append(anchors, uniq[i])
+	}
+	for i, j := 0, len(anchors)-1; i < j; i, j = i+1, j-1 {
+		anchors[i], anchors[j] = anchors[j], anchors[i]
+	}
+
+	// compare the gaps, after growing the matches around each anchor
+	for k := 0; ; k++ {
+		next1, next2 := aHi, bHi
+		if k < len(anchors) {
+			next1, next2 = anchors[k][0], anchors[k][1]
+			for next1 > aLo && next2 > bLo && d.a[next1-1] == d.b[next2-1] {
+				next1--
+				next2--
+			}
+		}
+		for aLo < next1 && bLo < next2 && d.a[aLo] == d.b[bLo] {
+			aLo++
+			bLo++
+		}
+		if next1 > aLo || next2 > bLo {
+			d.patience(aLo, next1, bLo, next2)
+		}
+		if k == len(anchors) {
+			return
+		}
+
+		for k+1 < len(anchors) && anchors[k+1][0] == anchors[k][0]+1 && anchors[k+1][1] == anchors[k][1]+1 {
+			k++
+		}
+		aLo, bLo = anchors[k][0]+1, anchors[k][1]+1
+	}
+}
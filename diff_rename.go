@@ -0,0 +1,337 @@
+package git
+
+import (
+	"hash/fnv"
+	"io/ioutil"
+	"path"
+	"sort"
+)
+
+const (
+	defaultRenameThreshold = 50
+	defaultRenameLimit     = 1000
+
+	// files are compared in chunks ending at a newline or of this size
+	renameChunkSize = 64
+)
+
+// renameSource is a file which an added file may have been renamed or copied
+// from.
+type renameSource struct {
+	path   string
+	mode   EntryMode
+	id     sha1
+	change *TreeChange // nil for unmodified files
+	used   int         // number of renames and copies from it
+}
+
+// renamePair is a possible rename or copy from src to changes[dst].
+type renamePair struct {
+	src, dst int
+	score    int
+	sameName bool
+}
+
+type renameDetector struct {
+	repo    *Repository
+	opts    TreeDiffOptions
+	changes []*TreeChange
+	sources []*renameSource
+	dsts    []int // indexes of added files in changes
+	found   map[int]*renamePair
+	chunks  map[sha1]map[uint32]int
+	sizes   map[sha1]int64
+}
+
+// detectRenames replaces pairs of a deleted and an added file with a rename
+// and turns added files into copies. Exact renames are found first, then
+// files are scored by the share of equal lines.
+func (repo *Repository) detectRenames(changes []*TreeChange, oldTree *Tree, opts TreeDiffOptions) ([]*TreeChange, error) {
+	if opts.RenameThreshold <= 0 {
+		opts.RenameThreshold = defaultRenameThreshold
+	}
+	if opts.RenameLimit <= 0 {
+		opts.RenameLimit = defaultRenameLimit
+	}
+	r := &renameDetector{
+		repo:    repo,
+		opts:    opts,
+		changes: changes,
+		found:   make(map[int]*renamePair),
+		chunks:  make(map[sha1]map[uint32]int),
+		sizes:   make(map[sha1]int64),
+	}
+	if err := r.collect(oldTree); err != nil {
+		return nil, err
+	}
+	if len(r.dsts) == 0 || len(r.sources) == 0 {
+		return changes, nil
+	}
+
+	r.findExact()
+	if err := r.findSimilar(); err != nil {
+		return nil, err
+	}
+	return r.result(), nil
+}
+
+// collect finds the added files and the possible sources: deleted files,
+// with copies the old side of modified files, and with FindCopiesHarder
+// every file of the old tree.
+func (r *renameDetector) collect(oldTree *Tree) error {
+	changed := make(map[string]bool)
+	for i, c := range r.changes {
+		switch {
+		case c.Type == ChangeAdded:
+			r.dsts = append(r.dsts, i)
+		case c.Type == ChangeDeleted,
+			r.opts.DetectCopies && (c.Type == ChangeModified || c.Type == ChangeTypeChanged):
+			r.sources = append(r.sources, &renameSource{path: c.OldPath, mode: c.OldMode, id: c.OldId, change: c})
+			changed[c.OldPath] = true
+		}
+	}
+	if !r.opts.DetectCopies || !r.opts.FindCopiesHarder || oldTree == nil {
+		return nil
+	}
+
+	filter := pathFilter(r.opts.Paths)
+	return oldTree.walkFiles("", func(name string, te *TreeEntry) error {
+		if !changed[name] && filter.match(name) {
+			r.sources = append(r.sources, &renameSource{path: name, mode: te.mode, id: te.Id})
+		}
+		return nil
+	})
+}
+
+// canUse reports whether the source may still be renamed, which a deleted
+// file may only be once, or copied.
+func (r *renameDetector) canUse(src *renameSource, rename bool) bool {
+	if rename {
+		return src.change != nil && src.change.Type == ChangeDeleted && src.used == 0
+	}
+	return r.opts.DetectCopies
+}
+
+// compatible reports whether files of the modes can be renamed into each
+// other. Only regular files are compared by content.
+func compatible(a, b EntryMode) bool {
+	return modeType(a) == modeType(b) && a != ModeCommit
+}
+
+func (r *renameDetector) take(p *renamePair) {
+	r.found[p.dst] = p
+	r.sources[p.src].used++
+}
+
+// findExact pairs added files with sources of the same id, preferring the
+// same file name and renames over copies.
+func (r *renameDetector) findExact() {
+	byId := make(map[sha1][]int)
+	for i, src := range r.sources {
+		byId[src.id] = append(byId[src.id], i)
+	}
+
+	for _, rename := range []bool{true, false} {
+		for _, dst := range r.dsts {
+			c := r.changes[dst]
+			if r.found[dst] != nil {
+				continue
+			}
+			var best *renamePair
+			for _, i := range byId[c.NewId] {
+				src := r.sources[i]
+				if !compatible(src.mode, c.NewMode) || !r.canUse(src, rename) {
+					continue
+				}
+				p := &renamePair{src: i, dst: dst, score: 100, sameName: path.Base(src.path) == path.Base(c.NewPath)}
+				if best == nil || p.sameName && !best.sameName {
+					best = p
+				}
+			}
+			if best != nil {
+				r.take(best)
+			}
+		}
+	}
+}
+
+// findSimilar scores the remaining pairs by content and takes the best ones
+// above the threshold, renames first.
+func (r *renameDetector) findSimilar() error {
+	var dsts []int
+	for _, dst := range r.dsts {
+		if r.found[dst] == nil && r.changes[dst].NewMode != ModeCommit {
+			dsts = append(dsts, dst)
+		}
+	}
+	if len(dsts) == 0 || len(dsts)*len(r.sources) > r.opts.RenameLimit*r.opts.RenameLimit {
+		return nil
+	}
+
+	var pairs []*renamePair
+	for _, dst := range dsts {
+		c := r.changes[dst]
+		for i, src := range r.sources {
+			if !compatible(src.mode, c.NewMode) || !r.canUse(src, true) && !r.canUse(src, false) {
+				continue
+			}
+			score, err := r.similarity(src.id, c.NewId)
+			if err != nil {
+				return err
+			}
+			if score >= r.opts.RenameThreshold {
+				pairs = append(pairs, &renamePair{src: i, dst: dst, score: score, sameName: path.Base(src.path) == path.Base(c.NewPath)})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].score != pairs[j].score {
+			return pairs[i].score > pairs[j].score
+		}
+		return pairs[i].sameName && !pairs[j].sameName
+	})
+
+	for _, rename := range []bool{true, false} {
+		for _, p := range pairs {
+			if r.found[p.dst] == nil && r.canUse(r.sources[p.src], rename) {
+				r.take(p)
+			}
+		}
+	}
+	return nil
+}
+
+// similarity returns the percentage of the bigger file which is also part
+// of the other one.
+func (r *renameDetector) similarity(a, b sha1) (int, error) {
+	if a == b {
+		return 100, nil
+	}
+	sizeA, err := r.size(a)
+	if err != nil {
+		return 0, err
+	}
+	sizeB, err := r.size(b)
+	if err != nil {
+		return 0, err
+	}
+	max, min := sizeA, sizeB
+	if max < min {
+		max, min = min, max
+	}
+	if max == 0 {
+		return 100, nil
+	}
+	// the size difference alone may rule out the threshold
+	if (max-min)*100 > max*int64(100-r.opts.RenameThreshold) {
+		return 0, nil
+	}
+
+	chunksA, err := r.chunksOf(a)
+	if err != nil {
+		return 0, err
+	}
+	chunksB, err := r.chunksOf(b)
+	if err != nil {
+		return 0, err
+	}
+	var common int64
+	for h, n := range chunksA {
+		if m := chunksB[h]; m < n {
+			common += int64(m)
+		} else {
+			common += int64(n)
+		}
+	}
+	return int(common * 100 / max), nil
+}
+
+func (r *renameDetector) size(id sha1) (int64, error) {
+	if size, ok := r.sizes[id]; ok {
+		return size, nil
+	}
+	size, err := r.repo.objectSize(id)
+	if err != nil {
+		return 0, err
+	}
+	r.sizes[id] = size
+	return size, nil
+}
+
+// chunksOf returns the number of bytes of a blob in chunks of each hash.
+func (r *renameDetector) chunksOf(id sha1) (map[uint32]int, error) {
+	if chunks, ok := r.chunks[id]; ok {
+		return chunks, nil
+	}
+	_, _, rc, err := r.repo.getRawObject(id, false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make(map[uint32]int)
+	for len(data) > 0 {
+		n := 0
+		for n < len(data) && n < renameChunkSize {
+			n++
+			if data[n-1] == '\n' {
+				break
+			}
+		}
+		h := fnv.New32a()
+		h.Write(data[:n])
+		chunks[h.Sum32()] += n
+		data = data[n:]
+	}
+	r.chunks[id] = chunks
+	return chunks, nil
+}
+
+// result merges the pairs into the changes. Of several files taken from the
+// same deleted file the last one is the rename and the others are copies,
+// like in git.
+func (r *renameDetector) result() []*TreeChange {
+	remaining := make(map[*renameSource]int)
+	renamed := make(map[*TreeChange]bool)
+	for _, src := range r.sources {
+		remaining[src] = src.used
+		renamed[src.change] = src.used > 0
+	}
+
+	var changes []*TreeChange
+	for i, c := range r.changes {
+		p := r.found[i]
+		if p == nil {
+			if c.Type != ChangeDeleted || !renamed[c] {
+				changes = append(changes, c)
+			}
+			continue
+		}
+
+		src := r.sources[p.src]
+		remaining[src]--
+		t := ChangeCopied
+		if src.change != nil && src.change.Type == ChangeDeleted && remaining[src] == 0 {
+			t = ChangeRenamed
+		}
+		changes = append(changes, &TreeChange{
+			Type:       t,
+			OldPath:    src.path,
+			NewPath:    c.NewPath,
+			OldMode:    src.mode,
+			NewMode:    c.NewMode,
+			OldId:      src.id,
+			NewId:      c.NewId,
+			Similarity: p.score,
+		})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path() < changes[j].Path()
+	})
+	return changes
+}
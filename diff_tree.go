@@ -0,0 +1,295 @@
+package git
+
+import (
+	"strings"
+)
+
+// ChangeType is the kind of a TreeChange.
+type ChangeType int
+
+const (
+	ChangeAdded ChangeType = iota + 1
+	ChangeDeleted
+	ChangeModified
+	ChangeTypeChanged // between file, symbolic link and submodule
+	ChangeRenamed
+	ChangeCopied
+)
+
+// String returns the letter git diff --name-status uses for the change.
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "A"
+	case ChangeDeleted:
+		return "D"
+	case ChangeModified:
+		return "M"
+	case ChangeTypeChanged:
+		return "T"
+	case ChangeRenamed:
+		return "R"
+	case ChangeCopied:
+		return "C"
+	}
+	return "?"
+}
+
+// TreeChange is a changed file between two trees. The old side of an added
+// file and the new side of a deleted file are zero.
+type TreeChange struct {
+	Type             ChangeType
+	OldPath, NewPath string
+	OldMode, NewMode EntryMode
+	OldId, NewId     sha1
+
+	// Similarity is the percentage of content shared by the old and new
+	// file of a rename or copy.
+	Similarity int
+}
+
+// Path returns the path of the file, the old one for deleted files.
+func (c *TreeChange) Path() string {
+	if c.Type == ChangeDeleted {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+// TreeDiffOptions configures DiffTrees.
+type TreeDiffOptions struct {
+	// Paths limits the diff to files below these paths or matching these
+	// wildmatch patterns. All files are compared if empty.
+	Paths []string
+
+	// DetectRenames pairs deleted and added files with similar content,
+	// like git diff -M.
+	DetectRenames bool
+
+	// DetectCopies also finds added files copied from files modified in
+	// the same diff, like git diff -C. With FindCopiesHarder any file of
+	// the old tree is a possible source, which is expensive.
+	DetectCopies     bool
+	FindCopiesHarder bool
+
+	// RenameThreshold is the minimum similarity in percent of a rename or
+	// copy, 50 by default.
+	RenameThreshold int
+
+	// RenameLimit skips detection of renames which are not exact if the
+	// number of sources times destinations exceeds its square, like
+	// diff.renameLimit. It is 1000 by default.
+	RenameLimit int
+}
+
+// DiffTrees returns the files changed from the tree oldIdStr to the tree
+// newIdStr, in the order of git diff. An empty id stands for the empty tree.
+func (repo *Repository) DiffTrees(oldIdStr, newIdStr string, opts TreeDiffOptions) ([]*TreeChange, error) {
+	var trees [2]*Tree
+	for i, idStr := range []string{oldIdStr, newIdStr} {
+		if len(idStr) == 0 {
+			continue
+		}
+		id, err := NewIdFromString(idStr)
+		if err != nil {
+			return nil, err
+		}
+		if trees[i], err = repo.getTree(id); err != nil {
+			return nil, err
+		}
+	}
+	return repo.diffTrees(trees[0], trees[1], opts)
+}
+
+// Diff returns the files changed by the commit, compared to its first parent.
+func (c *Commit) Diff(opts TreeDiffOptions) ([]*TreeChange, error) {
+	var parent *Tree
+	if c.ParentCount() > 0 {
+		p, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		parent = &p.Tree
+	}
+	return c.repo.diffTrees(parent, &c.Tree, opts)
+}
+
+// diffTrees compares two trees, either of which may be nil for the empty
+// tree.
+func (repo *Repository) diffTrees(oldTree, newTree *Tree, opts TreeDiffOptions) ([]*TreeChange, error) {
+	d := &treeDiffer{repo: repo, filter: pathFilter(opts.Paths)}
+	if err := d.diff(oldTree, newTree, ""); err != nil {
+		return nil, err
+	}
+	if opts.DetectRenames || opts.DetectCopies {
+		return repo.detectRenames(d.changes, oldTree, opts)
+	}
+	return d.changes, nil
+}
+
+type treeDiffer struct {
+	repo    *Repository
+	filter  pathFilter
+	changes []*TreeChange
+}
+
+func (d *treeDiffer) entries(t *Tree) (Entries, error) {
+	if t == nil {
+		return nil, nil
+	}
+	return t.readEntries()
+}
+
+// treeEntryName returns the name a tree entry is sorted by, with a trailing
+// slash for trees.
+func treeEntryName(te *TreeEntry) string {
+	if te.IsDir() {
+		return te.name + "/"
+	}
+	return te.name
+}
+
+// diff walks both trees in tree order. A file and a directory of the same
+// name are distinct entries, like in git.
+func (d *treeDiffer) diff(oldTree, newTree *Tree, prefix string) error {
+	if oldTree != nil && newTree != nil && oldTree.Id == newTree.Id {
+		return nil
+	}
+
+	oldEntries, err := d.entries(oldTree)
+	if err != nil {
+		return err
+	}
+	newEntries, err := d.entries(newTree)
+	if err != nil {
+		return err
+	}
+
+	for len(oldEntries) > 0 || len(newEntries) > 0 {
+		var o, n *TreeEntry
+		switch {
+		case len(newEntries) == 0:
+			o = oldEntries[0]
+		case len(oldEntries) == 0:
+			n = newEntries[0]
+		default:
+			on, nn := treeEntryName(oldEntries[0]), treeEntryName(newEntries[0])
+			switch {
+			case on < nn:
+				o = oldEntries[0]
+			case on > nn:
+				n = newEntries[0]
+			default:
+				o, n = oldEntries[0], newEntries[0]
+			}
+		}
+		if o != nil {
+			oldEntries = oldEntries[1:]
+		}
+		if n != nil {
+			newEntries = newEntries[1:]
+		}
+
+		if err := d.diffEntry(o, n, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *treeDiffer) diffEntry(o, n *TreeEntry, prefix string) error {
+	te := n
+	if te == nil {
+		te = o
+	}
+	path := prefix + te.name
+
+	if te.IsDir() {
+		if !d.filter.matchDir(path) {
+			return nil
+		}
+		var oldTree, newTree *Tree
+		var err error
+		if o != nil {
+			if oldTree, err = d.repo.getTree(o.Id); err != nil {
+				return err
+			}
+		}
+		if n != nil {
+			if newTree, err = d.repo.getTree(n.Id); err != nil {
+				return err
+			}
+		}
+		return d.diff(oldTree, newTree, path+"/")
+	}
+
+	if !d.filter.match(path) {
+		return nil
+	}
+
+	c := &TreeChange{}
+	switch {
+	case o == nil:
+		c.Type = ChangeAdded
+	case n == nil:
+		c.Type = ChangeDeleted
+	case o.Id == n.Id && o.mode == n.mode:
+		return nil
+	case modeType(o.mode) != modeType(n.mode):
+		c.Type = ChangeTypeChanged
+	default:
+		c.Type = ChangeModified
+	}
+	if o != nil {
+		c.OldPath, c.OldMode, c.OldId = path, o.mode, o.Id
+	}
+	if n != nil {
+		c.NewPath, c.NewMode, c.NewId = path, n.mode, n.Id
+	}
+	d.changes = append(d.changes, c)
+	return nil
+}
+
+// pathFilter limits a diff to paths below any of its elements, or matching
+// them as wildmatch patterns. An empty filter matches everything.
+type pathFilter []string
+
+func (f pathFilter) match(name string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, p := range f {
+		p = strings.TrimSuffix(p, "/")
+		if len(p) == 0 || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+		if hasGlob(p) && wildmatch(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDir reports whether any file below the directory dir may match.
+func (f pathFilter) matchDir(dir string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	dir += "/"
+	for _, p := range f {
+		// only the part before the first wildcard must agree
+		if i := strings.IndexAny(p, "*?[\\"); i >= 0 {
+			p = p[:i]
+		} else {
+			p = strings.TrimSuffix(p, "/") + "/"
+		}
+		if strings.HasPrefix(dir, p) || strings.HasPrefix(p, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[\\")
+}
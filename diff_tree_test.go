@@ -0,0 +1,139 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	r := copyTestRepo(t)
+	blob := func(content string) sha1 {
+		id, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	same := storeTestTree(t, r, []*TreeEntry{{name: "x", mode: ModeBlob, Id: blob("x")}})
+	oldSub := storeTestTree(t, r, []*TreeEntry{
+		{name: "a.go", mode: ModeBlob, Id: blob("a")},
+		{name: "b.go", mode: ModeBlob, Id: blob("b")},
+	})
+	newSub := storeTestTree(t, r, []*TreeEntry{
+		{name: "a.go", mode: ModeExec, Id: blob("a")},
+		{name: "c.go", mode: ModeBlob, Id: blob("c")},
+	})
+	oldRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "dir", mode: ModeTree, Id: oldSub},
+		{name: "file", mode: ModeBlob, Id: blob("file")},
+		{name: "link", mode: ModeBlob, Id: blob("target")},
+		{name: "same", mode: ModeTree, Id: same},
+	})
+	newRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "dir", mode: ModeTree, Id: newSub},
+		{name: "file", mode: ModeTree, Id: same},
+		{name: "link", mode: ModeSymlink, Id: blob("target")},
+		{name: "same", mode: ModeTree, Id: same},
+	})
+
+	diff := func(opts TreeDiffOptions) string {
+		changes, err := r.DiffTrees(oldRoot.String(), newRoot.String(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, fmt.Sprintf("%s %o %o %s", c.Type, c.OldMode, c.NewMode, c.Path()))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	expected := strings.Join([]string{
+		"M 100644 100755 dir/a.go",
+		"D 100644 0 dir/b.go",
+		"A 0 100644 dir/c.go",
+		"D 100644 0 file",
+		"A 0 100644 file/x",
+		"T 100644 120000 link",
+	}, "\n")
+	if got := diff(TreeDiffOptions{}); got != expected {
+		t.Errorf("unexpected diff:\n%s", got)
+	}
+
+	expected = "M 100644 100755 dir/a.go\nT 100644 120000 link"
+	if got := diff(TreeDiffOptions{Paths: []string{"dir/a*", "link"}}); got != expected {
+		t.Errorf("unexpected filtered diff:\n%s", got)
+	}
+
+	changes, err := r.DiffTrees("", same.String(), TreeDiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Type != ChangeAdded || changes[0].NewPath != "x" {
+		t.Errorf("unexpected diff from the empty tree: %v", changes)
+	}
+}
+
+func TestDiffTreesRenames(t *testing.T) {
+	r := copyTestRepo(t)
+	blob := func(content string) sha1 {
+		id, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	lines := func(from, to int, extra string) string {
+		var b strings.Builder
+		for i := from; i <= to; i++ {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		return b.String() + extra
+	}
+
+	oldRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "edited", mode: ModeBlob, Id: blob(lines(1, 100, ""))},
+		{name: "moved", mode: ModeBlob, Id: blob("same\n")},
+		{name: "source", mode: ModeBlob, Id: blob(lines(200, 300, ""))},
+	})
+	newRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "copy", mode: ModeBlob, Id: blob(lines(200, 290, ""))},
+		{name: "edited2", mode: ModeBlob, Id: blob(lines(1, 90, "new\n"))},
+		{name: "moved2", mode: ModeExec, Id: blob("same\n")},
+		{name: "source", mode: ModeBlob, Id: blob(lines(200, 300, "more\n"))},
+	})
+
+	diff := func(opts TreeDiffOptions) string {
+		changes, err := r.DiffTrees(oldRoot.String(), newRoot.String(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, fmt.Sprintf("%s%d %s %s", c.Type, c.Similarity, c.OldPath, c.NewPath))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	expected := strings.Join([]string{
+		"A0  copy",
+		"R89 edited edited2",
+		"R100 moved moved2",
+		"M0 source source",
+	}, "\n")
+	if got := diff(TreeDiffOptions{DetectRenames: true}); got != expected {
+		t.Errorf("unexpected renames:\n%s", got)
+	}
+
+	expected = strings.Replace(expected, "A0  copy", "C90 source copy", 1)
+	if got := diff(TreeDiffOptions{DetectRenames: true, DetectCopies: true}); got != expected {
+		t.Errorf("unexpected copies:\n%s", got)
+	}
+
+	if got := diff(TreeDiffOptions{DetectRenames: true, RenameThreshold: 95}); !strings.Contains(got, "D0 edited ") {
+		t.Errorf("rename below threshold detected:\n%s", got)
+	}
+}
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignorePattern is a line of a .gitignore file.
+type ignorePattern struct {
+	pattern  string
+	base     string // directory of the .gitignore, "" or "dir/"
+	negate   bool   // "!pattern" re-includes
+	dirOnly  bool   // "pattern/" only matches directories
+	anchored bool   // matched against the path below base, not the name
+}
+
+// parseIgnorePatterns parses the content of an ignore file found in the
+// directory base.
+func parseIgnorePatterns(data []byte, base string) []*ignorePattern {
+	var patterns []*ignorePattern
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		// trailing spaces are dropped unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+
+		p := &ignorePattern{base: base}
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		p.pattern = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match reports whether the pattern matches the slash separated path.
+func (p *ignorePattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !strings.HasPrefix(name, p.base) {
+		return false
+	}
+	name = name[len(p.base):]
+
+	if p.anchored {
+		return wildmatch(p.pattern, name)
+	}
+	return wildmatch(p.pattern, path.Base(name))
+}
+
+// ignoreMatcher holds the ignore rules in effect for a directory, in
+// increasing precedence: core.excludesFile, info/exclude and the .gitignore
+// files from the top of the work tree down.
+type ignoreMatcher []*ignorePattern
+
+// Ignored reports whether the path, relative to the work tree, is ignored.
+// The last matching pattern decides.
+func (m ignoreMatcher) Ignored(name string, isDir bool) bool {
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i].match(name, isDir) {
+			return !m[i].negate
+		}
+	}
+	return false
+}
+
+// with returns the matcher extended by the patterns of the ignore file at
+// path, which applies to the directory base.
+func (m ignoreMatcher) with(path, base string) ignoreMatcher {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return m
+	}
+	// never append to the parent's array, it is shared by siblings
+	return append(m[:len(m):len(m)], parseIgnorePatterns(data, base)...)
+}
+
+// newIgnoreMatcher returns the rules which apply to the whole work tree,
+// without any .gitignore.
+func (repo *Repository) newIgnoreMatcher(cfg *Config) ignoreMatcher {
+	var m ignoreMatcher
+
+	excludesFile, err := cfg.Get("core.excludesFile")
+	if err != nil {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); len(xdg) > 0 {
+			excludesFile = filepath.Join(xdg, "git", "ignore")
+		} else if home := os.Getenv("HOME"); len(home) > 0 {
+			excludesFile = filepath.Join(home, ".config", "git", "ignore")
+		}
+	} else if strings.HasPrefix(excludesFile, "~/") {
+		excludesFile = filepath.Join(os.Getenv("HOME"), excludesFile[2:])
+	}
+	if len(excludesFile) > 0 {
+		m = m.with(excludesFile, "")
+	}
+
+	return m.with(filepath.Join(repo.CommonDir, "info", "exclude"), "")
+}
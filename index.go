@@ -0,0 +1,765 @@
+package git
+
+import (
+	"bytes"
+	libsha1 "crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIndexCorrupt = errors.New("corrupt index file")
+)
+
+const (
+	indexSignature = "DIRC"
+
+	// stat data, id and flags of an entry
+	indexEntryHeaderSize = 62
+
+	indexFlagAssumeValid = 0x8000
+	indexFlagExtended    = 0x4000
+	indexFlagStageMask   = 0x3000
+	indexFlagStageShift  = 12
+	indexFlagNameMask    = 0x0fff
+
+	indexExtFlagSkipWorktree = 0x4000
+	indexExtFlagIntentToAdd  = 0x2000
+)
+
+// IndexEntry is a file in the index. Conflicted files have one entry for
+// each of the stages 1 (common ancestor), 2 (ours) and 3 (theirs) instead of
+// a single entry at stage 0.
+type IndexEntry struct {
+	Name  string // slash separated path
+	Id    sha1
+	Mode  EntryMode
+	Stage int
+
+	// stat data of the file when it was last written or refreshed
+	CTime, MTime time.Time
+	Dev, Ino     uint32
+	UID, GID     uint32
+	Size         uint32
+
+	AssumeValid  bool
+	SkipWorktree bool
+	IntentToAdd  bool
+}
+
+func (e *IndexEntry) extended() bool {
+	return e.SkipWorktree || e.IntentToAdd
+}
+
+// TreeCache is the TREE extension: the ids of trees which would be written
+// for the index, so unchanged trees need not be hashed again.
+type TreeCache struct {
+	Name string // path component, empty for the root
+
+	// Entries is the number of index entries below the tree, -1 if the
+	// tree was invalidated and Id is not valid.
+	Entries  int
+	Id       sha1
+	Children []*TreeCache
+}
+
+// Invalidate marks the trees containing path as changed.
+func (t *TreeCache) Invalidate(path string) {
+	for t != nil {
+		t.Entries = -1
+
+		slash := strings.IndexByte(path, '/')
+		if slash < 0 {
+			return
+		}
+		name := path[:slash]
+		path = path[slash+1:]
+
+		var next *TreeCache
+		for _, child := range t.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		t = next
+	}
+}
+
+// ResolveUndo is a REUC entry: the stages of a conflict which was resolved,
+// so the conflict can be recreated. Stages which did not exist have a zero
+// mode.
+type ResolveUndo struct {
+	Name  string
+	Modes [3]EntryMode
+	Ids   [3]sha1
+}
+
+// UntrackedCache is the UNTR extension. Only the environment it is valid
+// for is decoded, the cached directory contents are written back as they
+// were read. The cache is dropped when paths are added to or removed from
+// the index, git rebuilds it as needed.
+type UntrackedCache struct {
+	Ident []string
+	data  []byte
+}
+
+// SplitIndexLink is the link extension of a split index, which only holds
+// the changes to a shared index file.
+type SplitIndexLink struct {
+	SharedIndex sha1
+
+	// positions of the entries in the shared index which are deleted or
+	// replaced by this index
+	Delete, Replace []int
+}
+
+// Index is the staging area, the .git/index file.
+type Index struct {
+	Version uint32 // 2, 3 or 4
+	Entries []*IndexEntry
+
+	Tree        *TreeCache
+	ResolveUndo []*ResolveUndo
+	Untracked   *UntrackedCache
+
+	// Link is set if the index was read from a split index. The entries
+	// of the shared index are merged into Entries; the index is written
+	// back as a single file.
+	Link *SplitIndexLink
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{Version: 2}
+}
+
+func compareIndexEntry(name string, stage int, e *IndexEntry) int {
+	if c := strings.Compare(name, e.Name); c != 0 {
+		return c
+	}
+	return stage - e.Stage
+}
+
+// find returns the position of the entry name at stage, or where it would
+// be inserted.
+func (idx *Index) find(name string, stage int) (int, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return compareIndexEntry(name, stage, idx.Entries[i]) <= 0
+	})
+	return i, i < len(idx.Entries) && compareIndexEntry(name, stage, idx.Entries[i]) == 0
+}
+
+// Entry returns the entry for name at the given stage, nil if there is
+// none.
+func (idx *Index) Entry(name string, stage int) *IndexEntry {
+	if i, ok := idx.find(name, stage); ok {
+		return idx.Entries[i]
+	}
+	return nil
+}
+
+// Add adds entry to the index, replacing the entry with the same name and
+// stage. Adding a stage 0 entry resolves a conflict on the path.
+func (idx *Index) Add(entry *IndexEntry) {
+	if entry.Stage == 0 {
+		idx.removeStages(entry.Name, 1)
+	} else {
+		idx.removeStages(entry.Name, 0)
+	}
+	if idx.Tree != nil {
+		idx.Tree.Invalidate(entry.Name)
+	}
+
+	i, ok := idx.find(entry.Name, entry.Stage)
+	if ok {
+		idx.Entries[i] = entry
+		return
+	}
+	idx.Untracked = nil
+	idx.Entries = append(idx.Entries, nil)
+	copy(idx.Entries[i+1:], idx.Entries[i:])
+	idx.Entries[i] = entry
+}
+
+// Remove removes all stages of name from the index and reports whether
+// there was any.
+func (idx *Index) Remove(name string) bool {
+	if idx.Tree != nil {
+		idx.Tree.Invalidate(name)
+	}
+	if !idx.removeStages(name, 0) {
+		return false
+	}
+	idx.Untracked = nil
+	return true
+}
+
+// removeStages removes the entries for name at stage minStage or higher.
+func (idx *Index) removeStages(name string, minStage int) bool {
+	i, _ := idx.find(name, minStage)
+	j := i
+	for j < len(idx.Entries) && idx.Entries[j].Name == name {
+		j++
+	}
+	idx.Entries = append(idx.Entries[:i], idx.Entries[j:]...)
+	return j > i
+}
+
+func (idx *Index) sortEntries() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		return compareIndexEntry(a.Name, a.Stage, b) < 0
+	})
+}
+
+// ReadIndex reads the index file at path. The entries of a split index are
+// merged with its shared index, which is read from the same directory.
+func ReadIndex(path string) (*Index, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := DecodeIndex(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx.Link != nil {
+		shared, err := ReadIndex(filepath.Join(filepath.Dir(path), "sharedindex."+idx.Link.SharedIndex.String()))
+		if err != nil {
+			return nil, fmt.Errorf("reading shared index: %v", err)
+		}
+		if err := idx.mergeShared(shared); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// mergeShared applies the split index idx to its shared index. The first
+// entries of idx replace the shared entries marked in the replace bitmap,
+// the others are added.
+func (idx *Index) mergeShared(shared *Index) error {
+	deleted := make(map[int]bool, len(idx.Link.Delete))
+	for _, pos := range idx.Link.Delete {
+		deleted[pos] = true
+	}
+	if len(idx.Link.Replace) > len(idx.Entries) {
+		return ErrIndexCorrupt
+	}
+	replaced := make(map[int]*IndexEntry, len(idx.Link.Replace))
+	for i, pos := range idx.Link.Replace {
+		replaced[pos] = idx.Entries[i]
+	}
+
+	entries := make([]*IndexEntry, 0, len(shared.Entries)+len(idx.Entries))
+	for pos, entry := range shared.Entries {
+		if r := replaced[pos]; r != nil {
+			r.Name = entry.Name
+			entry = r
+		}
+		if !deleted[pos] {
+			entries = append(entries, entry)
+		}
+	}
+
+	idx.Entries = append(entries, idx.Entries[len(idx.Link.Replace):]...)
+	idx.sortEntries()
+	return nil
+}
+
+// DecodeIndex parses the content of an index file. The entries of a split
+// index are not merged with its shared index.
+func DecodeIndex(data []byte) (*Index, error) {
+	if len(data) < 12+libsha1.Size || string(data[:4]) != indexSignature {
+		return nil, ErrIndexCorrupt
+	}
+
+	// an index written with index.skipHash has no checksum
+	body, sum := data[:len(data)-libsha1.Size], data[len(data)-libsha1.Size:]
+	if expected := libsha1.Sum(body); !bytes.Equal(sum, expected[:]) && !sha1FromBytes(sum).IsZero() {
+		return nil, fmt.Errorf("index checksum mismatch")
+	}
+
+	idx := &Index{Version: binary.BigEndian.Uint32(data[4:])}
+	if idx.Version < 2 || idx.Version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", idx.Version)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	pos := 12
+	prevName := ""
+	for i := 0; i < count; i++ {
+		entry, n, err := decodeIndexEntry(body[pos:], idx.Version, prevName)
+		if err != nil {
+			return nil, err
+		}
+		idx.Entries = append(idx.Entries, entry)
+		prevName = entry.Name
+		pos += n
+	}
+
+	for pos < len(body) {
+		if len(body)-pos < 8 {
+			return nil, ErrIndexCorrupt
+		}
+		sig := string(body[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(body[pos+4:]))
+		pos += 8
+		if size > len(body)-pos {
+			return nil, ErrIndexCorrupt
+		}
+		if err := idx.decodeExtension(sig, body[pos:pos+size]); err != nil {
+			return nil, err
+		}
+		pos += size
+	}
+	return idx, nil
+}
+
+func sha1FromBytes(b []byte) sha1 {
+	var id sha1
+	copy(id[:], b)
+	return id
+}
+
+// indexTime converts a timestamp of the index, where zero stands for an
+// unknown time.
+func indexTime(sec, nsec uint32) time.Time {
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+func indexTimeFields(t time.Time) (sec, nsec uint32) {
+	if t.IsZero() {
+		return 0, 0
+	}
+	return uint32(t.Unix()), uint32(t.Nanosecond())
+}
+
+func decodeIndexEntry(data []byte, version uint32, prevName string) (*IndexEntry, int, error) {
+	if len(data) < indexEntryHeaderSize {
+		return nil, 0, ErrIndexCorrupt
+	}
+
+	u32 := func(off int) uint32 { return binary.BigEndian.Uint32(data[off:]) }
+	entry := &IndexEntry{
+		CTime: indexTime(u32(0), u32(4)),
+		MTime: indexTime(u32(8), u32(12)),
+		Dev:   u32(16),
+		Ino:   u32(20),
+		Mode:  EntryMode(u32(24)),
+		UID:   u32(28),
+		GID:   u32(32),
+		Size:  u32(36),
+		Id:    sha1FromBytes(data[40:60]),
+	}
+
+	flags := binary.BigEndian.Uint16(data[60:])
+	entry.AssumeValid = flags&indexFlagAssumeValid != 0
+	entry.Stage = int(flags&indexFlagStageMask) >> indexFlagStageShift
+
+	pos := indexEntryHeaderSize
+	if flags&indexFlagExtended != 0 {
+		if version < 3 || len(data) < pos+2 {
+			return nil, 0, ErrIndexCorrupt
+		}
+		extFlags := binary.BigEndian.Uint16(data[pos:])
+		entry.SkipWorktree = extFlags&indexExtFlagSkipWorktree != 0
+		entry.IntentToAdd = extFlags&indexExtFlagIntentToAdd != 0
+		pos += 2
+	}
+
+	if version == 4 {
+		// the name replaces the end of the previous one
+		strip, n, err := readReftableVarint(data[pos:])
+		if err != nil || strip > uint64(len(prevName)) {
+			return nil, 0, ErrIndexCorrupt
+		}
+		pos += n
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return nil, 0, ErrIndexCorrupt
+		}
+		entry.Name = prevName[:len(prevName)-int(strip)] + string(data[pos:pos+end])
+		return entry, pos + end + 1, nil
+	}
+
+	end := bytes.IndexByte(data[pos:], 0)
+	if end < 0 {
+		return nil, 0, ErrIndexCorrupt
+	}
+	entry.Name = string(data[pos : pos+end])
+
+	// entries are padded with NULs to a multiple of eight bytes
+	size := (pos + end + 8) &^ 7
+	if size > len(data) {
+		return nil, 0, ErrIndexCorrupt
+	}
+	return entry, size, nil
+}
+
+func (idx *Index) decodeExtension(sig string, data []byte) error {
+	var err error
+	switch sig {
+	case "TREE":
+		if len(data) > 0 {
+			idx.Tree, _, err = decodeTreeCache(data)
+		}
+	case "REUC":
+		idx.ResolveUndo, err = decodeResolveUndo(data)
+	case "UNTR":
+		idx.Untracked, err = decodeUntrackedCache(data)
+	case "link":
+		idx.Link, err = decodeSplitIndexLink(data)
+	default:
+		// optional extensions start with an upper case letter
+		if sig[0] < 'A' || sig[0] > 'Z' {
+			return fmt.Errorf("unsupported index extension %q", sig)
+		}
+	}
+	return err
+}
+
+// decodeTreeCache parses a tree and its subtrees, each of which is
+//
+//	<name> NUL <entries> SP <subtrees> LF [<id>]
+func decodeTreeCache(data []byte) (*TreeCache, int, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return nil, 0, ErrIndexCorrupt
+	}
+	lf := bytes.IndexByte(data[nul:], '\n')
+	if lf < 0 {
+		return nil, 0, ErrIndexCorrupt
+	}
+	lf += nul
+
+	t := &TreeCache{Name: string(data[:nul])}
+	counts := strings.Fields(string(data[nul+1 : lf]))
+	if len(counts) != 2 {
+		return nil, 0, ErrIndexCorrupt
+	}
+	entries, err1 := strconv.Atoi(counts[0])
+	subtrees, err2 := strconv.Atoi(counts[1])
+	if err1 != nil || err2 != nil || subtrees < 0 {
+		return nil, 0, ErrIndexCorrupt
+	}
+	t.Entries = entries
+
+	pos := lf + 1
+	if entries >= 0 {
+		if len(data) < pos+20 {
+			return nil, 0, ErrIndexCorrupt
+		}
+		t.Id = sha1FromBytes(data[pos : pos+20])
+		pos += 20
+	}
+
+	for i := 0; i < subtrees; i++ {
+		child, n, err := decodeTreeCache(data[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		t.Children = append(t.Children, child)
+		pos += n
+	}
+	return t, pos, nil
+}
+
+func (t *TreeCache) encode(buf []byte) []byte {
+	buf = append(buf, t.Name...)
+	buf = append(buf, 0)
+	buf = append(buf, fmt.Sprintf("%d %d\n", t.Entries, len(t.Children))...)
+	if t.Entries >= 0 {
+		buf = append(buf, t.Id[:]...)
+	}
+	for _, child := range t.Children {
+		buf = child.encode(buf)
+	}
+	return buf
+}
+
+// decodeResolveUndo parses REUC entries:
+//
+//	<name> NUL <mode1> NUL <mode2> NUL <mode3> NUL [<id1>] [<id2>] [<id3>]
+//
+// with octal modes and ids only for the stages which existed.
+func decodeResolveUndo(data []byte) ([]*ResolveUndo, error) {
+	var entries []*ResolveUndo
+	for len(data) > 0 {
+		fields := bytes.SplitN(data, []byte{0}, 5)
+		if len(fields) < 5 {
+			return nil, ErrIndexCorrupt
+		}
+
+		ru := &ResolveUndo{Name: string(fields[0])}
+		for i := 0; i < 3; i++ {
+			mode, err := strconv.ParseUint(string(fields[i+1]), 8, 32)
+			if err != nil {
+				return nil, ErrIndexCorrupt
+			}
+			ru.Modes[i] = EntryMode(mode)
+		}
+
+		data = fields[4]
+		for i := 0; i < 3; i++ {
+			if ru.Modes[i] == 0 {
+				continue
+			}
+			if len(data) < 20 {
+				return nil, ErrIndexCorrupt
+			}
+			ru.Ids[i] = sha1FromBytes(data[:20])
+			data = data[20:]
+		}
+		entries = append(entries, ru)
+	}
+	return entries, nil
+}
+
+func encodeResolveUndo(buf []byte, entries []*ResolveUndo) []byte {
+	for _, ru := range entries {
+		buf = append(buf, ru.Name...)
+		buf = append(buf, 0)
+		for _, mode := range ru.Modes {
+			buf = append(buf, strconv.FormatUint(uint64(mode), 8)...)
+			buf = append(buf, 0)
+		}
+		for i, mode := range ru.Modes {
+			if mode != 0 {
+				buf = append(buf, ru.Ids[i][:]...)
+			}
+		}
+	}
+	return buf
+}
+
+// decodeUntrackedCache decodes the environment the untracked cache was
+// made for, a list of NUL terminated strings preceded by its length.
+func decodeUntrackedCache(data []byte) (*UntrackedCache, error) {
+	size, n, err := readReftableVarint(data)
+	if err != nil || size > uint64(len(data)-n) {
+		return nil, ErrIndexCorrupt
+	}
+
+	uc := &UntrackedCache{data: data}
+	for _, s := range bytes.Split(data[n:n+int(size)], []byte{0}) {
+		if len(s) > 0 {
+			uc.Ident = append(uc.Ident, string(s))
+		}
+	}
+	return uc, nil
+}
+
+// decodeSplitIndexLink parses the id of the shared index followed by the
+// delete and replace bitmaps.
+func decodeSplitIndexLink(data []byte) (*SplitIndexLink, error) {
+	if len(data) < 20 {
+		return nil, ErrIndexCorrupt
+	}
+	link := &SplitIndexLink{SharedIndex: sha1FromBytes(data[:20])}
+	data = data[20:]
+	if len(data) == 0 {
+		return link, nil
+	}
+
+	var n int
+	var err error
+	if link.Delete, n, err = decodeEWAH(data); err != nil {
+		return nil, err
+	}
+	if link.Replace, _, err = decodeEWAH(data[n:]); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// decodeEWAH decodes a compressed bitmap and returns the positions of the
+// set bits and the size of the bitmap in bytes. The bitmap is a sequence
+// of 64 bit words, each run length word
+//
+//	bit 0: running bit, bits 1-32: running length, bits 33-63: literals
+//
+// is followed by that many literal words.
+func decodeEWAH(data []byte) ([]int, int, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrIndexCorrupt
+	}
+	bitSize := int(binary.BigEndian.Uint32(data))
+	words := int(binary.BigEndian.Uint32(data[4:]))
+	size := 8 + words*8 + 4
+	if words < 0 || len(data) < size {
+		return nil, 0, ErrIndexCorrupt
+	}
+
+	word := func(i int) uint64 { return binary.BigEndian.Uint64(data[8+i*8:]) }
+	var bits []int
+	pos := 0
+	for i := 0; i < words; {
+		rlw := word(i)
+		i++
+
+		runLen := int(rlw >> 1 & 0xffffffff)
+		literals := int(rlw >> 33)
+		if rlw&1 != 0 {
+			for b := pos; b < pos+runLen*64 && b < bitSize; b++ {
+				bits = append(bits, b)
+			}
+		}
+		pos += runLen * 64
+
+		for j := 0; j < literals; j++ {
+			if i >= words {
+				return nil, 0, ErrIndexCorrupt
+			}
+			w := word(i)
+			i++
+			for b := 0; b < 64; b++ {
+				if w>>uint(b)&1 != 0 && pos+b < bitSize {
+					bits = append(bits, pos+b)
+				}
+			}
+			pos += 64
+		}
+	}
+	return bits, size, nil
+}
+
+// Encode returns the index file content. Version 2 indexes are upgraded to
+// version 3 if an entry needs extended flags.
+func (idx *Index) Encode() []byte {
+	version := idx.Version
+	if version < 2 {
+		version = 2
+	}
+	if version == 2 {
+		for _, entry := range idx.Entries {
+			if entry.extended() {
+				version = 3
+				break
+			}
+		}
+	}
+
+	buf := make([]byte, 0, 12+len(idx.Entries)*(indexEntryHeaderSize+40))
+	buf = append(buf, indexSignature...)
+	buf = appendUint32(buf, version)
+	buf = appendUint32(buf, uint32(len(idx.Entries)))
+
+	prevName := ""
+	for _, entry := range idx.Entries {
+		buf = encodeIndexEntry(buf, entry, version, prevName)
+		prevName = entry.Name
+	}
+
+	if idx.Tree != nil {
+		buf = appendIndexExtension(buf, "TREE", idx.Tree.encode(nil))
+	}
+	if len(idx.ResolveUndo) > 0 {
+		buf = appendIndexExtension(buf, "REUC", encodeResolveUndo(nil, idx.ResolveUndo))
+	}
+	if idx.Untracked != nil {
+		buf = appendIndexExtension(buf, "UNTR", idx.Untracked.data)
+	}
+
+	sum := libsha1.Sum(buf)
+	return append(buf, sum[:]...)
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendIndexExtension(buf []byte, sig string, data []byte) []byte {
+	buf = append(buf, sig...)
+	buf = appendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+func encodeIndexEntry(buf []byte, entry *IndexEntry, version uint32, prevName string) []byte {
+	start := len(buf)
+	ctime, ctimeNsec := indexTimeFields(entry.CTime)
+	mtime, mtimeNsec := indexTimeFields(entry.MTime)
+	for _, v := range []uint32{
+		ctime, ctimeNsec, mtime, mtimeNsec, entry.Dev, entry.Ino, uint32(entry.Mode), entry.UID, entry.GID, entry.Size,
+	} {
+		buf = appendUint32(buf, v)
+	}
+	buf = append(buf, entry.Id[:]...)
+
+	flags := uint16(entry.Stage<<indexFlagStageShift) & indexFlagStageMask
+	if len(entry.Name) < indexFlagNameMask {
+		flags |= uint16(len(entry.Name))
+	} else {
+		flags |= indexFlagNameMask
+	}
+	if entry.AssumeValid {
+		flags |= indexFlagAssumeValid
+	}
+	if entry.extended() && version >= 3 {
+		flags |= indexFlagExtended
+	}
+	buf = append(buf, byte(flags>>8), byte(flags))
+
+	if flags&indexFlagExtended != 0 {
+		var extFlags uint16
+		if entry.SkipWorktree {
+			extFlags |= indexExtFlagSkipWorktree
+		}
+		if entry.IntentToAdd {
+			extFlags |= indexExtFlagIntentToAdd
+		}
+		buf = append(buf, byte(extFlags>>8), byte(extFlags))
+	}
+
+	if version == 4 {
+		common := 0
+		for common < len(prevName) && common < len(entry.Name) && prevName[common] == entry.Name[common] {
+			common++
+		}
+		buf = putReftableVarint(buf, uint64(len(prevName)-common))
+		buf = append(buf, entry.Name[common:]...)
+		return append(buf, 0)
+	}
+
+	buf = append(buf, entry.Name...)
+	size := (len(buf) - start + 8) &^ 7
+	for len(buf)-start < size {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// Index reads the index of the repository. A missing index is empty.
+func (repo *Repository) Index() (*Index, error) {
+	idx, err := ReadIndex(filepath.Join(repo.Path, "index"))
+	if os.IsNotExist(err) {
+		return NewIndex(), nil
+	}
+	return idx, err
+}
+
+// WriteIndex replaces the index of the repository under a lock.
+func (repo *Repository) WriteIndex(idx *Index) error {
+	lock, err := newLockFile(filepath.Join(repo.Path, "index"))
+	if err != nil {
+		return err
+	}
+	if err = lock.Write(idx.Encode()); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
@@ -0,0 +1,24 @@
+package git
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// setStat fills the stat data of the entry from fi.
+func (e *IndexEntry) setStat(fi os.FileInfo) {
+	e.MTime = fi.ModTime()
+	e.CTime = e.MTime
+	e.Size = uint32(fi.Size())
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	e.CTime = time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+	e.Dev = uint32(st.Dev)
+	e.Ino = uint32(st.Ino)
+	e.UID = st.Uid
+	e.GID = st.Gid
+}
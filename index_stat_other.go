@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package git
+
+import "os"
+
+// setStat fills the stat data of the entry from fi.
+func (e *IndexEntry) setStat(fi os.FileInfo) {
+	e.MTime = fi.ModTime()
+	e.CTime = e.MTime
+	e.Size = uint32(fi.Size())
+}
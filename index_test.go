@@ -0,0 +1,90 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	id, _ := NewIdFromString("c3ca89834257974d7375ac7915ed58d01afe7d4b")
+	now := time.Unix(1453753053, 12345)
+
+	for _, version := range []uint32{2, 3, 4} {
+		idx := &Index{Version: version}
+		for _, name := range []string{"src/main.go", "README", "src/util/strings.go", "src/main_test.go"} {
+			idx.Add(&IndexEntry{Name: name, Id: id, Mode: ModeBlob, MTime: now, CTime: now, Size: 42})
+		}
+		idx.Add(&IndexEntry{Name: "conflict", Id: id, Mode: ModeBlob, Stage: 2})
+		idx.Add(&IndexEntry{Name: "conflict", Id: id, Mode: ModeExec, Stage: 3})
+		idx.Tree = &TreeCache{Entries: -1, Children: []*TreeCache{{Name: "src", Entries: 3, Id: id}}}
+		if version > 2 {
+			idx.Add(&IndexEntry{Name: "new", Id: id, Mode: ModeBlob, IntentToAdd: true})
+		}
+
+		data := idx.Encode()
+		decoded, err := DecodeIndex(data)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if decoded.Version != version || len(decoded.Entries) != len(idx.Entries) {
+			t.Fatalf("version %d: decoded version %d with %d entries", version, decoded.Version, len(decoded.Entries))
+		}
+		for i, e := range decoded.Entries {
+			if *e != *idx.Entries[i] {
+				t.Fatalf("version %d: entry %d is %+v, expected %+v", version, i, e, idx.Entries[i])
+			}
+		}
+		if decoded.Tree == nil || decoded.Tree.Children[0].Name != "src" || decoded.Tree.Children[0].Id != id {
+			t.Fatalf("version %d: tree cache not decoded", version)
+		}
+		if !bytes.Equal(decoded.Encode(), data) {
+			t.Fatalf("version %d: encoding is not stable", version)
+		}
+	}
+}
+
+func TestIndexAddResolvesConflict(t *testing.T) {
+	idx := NewIndex()
+	for stage := 1; stage <= 3; stage++ {
+		idx.Add(&IndexEntry{Name: "file", Mode: ModeBlob, Stage: stage})
+	}
+	idx.Add(&IndexEntry{Name: "file", Mode: ModeBlob})
+	if len(idx.Entries) != 1 || idx.Entry("file", 0) == nil {
+		t.Fatalf("conflict not resolved: %+v", idx.Entries)
+	}
+	if !idx.Remove("file") || len(idx.Entries) != 0 {
+		t.Fatal("entry not removed")
+	}
+}
+
+// testdata/index-v4 was written by git with a conflict resolved in REUC,
+// an intent-to-add and a skip-worktree entry.
+func TestIndexV4(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/index-v4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := DecodeIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range idx.Entries {
+		names = append(names, e.Name)
+	}
+	if idx.Version != 4 || len(names) != 6 || names[1] != "a/b/z" || names[3] != "c/w" {
+		t.Fatalf("unexpected entries %q", names)
+	}
+	if !idx.Entry("a/x", 0).SkipWorktree || !idx.Entry("n", 0).IntentToAdd {
+		t.Fatal("extended flags not decoded")
+	}
+	if len(idx.ResolveUndo) != 1 || idx.ResolveUndo[0].Name != "top" || idx.ResolveUndo[0].Ids[2].String() != "2299c37978265a95cbe835a4b0f0bbf15aad5549" {
+		t.Fatalf("unexpected REUC %+v", idx.ResolveUndo)
+	}
+	if !bytes.Equal(idx.Encode(), data) {
+		t.Fatal("re-encoded index differs")
+	}
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrLocked = errors.New("file is locked by another process")
+)
+
+// lockFile implements git's locking protocol: path is locked by creating
+// "path.lock" exclusively. The new content is written to the lock file,
+// which is then renamed over path.
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+func newLockFile(path string) (*lockFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	return &lockFile{path: path, f: f}, nil
+}
+
+// Write writes data to the lock file and flushes it to disk.
+func (l *lockFile) Write(data []byte) error {
+	if _, err := l.f.Write(data); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Commit replaces the locked file with the content of the lock file and
+// releases the lock.
+func (l *lockFile) Commit() error {
+	if l.f == nil {
+		return nil
+	}
+
+	err := l.f.Close()
+	l.f = nil
+	if err == nil {
+		err = os.Rename(l.path+".lock", l.path)
+	}
+	if err != nil {
+		os.Remove(l.path + ".lock")
+	}
+	return err
+}
+
+// Rollback releases the lock without touching the locked file. It does
+// nothing if the lock was already committed.
+func (l *lockFile) Rollback() {
+	if l.f == nil {
+		return
+	}
+
+	l.f.Close()
+	l.f = nil
+	os.Remove(l.path + ".lock")
+}
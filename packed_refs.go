@@ -0,0 +1,229 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const packedRefsHeader = "# pack-refs with:"
+
+// packedRefs is the parsed content of a packed-refs file.
+//
+// The file starts with an optional header listing its traits:
+//
+//	# pack-refs with: peeled fully-peeled sorted
+//
+// followed by one "<id> <name>" line per reference. A line "^<id>" after a
+// tag reference holds the id of the object the tag peels to.
+type packedRefs struct {
+	// tags are followed by their peeled id
+	peeled bool
+	// every reference which can be peeled is followed by its peeled id
+	fullyPeeled bool
+	// references are sorted by name
+	sorted bool
+
+	data []byte // raw records following the header
+
+	mu   sync.Mutex
+	refs []*Reference // parsed on first use
+}
+
+func parsePackedRefsHeader(data []byte) (*packedRefs, error) {
+	p := &packedRefs{data: data}
+	if !bytes.HasPrefix(data, []byte(packedRefsHeader)) {
+		return p, nil
+	}
+
+	eol := bytes.IndexByte(data, '\n')
+	if eol < 0 {
+		return nil, fmt.Errorf("unterminated packed-refs header")
+	}
+	for _, trait := range strings.Fields(string(data[len(packedRefsHeader):eol])) {
+		switch trait {
+		case "peeled":
+			p.peeled = true
+		case "fully-peeled":
+			p.fullyPeeled = true
+		case "sorted":
+			p.sorted = true
+		}
+	}
+	p.data = data[eol+1:]
+	return p, nil
+}
+
+// parsePackedRefs parses all records of a packed-refs file.
+func parsePackedRefs(data []byte) (*packedRefs, error) {
+	p, err := parsePackedRefsHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p.refs = make([]*Reference, 0, bytes.Count(p.data, []byte{'\n'}))
+	for pos := 0; pos < len(p.data); {
+		ref, next, err := p.parseRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+		if ref != nil {
+			p.refs = append(p.refs, ref)
+		}
+		pos = next
+	}
+
+	if !p.sorted {
+		sortRefs(p.refs)
+		p.sorted = true
+	}
+
+	return p, nil
+}
+
+// parseRecord parses the reference starting at pos together with its
+// peeled line and returns the position of the next record. Comments
+// and empty lines yield a nil reference.
+func (p *packedRefs) parseRecord(pos int) (*Reference, int, error) {
+	line, next := packedRefsLine(p.data, pos)
+	if len(line) == 0 || line[0] == '#' {
+		return nil, next, nil
+	}
+	if line[0] == '^' {
+		return nil, 0, fmt.Errorf("peeled line without reference in packed-refs: %q", line)
+	}
+
+	if len(line) < 42 || line[40] != ' ' {
+		return nil, 0, fmt.Errorf("bad packed-refs line %q", line)
+	}
+	id, err := NewIdFromString(string(line[:40]))
+	if err != nil {
+		return nil, 0, err
+	}
+	ref := &Reference{Name: string(line[41:]), Id: id}
+
+	if peeled, after := packedRefsLine(p.data, next); len(peeled) > 0 && peeled[0] == '^' {
+		ref.Peeled, err = NewIdFromString(string(peeled[1:]))
+		if err != nil {
+			return nil, 0, err
+		}
+		next = after
+	}
+
+	return ref, next, nil
+}
+
+// all returns every reference sorted by name, parsing the records on
+// first use.
+func (p *packedRefs) all() ([]*Reference, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.refs == nil {
+		parsed, err := parsePackedRefs(p.data)
+		if err != nil {
+			return nil, err
+		}
+		p.refs = parsed.refs
+	}
+	return p.refs, nil
+}
+
+// ref returns the reference called name or nil if there is none. Sorted
+// files are binary searched without parsing every record.
+func (p *packedRefs) ref(name string) (*Reference, error) {
+	if !p.sorted {
+		if _, err := p.all(); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	refs := p.refs
+	p.mu.Unlock()
+	if refs != nil {
+		i := sort.Search(len(refs), func(i int) bool {
+			return refs[i].Name >= name
+		})
+		if i < len(refs) && refs[i].Name == name {
+			return refs[i], nil
+		}
+		return nil, nil
+	}
+
+	lo, hi := 0, len(p.data)
+	for lo < hi {
+		pos := p.recordStart(lo + (hi-lo)/2)
+		ref, next, err := p.parseRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case ref == nil:
+			// comment or empty line, search the part behind it
+			lo = next
+		case ref.Name < name:
+			lo = next
+		case ref.Name > name:
+			hi = pos
+		default:
+			return ref, nil
+		}
+	}
+	return nil, nil
+}
+
+// recordStart returns the beginning of the record containing pos, going
+// back over peeled lines to the reference they belong to.
+func (p *packedRefs) recordStart(pos int) int {
+	for {
+		start := bytes.LastIndexByte(p.data[:pos], '\n') + 1
+		if start == 0 || p.data[start] != '^' {
+			return start
+		}
+		pos = start - 1
+	}
+}
+
+// packedRefsLine returns the line starting at pos without its line
+// feed and the position of the following line.
+func packedRefsLine(data []byte, pos int) ([]byte, int) {
+	if pos >= len(data) {
+		return nil, len(data)
+	}
+	eol := bytes.IndexByte(data[pos:], '\n')
+	if eol < 0 {
+		return data[pos:], len(data)
+	}
+	return data[pos : pos+eol], pos + eol + 1
+}
+
+// encode returns refs in the packed-refs format. The peeled traits of p
+// are kept, refs must be sorted by name.
+func (p *packedRefs) encode(refs []*Reference) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(packedRefsHeader)
+	if p.peeled {
+		buf.WriteString(" peeled")
+	}
+	if p.fullyPeeled {
+		buf.WriteString(" fully-peeled")
+	}
+	buf.WriteString(" sorted \n")
+
+	for _, ref := range refs {
+		buf.WriteString(ref.Id.String())
+		buf.WriteByte(' ')
+		buf.WriteString(ref.Name)
+		buf.WriteByte('\n')
+		if ref.IsPeeled() {
+			buf.WriteByte('^')
+			buf.WriteString(ref.Peeled.String())
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,472 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAbbrev    = 7
+	defaultStatWidth = 80
+
+	// git shows at most this many bytes of the line a hunk is in
+	hunkSectionSize = 80
+)
+
+// FilePatch is the diff of a changed file.
+type FilePatch struct {
+	*TreeChange
+	*BlobDiff // nil if the content did not change
+
+	// binary files are summarized by their sizes
+	binary           bool
+	oldSize, newSize int
+
+	// a change of the file type is shown as deletion and creation
+	typeChange [2]*BlobDiff
+
+	// set by ParsePatch: the abbreviated ids of the index line and the
+	// data of a binary patch
+	oldIndex, newIndex string
+	binaryPatch        *binaryPatch
+}
+
+// PatchOptions configures WritePatch.
+type PatchOptions struct {
+	// Abbrev is the number of hex digits of the ids in index lines, 7 by
+	// default.
+	Abbrev int
+
+	// SrcPrefix and DstPrefix replace "a/" and "b/" before the paths,
+	// unless NoPrefix is set.
+	SrcPrefix, DstPrefix string
+	NoPrefix             bool
+}
+
+// Patch computes the line diffs of the changes.
+func (repo *Repository) Patch(changes []*TreeChange, opts LineDiffOptions) ([]*FilePatch, error) {
+	patches := make([]*FilePatch, 0, len(changes))
+	for _, c := range changes {
+		p := &FilePatch{TreeChange: c}
+		patches = append(patches, p)
+		if c.OldId == c.NewId {
+			// an unchanged binary file is still marked in --stat
+			if c.OldMode != ModeCommit && (c.Type == ChangeRenamed || c.Type == ChangeCopied) {
+				data, err := repo.blobData(c.OldId)
+				if err != nil {
+					return nil, err
+				}
+				p.binary = isBinary(data)
+			}
+			continue
+		}
+
+		oldData, err := repo.patchData(c.OldId, c.OldMode)
+		if err != nil {
+			return nil, err
+		}
+		newData, err := repo.patchData(c.NewId, c.NewMode)
+		if err != nil {
+			return nil, err
+		}
+		p.BlobDiff = DiffBytes(oldData, newData, opts)
+		p.binary = p.Binary
+		p.oldSize, p.newSize = len(oldData), len(newData)
+		if c.Type == ChangeTypeChanged {
+			p.typeChange[0] = DiffBytes(oldData, nil, opts)
+			p.typeChange[1] = DiffBytes(nil, newData, opts)
+		}
+	}
+	return patches, nil
+}
+
+// patchData returns the content of a file as shown in a patch, which for
+// submodules is the commit.
+func (repo *Repository) patchData(id sha1, mode EntryMode) ([]byte, error) {
+	if mode == ModeCommit {
+		return []byte("Subproject commit " + id.String() + "\n"), nil
+	}
+	return repo.blobData(id)
+}
+
+// WritePatch writes the patches in the format of git diff.
+func WritePatch(w io.Writer, patches []*FilePatch, opts PatchOptions) error {
+	bw := bufio.NewWriter(w)
+	for _, p := range patches {
+		if p.Type == ChangeTypeChanged && p.BlobDiff != nil {
+			// shown as a deletion followed by a creation, like git
+			del, add := *p.TreeChange, *p.TreeChange
+			del.Type, del.NewMode, del.NewId = ChangeDeleted, 0, sha1{}
+			add.Type, add.OldMode, add.OldId = ChangeAdded, 0, sha1{}
+			writeFilePatch(bw, &FilePatch{TreeChange: &del, BlobDiff: p.typeChange[0]}, opts)
+			writeFilePatch(bw, &FilePatch{TreeChange: &add, BlobDiff: p.typeChange[1]}, opts)
+			continue
+		}
+		writeFilePatch(bw, p, opts)
+	}
+	return bw.Flush()
+}
+
+func writeFilePatch(w *bufio.Writer, p *FilePatch, opts PatchOptions) {
+	srcPrefix, dstPrefix := "a/", "b/"
+	if opts.NoPrefix {
+		srcPrefix, dstPrefix = "", ""
+	} else {
+		if len(opts.SrcPrefix) > 0 {
+			srcPrefix = opts.SrcPrefix
+		}
+		if len(opts.DstPrefix) > 0 {
+			dstPrefix = opts.DstPrefix
+		}
+	}
+	abbrev := opts.Abbrev
+	if abbrev <= 0 {
+		abbrev = defaultAbbrev
+	}
+
+	oldPath, newPath := p.OldPath, p.NewPath
+	switch p.Type {
+	case ChangeAdded:
+		oldPath = newPath
+	case ChangeDeleted:
+		newPath = oldPath
+	}
+	oldName := quotePath(srcPrefix + oldPath)
+	newName := quotePath(dstPrefix + newPath)
+	fmt.Fprintf(w, "diff --git %s %s\n", oldName, newName)
+
+	switch {
+	case p.Type == ChangeAdded:
+		fmt.Fprintf(w, "new file mode %06o\n", p.NewMode)
+		oldName = "/dev/null"
+	case p.Type == ChangeDeleted:
+		fmt.Fprintf(w, "deleted file mode %06o\n", p.OldMode)
+		newName = "/dev/null"
+	case p.OldMode != p.NewMode:
+		fmt.Fprintf(w, "old mode %06o\nnew mode %06o\n", p.OldMode, p.NewMode)
+	}
+	switch p.Type {
+	case ChangeRenamed, ChangeCopied:
+		verb := "rename"
+		if p.Type == ChangeCopied {
+			verb = "copy"
+		}
+		fmt.Fprintf(w, "similarity index %d%%\n", p.Similarity)
+		fmt.Fprintf(w, "%s from %s\n%s to %s\n", verb, quotePath(p.OldPath), verb, quotePath(p.NewPath))
+	}
+	if p.BlobDiff == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "index %s..%s", p.OldId.String()[:abbrev], p.NewId.String()[:abbrev])
+	if p.OldMode == p.NewMode {
+		fmt.Fprintf(w, " %06o", p.OldMode)
+	}
+	w.WriteString("\n")
+
+	if p.Binary {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return
+	}
+	if len(p.Hunks) == 0 {
+		return
+	}
+	// a tab marks the end of names with spaces
+	fmt.Fprintf(w, "--- %s%s\n+++ %s%s\n", oldName, nameTab(oldName), newName, nameTab(newName))
+
+	for _, h := range p.Hunks {
+		fmt.Fprintf(w, "@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+		if len(h.Section) > 0 {
+			w.WriteString(" " + h.Section)
+		}
+		w.WriteString("\n")
+		for _, l := range h.Lines {
+			w.WriteByte(byte(l.Op))
+			w.WriteString(l.Content)
+			if !strings.HasSuffix(l.Content, "\n") {
+				w.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+}
+
+func nameTab(name string) string {
+	if strings.Contains(name, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+// hunkRange formats a side of a hunk header, omitting a count of one.
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// quotePath quotes a path in C style like git does if it contains control
+// characters, quotes, backslashes or non-ASCII bytes.
+func quotePath(name string) string {
+	needsQuote := false
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c == '"' || c == '\\' || c >= 0x7f {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\v':
+			b.WriteString(`\v`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// statName returns the name of a file in --stat and --numstat output, with
+// renames shortened to the part that changed like "dir/{a => b}".
+func statName(p *FilePatch) string {
+	if p.Type != ChangeRenamed && p.Type != ChangeCopied {
+		return quotePath(p.Path())
+	}
+
+	a, b := p.OldPath, p.NewPath
+	if quotePath(a) != a || quotePath(b) != b {
+		return quotePath(a) + " => " + quotePath(b)
+	}
+
+	// common prefix up to a slash
+	pfx := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx = i + 1
+		}
+	}
+	// common suffix from a slash, which may be the one ending the prefix
+	sfx := 0
+	adjust := 0
+	if pfx > 0 {
+		adjust = 1
+	}
+	for i, j := len(a), len(b); i >= pfx-adjust && j >= pfx-adjust; i, j = i-1, j-1 {
+		ca, cb := byte(0), byte(0)
+		if i < len(a) {
+			ca = a[i]
+		}
+		if j < len(b) {
+			cb = b[j]
+		}
+		if ca != cb {
+			break
+		}
+		if ca == '/' {
+			sfx = len(a) - i
+		}
+	}
+
+	aMid, bMid := len(a)-pfx-sfx, len(b)-pfx-sfx
+	if aMid < 0 {
+		aMid = 0
+	}
+	if bMid < 0 {
+		bMid = 0
+	}
+	name := a[pfx:pfx+aMid] + " => " + b[pfx:pfx+bMid]
+	if pfx+sfx > 0 {
+		name = a[:pfx] + "{" + name + "}" + a[len(a)-sfx:]
+	}
+	return name
+}
+
+// WriteNumstat writes the number of added and deleted lines of each file
+// like git diff --numstat.
+func WriteNumstat(w io.Writer, patches []*FilePatch) error {
+	bw := bufio.NewWriter(w)
+	for _, p := range patches {
+		if p.binary {
+			fmt.Fprintf(bw, "-\t-\t%s\n", statName(p))
+			continue
+		}
+		added, deleted := p.stats()
+		fmt.Fprintf(bw, "%d\t%d\t%s\n", added, deleted, statName(p))
+	}
+	return bw.Flush()
+}
+
+func (p *FilePatch) stats() (added, deleted int) {
+	if p.BlobDiff == nil {
+		return 0, 0
+	}
+	return p.Stats()
+}
+
+// WriteStat writes a histogram of the changed lines like git diff --stat.
+// width is the width of the output, 80 if zero.
+func WriteStat(w io.Writer, patches []*FilePatch, width int) error {
+	if width <= 0 {
+		width = defaultStatWidth
+	}
+
+	names := make([]string, len(patches))
+	maxLen, maxChange, numberWidth, binWidth := 0, 0, 0, 0
+	for i, p := range patches {
+		names[i] = statName(p)
+		if len(names[i]) > maxLen {
+			maxLen = len(names[i])
+		}
+		if p.binary {
+			// "Bin XXX -> YYY bytes"
+			if w := 14 + len(strconv.Itoa(p.oldSize)) + len(strconv.Itoa(p.newSize)); w > binWidth {
+				binWidth = w
+			}
+			numberWidth = 3
+			continue
+		}
+		if added, deleted := p.stats(); added+deleted > maxChange {
+			maxChange = added + deleted
+		}
+	}
+	if n := len(strconv.Itoa(maxChange)); n > numberWidth {
+		numberWidth = n
+	}
+
+	// the same division of the width between names and graph as git
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var insertions, deletions int
+	for i, p := range patches {
+		name, prefix := names[i], ""
+		if len(name) > nameWidth {
+			prefix = "..."
+			keep := nameWidth - 3
+			if keep < 0 {
+				keep = 0
+			}
+			name = name[len(name)-keep:]
+			if slash := strings.IndexByte(name, '/'); slash >= 0 {
+				name = name[slash:]
+			}
+		}
+		padding := nameWidth - len(prefix) - len(name)
+		if padding < 0 {
+			padding = 0
+		}
+		fmt.Fprintf(bw, " %s%s%s | ", prefix, name, strings.Repeat(" ", padding))
+
+		if p.binary {
+			fmt.Fprintf(bw, "%*s", numberWidth, "Bin")
+			if p.OldId != p.NewId {
+				fmt.Fprintf(bw, " %d -> %d bytes", p.oldSize, p.newSize)
+			}
+			bw.WriteString("\n")
+			continue
+		}
+
+		added, deleted := p.stats()
+		insertions += added
+		deletions += deleted
+		add, del := added, deleted
+		if graphWidth <= maxChange {
+			total := scaleStat(added+deleted, graphWidth, maxChange)
+			if total < 2 && added > 0 && deleted > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleStat(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleStat(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		fmt.Fprintf(bw, "%*d", numberWidth, added+deleted)
+		if added+deleted > 0 {
+			bw.WriteString(" ")
+		}
+		bw.WriteString(strings.Repeat("+", add) + strings.Repeat("-", del) + "\n")
+	}
+
+	files := "files"
+	if len(patches) == 1 {
+		files = "file"
+	}
+	fmt.Fprintf(bw, " %d %s changed", len(patches), files)
+	if insertions > 0 || deletions == 0 {
+		fmt.Fprintf(bw, ", %d insertion%s(+)", insertions, plural(insertions))
+	}
+	if deletions > 0 || insertions == 0 {
+		fmt.Fprintf(bw, ", %d deletion%s(-)", deletions, plural(deletions))
+	}
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// scaleStat scales a number of changed lines to the graph, showing at least
+// one character for any change.
+func scaleStat(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,531 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrPatchConflict = errors.New("patch does not apply")
+)
+
+// ApplyOptions configures Tree.Apply.
+type ApplyOptions struct {
+	// Fuzz is the number of context lines at the start and the end of a
+	// hunk which may be ignored if the hunk does not apply with all of
+	// them. Without fuzz, hunks at the start or end of a file must also
+	// apply there, like with git apply.
+	Fuzz int
+}
+
+// PatchConflict is a part of a patch which does not apply.
+type PatchConflict struct {
+	Path string
+
+	// Hunk is the index of the hunk which does not apply, -1 if the whole
+	// file conflicts.
+	Hunk int
+
+	Reason string
+}
+
+func (c *PatchConflict) String() string {
+	if c.Hunk < 0 {
+		return c.Path + ": " + c.Reason
+	}
+	return fmt.Sprintf("%s: hunk #%d: %s", c.Path, c.Hunk+1, c.Reason)
+}
+
+// Apply applies the patches to the files of the tree in order and stores
+// the resulting tree. Hunks are searched for near the lines they name. If
+// any part of the patches does not apply, ErrPatchConflict is returned
+// with all conflicts and nothing is stored.
+func (t *Tree) Apply(patches []*FilePatch, opts ApplyOptions) (*Tree, []*PatchConflict, error) {
+	a := &patchApplier{
+		tree:  t,
+		opts:  opts,
+		files: make(map[string]*TreeEntry),
+		blobs: make(map[sha1][]byte),
+	}
+	for _, p := range patches {
+		if err := a.apply(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(a.conflicts) > 0 {
+		return nil, a.conflicts, ErrPatchConflict
+	}
+
+	for id, data := range a.blobs {
+		if _, err := t.repo.StoreObjectLoose(ObjectBlob, bytes.NewReader(data)); err != nil {
+			return nil, nil, fmt.Errorf("failed to store blob %s: %v", id, err)
+		}
+	}
+	id, _, err := t.repo.updateTree(t.Id, "", a.files)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewTree(t.repo, id), nil, nil
+}
+
+// patchApplier tracks the files changed by the patches applied so far.
+type patchApplier struct {
+	tree *Tree
+	opts ApplyOptions
+
+	files     map[string]*TreeEntry // nil for deleted files
+	blobs     map[sha1][]byte       // new content to store
+	conflicts []*PatchConflict
+}
+
+// lookup returns the entry at path, nil if there is none.
+func (a *patchApplier) lookup(path string) (*TreeEntry, error) {
+	if te, ok := a.files[path]; ok {
+		return te, nil
+	}
+
+	t := a.tree
+	for {
+		name := path
+		i := strings.IndexByte(path, '/')
+		if i >= 0 {
+			name, path = path[:i], path[i+1:]
+		}
+
+		entries, err := t.readEntries()
+		if err != nil {
+			return nil, err
+		}
+		var te *TreeEntry
+		for _, e := range entries {
+			if e.name == name {
+				te = e
+				break
+			}
+		}
+		if i < 0 || te == nil || !te.IsDir() {
+			if i >= 0 {
+				return nil, nil
+			}
+			return te, nil
+		}
+		if t, err = t.repo.getTree(te.Id); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// data returns the content of a file as shown in patches.
+func (a *patchApplier) data(te *TreeEntry) ([]byte, error) {
+	if data, ok := a.blobs[te.Id]; ok {
+		return data, nil
+	}
+	return a.tree.repo.patchData(te.Id, te.mode)
+}
+
+func (a *patchApplier) apply(p *FilePatch) error {
+	conflict := func(hunk int, format string, args ...interface{}) {
+		a.conflicts = append(a.conflicts, &PatchConflict{
+			Path:   p.Path(),
+			Hunk:   hunk,
+			Reason: fmt.Sprintf(format, args...),
+		})
+	}
+
+	var old *TreeEntry
+	if p.Type != ChangeAdded {
+		te, err := a.lookup(p.OldPath)
+		if err != nil {
+			return err
+		}
+		if te == nil || te.IsDir() {
+			conflict(-1, "%s does not exist", p.OldPath)
+			return nil
+		}
+		old = te
+	}
+	if p.Type != ChangeDeleted && p.Type != ChangeModified {
+		ok, err := a.canCreate(p.NewPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			conflict(-1, "%s already exists", p.NewPath)
+			return nil
+		}
+	}
+
+	var te *TreeEntry
+	if old != nil {
+		te = &TreeEntry{Id: old.Id, Type: old.Type, mode: old.mode}
+	} else {
+		te = &TreeEntry{Type: ObjectBlob, mode: ModeBlob}
+	}
+	if p.NewMode != 0 {
+		te.mode = p.NewMode
+		te.Type = ObjectBlob
+		if te.mode == ModeCommit {
+			te.Type = ObjectCommit
+		}
+	}
+
+	if p.BlobDiff != nil {
+		var oldData []byte
+		if old != nil {
+			var err error
+			if oldData, err = a.data(old); err != nil {
+				return err
+			}
+		}
+
+		var (
+			newData []byte
+			ok      bool
+			err     error
+		)
+		if p.Binary {
+			newData, ok, err = a.applyBinary(p, old, oldData, conflict)
+		} else {
+			newData, ok = a.applyHunks(p, oldData, conflict)
+		}
+		if err != nil || !ok {
+			return err
+		}
+
+		switch {
+		case p.Type == ChangeDeleted:
+			if len(newData) > 0 {
+				conflict(-1, "removal patch leaves file contents")
+				return nil
+			}
+		case te.mode == ModeCommit:
+			s := strings.TrimSuffix(string(newData), "\n")
+			id, err := NewIdFromString(strings.TrimPrefix(s, "Subproject commit "))
+			if err != nil || !strings.HasPrefix(s, "Subproject commit ") {
+				conflict(-1, "invalid submodule commit")
+				return nil
+			}
+			te.Id = id
+		default:
+			id, err := hashObject(ObjectBlob, int64(len(newData)), bytes.NewReader(newData))
+			if err != nil {
+				return err
+			}
+			te.Id = id
+			a.blobs[id] = newData
+		}
+	}
+
+	if p.Type == ChangeDeleted || p.Type == ChangeRenamed {
+		a.files[p.OldPath] = nil
+	}
+	if p.Type != ChangeDeleted {
+		te.name = p.NewPath[strings.LastIndexByte(p.NewPath, '/')+1:]
+		a.files[p.NewPath] = te
+	}
+	return nil
+}
+
+// canCreate reports whether a file can be created at path, which must not
+// exist and must not be below a file.
+func (a *patchApplier) canCreate(path string) (bool, error) {
+	te, err := a.lookup(path)
+	if err != nil || te != nil {
+		return false, err
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		if te, err := a.lookup(path[:i]); err != nil || te != nil && !te.IsDir() {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// applyHunks applies the hunks of a text patch in order. Each hunk may
+// apply at an offset, which is expected for the following ones as well.
+func (a *patchApplier) applyHunks(p *FilePatch, data []byte, conflict func(int, string, ...interface{})) ([]byte, bool) {
+	image := splitLines(data)
+	ok := true
+	offset := 0
+	for i, h := range p.Hunks {
+		var applied bool
+		var at int
+		image, at, applied = applyHunk(image, h, offset, a.opts.Fuzz)
+		if !applied {
+			conflict(i, "does not apply at line %d", h.OldStart)
+			ok = false
+			continue
+		}
+		offset = at - hunkPosition(h)
+	}
+	if !ok {
+		return nil, false
+	}
+	return []byte(strings.Join(image, "")), true
+}
+
+// hunkPosition returns the index of the first line of a hunk in the file
+// with the previous hunks applied.
+func hunkPosition(h *Hunk) int {
+	if h.NewLines == 0 {
+		return h.NewStart
+	}
+	return h.NewStart - 1
+}
+
+// applyHunk searches for the old lines of the hunk nearest to where the
+// hunk expects them and replaces them with the new lines. The returned
+// position is where the hunk with all its context applied.
+func applyHunk(image []string, h *Hunk, offset, fuzz int) ([]string, int, bool) {
+	var pre, post []string
+	for _, l := range h.Lines {
+		if l.Op != DiffInsert {
+			pre = append(pre, l.Content)
+		}
+		if l.Op != DiffDelete {
+			post = append(post, l.Content)
+		}
+	}
+	leading := 0
+	for leading < len(h.Lines) && h.Lines[leading].Op == DiffEqual {
+		leading++
+	}
+	trailing := 0
+	for trailing < len(h.Lines)-leading && h.Lines[len(h.Lines)-1-trailing].Op == DiffEqual {
+		trailing++
+	}
+
+	// hunks at the start or end of the file must stay there; without any
+	// context this is only known for the start
+	hasContext := leading+trailing > 0
+	matchBeginning := h.OldStart == 0 || h.OldStart == 1 && hasContext
+	matchEnd := trailing == 0 && hasContext
+
+	pos := hunkPosition(h) + offset
+	for k := 0; ; {
+		drop := k
+		if drop > leading {
+			drop = leading
+		}
+		dropEnd := k
+		if dropEnd > trailing {
+			dropEnd = trailing
+		}
+		at := findHunk(image, pre[drop:len(pre)-dropEnd], pos+drop, matchBeginning, matchEnd)
+		if at >= 0 {
+			result := make([]string, 0, len(image)-len(pre)+len(post))
+			result = append(result, image[:at]...)
+			result = append(result, post[drop:len(post)-dropEnd]...)
+			result = append(result, image[at+len(pre)-drop-dropEnd:]...)
+			return result, at - drop, true
+		}
+
+		switch {
+		case k >= fuzz:
+			return image, 0, false
+		case matchBeginning || matchEnd:
+			matchBeginning, matchEnd = false, false
+		case k >= leading && k >= trailing:
+			return image, 0, false
+		default:
+			k++
+		}
+	}
+}
+
+// findHunk returns the index of the lines in image nearest to pos, trying
+// later lines first at the same distance like git, or -1.
+func findHunk(image, lines []string, pos int, matchBeginning, matchEnd bool) int {
+	matches := func(at int) bool {
+		if at < 0 || at+len(lines) > len(image) {
+			return false
+		}
+		for i, l := range lines {
+			if image[at+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case matchBeginning && matchEnd:
+		if len(lines) == len(image) && matches(0) {
+			return 0
+		}
+		return -1
+	case matchBeginning:
+		pos = 0
+	case matchEnd:
+		pos = len(image) - len(lines)
+	}
+	if matchBeginning || matchEnd {
+		if matches(pos) {
+			return pos
+		}
+		return -1
+	}
+
+	if pos > len(image) {
+		pos = len(image)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	for d := 0; pos-d >= 0 || pos+d <= len(image); d++ {
+		if matches(pos + d) {
+			return pos + d
+		}
+		if d > 0 && matches(pos-d) {
+			return pos - d
+		}
+	}
+	return -1
+}
+
+// applyBinary checks that a binary patch is made for the old content and
+// returns the new content. A patch without data can only be applied if the
+// new blob is in the repository.
+func (a *patchApplier) applyBinary(p *FilePatch, old *TreeEntry, oldData []byte, conflict func(int, string, ...interface{})) ([]byte, bool, error) {
+	if old != nil && !strings.HasPrefix(old.Id.String(), p.oldIndex) {
+		conflict(-1, "the patch does not apply to the content of %s", p.OldPath)
+		return nil, false, nil
+	}
+
+	bp := p.binaryPatch
+	if bp == nil {
+		if p.Type == ChangeDeleted {
+			return nil, true, nil
+		}
+		if p.NewId == (sha1{}) {
+			conflict(-1, "binary patch without full index line")
+			return nil, false, nil
+		}
+		found, _, err := a.tree.repo.haveObject(p.NewId)
+		if err != nil {
+			return nil, false, err
+		}
+		if !found {
+			conflict(-1, "binary patch without data for missing blob %s", p.NewId)
+			return nil, false, nil
+		}
+		data, err := a.tree.repo.blobData(p.NewId)
+		return data, err == nil, err
+	}
+
+	newData := bp.data
+	if bp.delta {
+		r := bytes.NewReader(bp.data)
+		baseLen, _ := readerLittleEndianBase128Number(r)
+		resultLen, _ := readerLittleEndianBase128Number(r)
+		if baseLen != int64(len(oldData)) {
+			conflict(-1, "binary delta does not apply to the content of %s", p.OldPath)
+			return nil, false, nil
+		}
+		var err error
+		if newData, err = readerApplyDelta(&readAter{oldData}, r, resultLen); err != nil {
+			conflict(-1, "invalid binary delta: %v", err)
+			return nil, false, nil
+		}
+	}
+
+	// only deletions have an all-zero new index
+	if p.Type != ChangeDeleted && len(p.newIndex) > 0 && len(strings.Trim(p.newIndex, "0")) == 0 {
+		conflict(-1, "binary patch without result for a file that is not removed")
+		return nil, false, nil
+	}
+	if p.Type == ChangeDeleted {
+		if len(newData) != 0 {
+			conflict(-1, "removal patch leaves file contents")
+			return nil, false, nil
+		}
+		return nil, true, nil
+	}
+
+	id, err := hashObject(ObjectBlob, int64(len(newData)), bytes.NewReader(newData))
+	if err != nil {
+		return nil, false, err
+	}
+	if !strings.HasPrefix(id.String(), p.newIndex) {
+		conflict(-1, "binary patch does not result in %s", p.newIndex)
+		return nil, false, nil
+	}
+	return newData, true, nil
+}
+
+// updateTree stores the tree id, which is empty if zero, with the files
+// below prefix replaced by the given entries, and returns its id and the
+// number of entries. Nil entries remove files.
+func (repo *Repository) updateTree(id sha1, prefix string, files map[string]*TreeEntry) (sha1, int, error) {
+	byName := make(map[string]*TreeEntry)
+	if id != (sha1{}) {
+		t, err := repo.getTree(id)
+		if err != nil {
+			return sha1{}, 0, err
+		}
+		entries, err := t.readEntries()
+		if err != nil {
+			return sha1{}, 0, err
+		}
+		for _, te := range entries {
+			byName[te.name] = te
+		}
+	}
+
+	dirs := make(map[string]bool)
+	for path, te := range files {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		name := path[len(prefix):]
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			dirs[name[:i]] = true
+			continue
+		}
+		if te == nil {
+			delete(byName, name)
+		} else {
+			byName[name] = te
+		}
+	}
+	for name := range dirs {
+		var subId sha1
+		if te := byName[name]; te != nil {
+			if !te.IsDir() {
+				return sha1{}, 0, fmt.Errorf("%s%s is not a directory", prefix, name)
+			}
+			subId = te.Id
+		}
+		newId, n, err := repo.updateTree(subId, prefix+name+"/", files)
+		if err != nil {
+			return sha1{}, 0, err
+		}
+		if n == 0 {
+			delete(byName, name)
+		} else {
+			byName[name] = &TreeEntry{Id: newId, Type: ObjectTree, mode: ModeTree, name: name}
+		}
+	}
+
+	entries := make(Entries, 0, len(byName))
+	for _, te := range byName {
+		entries = append(entries, te)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return treeEntryName(entries[i]) < treeEntryName(entries[j])
+	})
+
+	var buf bytes.Buffer
+	for _, te := range entries {
+		fmt.Fprintf(&buf, "%o %s\x00", te.mode, te.name)
+		buf.Write(te.Id[:])
+	}
+	newId, err := repo.StoreObjectLoose(ObjectTree, bytes.NewReader(buf.Bytes()))
+	return newId, len(entries), err
+}
@@ -0,0 +1,158 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	r := copyTestRepo(t)
+	blob := func(content string) sha1 {
+		id, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	lines := func(from, to int) string {
+		var b strings.Builder
+		for i := from; i <= to; i++ {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		return b.String()
+	}
+
+	// the patch was made before two lines were inserted at the top
+	oldRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "file", mode: ModeBlob, Id: blob("new 1\nnew 2\n" + lines(1, 20))},
+		{name: "gone", mode: ModeBlob, Id: blob("bye\n")},
+		{name: "old name", mode: ModeBlob, Id: blob("moved\n")},
+	})
+	tree, err := r.GetTree(oldRoot.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := strings.Join([]string{
+		"From: someone",
+		"",
+		"diff --git a/file b/file",
+		"index 1234567..89abcde 100644",
+		"--- a/file",
+		"+++ b/file",
+		"@@ -9,5 +9,5 @@ section",
+		" line 9",
+		" line 10",
+		"-line 11",
+		"+changed 11",
+		" line 12",
+		" line 13",
+		"diff --git a/gone b/gone",
+		"deleted file mode 100644",
+		"index 2a3eb2b..0000000",
+		"--- a/gone",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-bye",
+		"diff --git a/old name b/dir/new name",
+		"old mode 100644",
+		"new mode 100755",
+		"similarity index 100%",
+		"rename from old name",
+		"rename to dir/new name",
+		"diff --git a/bin b/bin",
+		"new file mode 100644",
+		"index 0000000000000000000000000000000000000000..1a23e4be731d2f539deeea324686d000ccdfbfcd",
+		"GIT binary patch",
+		"literal 4",
+		"LcmYdfNa6wj0#*Rd",
+		"",
+		"literal 0",
+		"HcmV?d00001",
+		"",
+		"",
+	}, "\n")
+	patches, err := ParsePatch(strings.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 4 || patches[0].Hunks[0].Section != "section" || patches[2].Type != ChangeRenamed {
+		t.Fatalf("unexpected patches: %v", patches)
+	}
+
+	newTree, _, err := tree.Apply(patches, ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := storeTestTree(t, r, []*TreeEntry{{name: "new name", mode: ModeExec, Id: blob("moved\n")}})
+	expected := storeTestTree(t, r, []*TreeEntry{
+		{name: "bin", mode: ModeBlob, Id: blob("a\x00b\n")},
+		{name: "dir", mode: ModeTree, Id: dir},
+		{name: "file", mode: ModeBlob, Id: blob("new 1\nnew 2\n" + strings.Replace(lines(1, 20), "line 11", "changed 11", 1))},
+	})
+	if newTree.Id != expected {
+		t.Errorf("unexpected tree %s, expected %s", newTree.Id, expected)
+	}
+
+	// the first context line does not match anymore
+	changed := strings.Replace(patch, " line 9", " line nine", 1)
+	patches, err = ParsePatch(strings.NewReader(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, conflicts, err := tree.Apply(patches, ApplyOptions{})
+	if err != ErrPatchConflict || len(conflicts) != 1 || conflicts[0].String() != "file: hunk #1: does not apply at line 9" {
+		t.Errorf("unexpected conflicts %v: %v", conflicts, err)
+	}
+	if newTree, _, err = tree.Apply(patches, ApplyOptions{Fuzz: 1}); err != nil || newTree.Id != expected {
+		t.Errorf("patch did not apply with fuzz: %v", err)
+	}
+
+	// applying it again conflicts for every file
+	_, conflicts, _ = newTree.Apply(patches, ApplyOptions{Fuzz: 1})
+	if len(conflicts) != 4 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	// binary deletions carry an empty literal and an all-zero new index
+	removal := strings.Join([]string{
+		"diff --git a/bin b/bin",
+		"deleted file mode 100644",
+		"index 1a23e4be731d2f539deeea324686d000ccdfbfcd..0000000000000000000000000000000000000000",
+		"GIT binary patch",
+		"literal 0",
+		"HcmV?d00001",
+		"",
+		"literal 4",
+		"LcmYdfNa6wj0#*Rd",
+		"",
+		"",
+	}, "\n")
+	patches, err = ParsePatch(strings.NewReader(removal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, _, err := newTree.Apply(patches, ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = storeTestTree(t, r, []*TreeEntry{
+		{name: "dir", mode: ModeTree, Id: dir},
+		{name: "file", mode: ModeBlob, Id: blob("new 1\nnew 2\n" + strings.Replace(lines(1, 20), "line 11", "changed 11", 1))},
+	})
+	if removed.Id != expected {
+		t.Errorf("unexpected tree %s after binary removal, expected %s", removed.Id, expected)
+	}
+
+	// without the deletion header the empty result is a conflict
+	patches, err = ParsePatch(strings.NewReader(strings.Replace(removal, "deleted file mode 100644\n", "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, conflicts, err = newTree.Apply(patches, ApplyOptions{})
+	if err != ErrPatchConflict || len(conflicts) != 1 {
+		t.Errorf("unexpected conflicts %v: %v", conflicts, err)
+	}
+}
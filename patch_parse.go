@@ -0,0 +1,537 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrPatchCorrupt = errors.New("corrupt patch")
+)
+
+// binaryPatch is the data of a binary patch, either the new content or a
+// delta against the old one.
+type binaryPatch struct {
+	delta bool
+	data  []byte
+}
+
+// ParsePatch reads the file patches of a diff in the format of git diff,
+// including binary patches, or of a unified diff. Like git apply, one
+// leading directory is stripped from the names in the ---/+++ lines and
+// text outside of the patches is ignored.
+func ParsePatch(r io.Reader) ([]*FilePatch, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &patchParser{lines: splitLines(data)}
+	var patches []*FilePatch
+	for p.pos < len(p.lines) {
+		var fp *FilePatch
+		switch line := p.lines[p.pos]; {
+		case strings.HasPrefix(line, "diff --git "):
+			fp, err = p.parseGitPatch()
+		case strings.HasPrefix(line, "--- ") && p.pos+2 < len(p.lines) &&
+			strings.HasPrefix(p.lines[p.pos+1], "+++ ") &&
+			strings.HasPrefix(p.lines[p.pos+2], "@@ -"):
+			fp, err = p.parseUnifiedPatch()
+		default:
+			p.pos++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, fp)
+	}
+	return patches, nil
+}
+
+type patchParser struct {
+	lines []string
+	pos   int
+}
+
+func (p *patchParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: line %d: %s", ErrPatchCorrupt, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// line returns the current line without the newline, or "" at the end.
+func (p *patchParser) line() string {
+	if p.pos >= len(p.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(p.lines[p.pos], "\n")
+}
+
+func (p *patchParser) parseGitPatch() (*FilePatch, error) {
+	fp := &FilePatch{TreeChange: &TreeChange{Type: ChangeModified}}
+	oldPath, newPath := parseGitDiffNames(p.line()[len("diff --git "):])
+	p.pos++
+
+	var err error
+	parseMode := func(s string) EntryMode {
+		mode, e := strconv.ParseUint(s, 8, 32)
+		if e != nil && err == nil {
+			err = p.errorf("invalid mode %q", s)
+		}
+		return EntryMode(mode)
+	}
+	parseName := func(s string) string {
+		name, rest, ok := unquotePath(s)
+		if (!ok || len(rest) > 0) && err == nil {
+			err = p.errorf("invalid name %q", s)
+		}
+		return name
+	}
+
+header:
+	for ; p.pos < len(p.lines) && err == nil; p.pos++ {
+		line := p.line()
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			fp.OldMode = parseMode(line[len("old mode "):])
+		case strings.HasPrefix(line, "new mode "):
+			fp.NewMode = parseMode(line[len("new mode "):])
+		case strings.HasPrefix(line, "deleted file mode "):
+			fp.Type = ChangeDeleted
+			fp.OldMode = parseMode(line[len("deleted file mode "):])
+		case strings.HasPrefix(line, "new file mode "):
+			fp.Type = ChangeAdded
+			fp.NewMode = parseMode(line[len("new file mode "):])
+		case strings.HasPrefix(line, "rename from "):
+			fp.Type, oldPath = ChangeRenamed, parseName(line[len("rename from "):])
+		case strings.HasPrefix(line, "rename to "):
+			fp.Type, newPath = ChangeRenamed, parseName(line[len("rename to "):])
+		case strings.HasPrefix(line, "rename old "):
+			fp.Type, oldPath = ChangeRenamed, parseName(line[len("rename old "):])
+		case strings.HasPrefix(line, "rename new "):
+			fp.Type, newPath = ChangeRenamed, parseName(line[len("rename new "):])
+		case strings.HasPrefix(line, "copy from "):
+			fp.Type, oldPath = ChangeCopied, parseName(line[len("copy from "):])
+		case strings.HasPrefix(line, "copy to "):
+			fp.Type, newPath = ChangeCopied, parseName(line[len("copy to "):])
+		case strings.HasPrefix(line, "similarity index "):
+			fp.Similarity, _ = strconv.Atoi(strings.TrimSuffix(line[len("similarity index "):], "%"))
+		case strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "index "):
+			err = p.parseIndexLine(fp, line[len("index "):])
+		default:
+			break header
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch line := p.line(); {
+	case strings.HasPrefix(line, "--- "):
+		oldName, newName, err := p.parseNames()
+		if err != nil {
+			return nil, err
+		}
+		if len(oldName) > 0 {
+			oldPath = oldName
+		}
+		if len(newName) > 0 {
+			newPath = newName
+		}
+		fp.BlobDiff = &BlobDiff{}
+		if fp.Hunks, err = p.parseHunks(); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(line, "Binary files "):
+		p.pos++
+		fp.BlobDiff = &BlobDiff{Binary: true}
+	case line == "GIT binary patch":
+		p.pos++
+		fp.BlobDiff = &BlobDiff{Binary: true}
+		if fp.binaryPatch, err = p.parseBinary(); err != nil {
+			return nil, err
+		}
+		// the reverse patch is not needed
+		if line := p.line(); strings.HasPrefix(line, "literal ") || strings.HasPrefix(line, "delta ") {
+			if _, err = p.parseBinary(); err != nil {
+				return nil, err
+			}
+		}
+	case len(fp.oldIndex) > 0 && fp.Type != ChangeRenamed && fp.Type != ChangeCopied:
+		// an empty file is added or deleted
+		fp.BlobDiff = &BlobDiff{}
+	}
+
+	switch fp.Type {
+	case ChangeAdded:
+		oldPath = ""
+	case ChangeDeleted:
+		newPath = ""
+	}
+	if fp.Type != ChangeAdded && len(oldPath) == 0 || fp.Type != ChangeDeleted && len(newPath) == 0 {
+		return nil, p.errorf("git diff header lacks filename information")
+	}
+	fp.OldPath, fp.NewPath = oldPath, newPath
+	return fp, nil
+}
+
+// parseIndexLine reads the abbreviated ids of the files and, if given, the
+// mode of both.
+func (p *patchParser) parseIndexLine(fp *FilePatch, s string) error {
+	ids := s
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		ids = s[:i]
+		mode, err := strconv.ParseUint(s[i+1:], 8, 32)
+		if err != nil {
+			return p.errorf("invalid mode %q", s[i+1:])
+		}
+		fp.OldMode, fp.NewMode = EntryMode(mode), EntryMode(mode)
+	}
+
+	i := strings.Index(ids, "..")
+	if i < 0 {
+		return p.errorf("invalid index line")
+	}
+	fp.oldIndex, fp.newIndex = ids[:i], ids[i+2:]
+	if len(fp.oldIndex) == 40 {
+		fp.OldId, _ = NewIdFromString(fp.oldIndex)
+	}
+	if len(fp.newIndex) == 40 {
+		fp.NewId, _ = NewIdFromString(fp.newIndex)
+	}
+	return nil
+}
+
+// parseUnifiedPatch reads a patch without a git header.
+func (p *patchParser) parseUnifiedPatch() (*FilePatch, error) {
+	oldPath, newPath, err := p.parseNames()
+	if err != nil {
+		return nil, err
+	}
+
+	fp := &FilePatch{
+		TreeChange: &TreeChange{Type: ChangeModified, OldPath: oldPath, NewPath: newPath},
+		BlobDiff:   &BlobDiff{},
+	}
+	switch {
+	case len(oldPath) == 0 && len(newPath) == 0:
+		return nil, p.errorf("patch lacks filename information")
+	case len(oldPath) == 0:
+		fp.Type = ChangeAdded
+	case len(newPath) == 0:
+		fp.Type = ChangeDeleted
+	default:
+		// the file is patched in place, the old name may be a backup
+		fp.OldPath = newPath
+	}
+	if fp.Hunks, err = p.parseHunks(); err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// parseNames reads the ---/+++ lines. The names of /dev/null are empty.
+func (p *patchParser) parseNames() (oldName, newName string, err error) {
+	parse := func(prefix string) (string, error) {
+		line := p.line()
+		if !strings.HasPrefix(line, prefix) {
+			return "", p.errorf("expected %q", prefix)
+		}
+		p.pos++
+
+		name := line[len(prefix):]
+		if strings.HasPrefix(name, `"`) {
+			var ok bool
+			if name, _, ok = unquotePath(name); !ok {
+				return "", p.errorf("invalid name %q", line[len(prefix):])
+			}
+		} else if i := strings.IndexByte(name, '\t'); i >= 0 {
+			// a tab ends names with spaces or precedes a timestamp
+			name = name[:i]
+		}
+		if name == "/dev/null" {
+			return "", nil
+		}
+		return stripPathPrefix(name), nil
+	}
+
+	if oldName, err = parse("--- "); err != nil {
+		return "", "", err
+	}
+	if newName, err = parse("+++ "); err != nil {
+		return "", "", err
+	}
+	return oldName, newName, nil
+}
+
+// stripPathPrefix removes the leading directory, like "a/".
+func stripPathPrefix(name string) string {
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// parseGitDiffNames splits the names of a diff --git line. Unquoted names
+// with spaces are only found if both are the same, as for all but renames
+// and copies, which name the files in other header lines.
+func parseGitDiffNames(s string) (oldPath, newPath string) {
+	if strings.HasPrefix(s, `"`) {
+		name, rest, ok := unquotePath(s)
+		if !ok || !strings.HasPrefix(rest, " ") {
+			return "", ""
+		}
+		oldPath, rest = stripPathPrefix(name), rest[1:]
+		if strings.HasPrefix(rest, `"`) {
+			if name, rest, ok = unquotePath(rest); !ok || len(rest) > 0 {
+				return "", ""
+			}
+			return oldPath, stripPathPrefix(name)
+		}
+		return oldPath, stripPathPrefix(rest)
+	}
+
+	if i := strings.Index(s, ` "`); i >= 0 {
+		name, rest, ok := unquotePath(s[i+1:])
+		if !ok || len(rest) > 0 {
+			return "", ""
+		}
+		return stripPathPrefix(s[:i]), stripPathPrefix(name)
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' && stripPathPrefix(s[:i]) == stripPathPrefix(s[i+1:]) {
+			name := stripPathPrefix(s[i+1:])
+			return name, name
+		}
+	}
+	return "", ""
+}
+
+// unquotePath reads a name at the start of s which may be quoted like
+// quotePath does, and returns the text after it.
+func unquotePath(s string) (name, rest string, ok bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, "", true
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), s[i+1:], true
+		case c != '\\':
+			b.WriteByte(c)
+			continue
+		case i+1 == len(s):
+			return "", "", false
+		}
+
+		i++
+		switch c = s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'v':
+			b.WriteByte('\v')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\':
+			b.WriteByte(c)
+		default:
+			if i+3 > len(s) {
+				return "", "", false
+			}
+			n, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return "", "", false
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		}
+	}
+	return "", "", false
+}
+
+// parseHunks reads the hunks of a text patch.
+func (p *patchParser) parseHunks() ([]*Hunk, error) {
+	var hunks []*Hunk
+	for strings.HasPrefix(p.line(), "@@ -") {
+		h, err := p.parseHunkHeader(p.line())
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+
+		for oldLeft, newLeft := h.OldLines, h.NewLines; oldLeft > 0 || newLeft > 0; {
+			if p.pos >= len(p.lines) {
+				return nil, p.errorf("truncated hunk")
+			}
+			line := p.lines[p.pos]
+			if line == "\n" {
+				// an empty context line which lost its space
+				line = " \n"
+			}
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			op := DiffOp(line[0])
+			switch op {
+			case DiffEqual:
+				oldLeft--
+				newLeft--
+			case DiffDelete:
+				oldLeft--
+			case DiffInsert:
+				newLeft--
+			default:
+				return nil, p.errorf("unexpected line in hunk")
+			}
+			if oldLeft < 0 || newLeft < 0 {
+				return nil, p.errorf("hunk is longer than its header")
+			}
+			p.pos++
+
+			content := line[1:]
+			if strings.HasPrefix(p.line(), `\`) {
+				// no newline at end of file
+				content = strings.TrimSuffix(content, "\n")
+				p.pos++
+			}
+			h.Lines = append(h.Lines, HunkLine{Op: op, Content: content})
+		}
+		hunks = append(hunks, h)
+	}
+	if len(hunks) == 0 {
+		return nil, p.errorf("patch without hunks")
+	}
+	return hunks, nil
+}
+
+func (p *patchParser) parseHunkHeader(line string) (*Hunk, error) {
+	end := strings.Index(line[len("@@ -"):], " @@")
+	if end < 0 {
+		return nil, p.errorf("invalid hunk header")
+	}
+	ranges := strings.Fields(line[len("@@ -") : len("@@ -")+end])
+	if len(ranges) != 2 || !strings.HasPrefix(ranges[1], "+") {
+		return nil, p.errorf("invalid hunk header")
+	}
+
+	parseRange := func(s string) (start, lines int, err error) {
+		lines = 1
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			if lines, err = strconv.Atoi(s[i+1:]); err != nil {
+				return 0, 0, err
+			}
+			s = s[:i]
+		}
+		start, err = strconv.Atoi(s)
+		return start, lines, err
+	}
+
+	h := &Hunk{Section: strings.TrimPrefix(line[len("@@ -")+end+len(" @@"):], " ")}
+	var err1, err2 error
+	h.OldStart, h.OldLines, err1 = parseRange(ranges[0])
+	h.NewStart, h.NewLines, err2 = parseRange(ranges[1][1:])
+	if err1 != nil || err2 != nil || h.OldLines < 0 || h.NewLines < 0 {
+		return nil, p.errorf("invalid hunk header")
+	}
+	return h, nil
+}
+
+// parseBinary reads a "literal" or "delta" block of a binary patch: lines
+// of base85 encoded, deflated data, each starting with its length, up to
+// an empty line.
+func (p *patchParser) parseBinary() (*binaryPatch, error) {
+	bp := &binaryPatch{}
+	line := p.line()
+	var sizeStr string
+	switch {
+	case strings.HasPrefix(line, "literal "):
+		sizeStr = line[len("literal "):]
+	case strings.HasPrefix(line, "delta "):
+		bp.delta, sizeStr = true, line[len("delta "):]
+	default:
+		return nil, p.errorf("unrecognized binary patch")
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return nil, p.errorf("invalid binary patch size")
+	}
+	p.pos++
+
+	var deflated []byte
+	for ; p.line() != ""; p.pos++ {
+		line := p.line()
+		var n int
+		switch c := line[0]; {
+		case 'A' <= c && c <= 'Z':
+			n = int(c-'A') + 1
+		case 'a' <= c && c <= 'z':
+			n = int(c-'a') + 27
+		default:
+			return nil, p.errorf("invalid binary patch line")
+		}
+		if (len(line)-1)%5 != 0 || (len(line)-1)/5*4 < n || (len(line)-1)/5*4-n >= 4 {
+			return nil, p.errorf("invalid binary patch line")
+		}
+		data, ok := decode85(line[1:])
+		if !ok {
+			return nil, p.errorf("invalid base85 data")
+		}
+		deflated = append(deflated, data[:n]...)
+	}
+	p.pos++
+
+	zr, err := zlib.NewReader(bytes.NewReader(deflated))
+	if err != nil {
+		return nil, p.errorf("invalid binary patch data")
+	}
+	defer zr.Close()
+	if bp.data, err = ioutil.ReadAll(zr); err != nil || len(bp.data) != size {
+		return nil, p.errorf("invalid binary patch data")
+	}
+	return bp, nil
+}
+
+// base85Alphabet is the alphabet of git's base85 encoding.
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// decode85 decodes groups of five characters to four bytes each.
+func decode85(s string) ([]byte, bool) {
+	data := make([]byte, 0, len(s)/5*4)
+	for ; len(s) >= 5; s = s[5:] {
+		var acc uint64
+		for i := 0; i < 5; i++ {
+			v := strings.IndexByte(base85Alphabet, s[i])
+			if v < 0 {
+				return nil, false
+			}
+			acc = acc*85 + uint64(v)
+		}
+		if acc > 0xffffffff {
+			return nil, false
+		}
+		data = append(data, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+	}
+	return data, len(s) == 0
+}
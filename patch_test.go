@@ -0,0 +1,89 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePatch(t *testing.T) {
+	r := copyTestRepo(t)
+	blob := func(content string) sha1 {
+		id, err := r.StoreObjectLoose(ObjectBlob, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	oldRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "main.go", mode: ModeBlob, Id: blob("package main\n\nfunc main() {\n\ta()\n\tb()\n\tc()\n\td()\n}\n")},
+		{name: "old name", mode: ModeBlob, Id: blob("moved\n")},
+	})
+	newRoot := storeTestTree(t, r, []*TreeEntry{
+		{name: "main.go", mode: ModeBlob, Id: blob("package main\n\nfunc main() {\n\ta()\n\tb()\n\tc()\n\te()\n}")},
+		{name: "new", mode: ModeExec, Id: blob("")},
+		{name: "new name", mode: ModeBlob, Id: blob("moved\n")},
+	})
+
+	changes, err := r.DiffTrees(oldRoot.String(), newRoot.String(), TreeDiffOptions{DetectRenames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := r.Patch(changes, LineDiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePatch(&buf, patches, PatchOptions{Abbrev: 7}); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"diff --git a/main.go b/main.go",
+		"index 3c5f728..74c5c36 100644",
+		"--- a/main.go",
+		"+++ b/main.go",
+		"@@ -4,5 +4,5 @@ func main() {",
+		" \ta()",
+		" \tb()",
+		" \tc()",
+		"-\td()",
+		"-}",
+		"+\te()",
+		"+}",
+		"\\ No newline at end of file",
+		"diff --git a/new b/new",
+		"new file mode 100755",
+		"index 0000000..e69de29",
+		"diff --git a/old name b/new name",
+		"similarity index 100%",
+		"rename from old name",
+		"rename to new name",
+		"",
+	}, "\n")
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected patch:\n%s", got)
+	}
+
+	buf.Reset()
+	if err := WriteStat(&buf, patches, 80); err != nil {
+		t.Fatal(err)
+	}
+	expected = " main.go              | 4 ++--\n" +
+		" new                  | 0\n" +
+		" old name => new name | 0\n" +
+		" 3 files changed, 2 insertions(+), 2 deletions(-)\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected stat:\n%s", got)
+	}
+
+	buf.Reset()
+	if err := WriteNumstat(&buf, patches); err != nil {
+		t.Fatal(err)
+	}
+	expected = "2\t2\tmain.go\n0\t0\tnew\n0\t0\told name => new name\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected numstat:\n%s", got)
+	}
+}
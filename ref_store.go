@@ -0,0 +1,90 @@
+package git
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrRefNotExist = errors.New("reference does not exist")
+	ErrRefLoop     = errors.New("too many levels of symbolic references")
+)
+
+// Git gives up after the same number of nested symbolic references.
+const maxSymbolicRefDepth = 5
+
+// RefStore is a storage backend for references.
+type RefStore interface {
+	// Ref returns the reference called name without following symbolic
+	// references. ErrRefNotExist is returned if there is no such reference.
+	Ref(name string) (*Reference, error)
+
+	// Refs returns all references below refs/ whose name starts with
+	// prefix, sorted by name.
+	Refs(prefix string) ([]*Reference, error)
+
+	// SetRef writes ref, replacing any previous value.
+	SetRef(ref *Reference) error
+
+	// Update applies all updates atomically: either every reference is
+	// changed or none is.
+	Update(updates ...*RefUpdate) error
+
+	// Rename moves the reference oldName, together with its log, to
+	// newName, which must not exist yet. The rename is logged as
+	// committer.
+	Rename(oldName, newName string, committer *Signature) error
+
+	// Reflog returns the log of the reference, oldest entry first.
+	Reflog(name string) ([]*ReflogEntry, error)
+}
+
+// openRefStore returns the reference backend used by the repository at
+// repoPath.
+func openRefStore(repoPath string) RefStore {
+	return newRefStore(repoPath, readCommonDir(repoPath))
+}
+
+// newRefStore returns the reference backend chosen by extensions.refStorage.
+// Repositories without a config are recognized by their reftable
+// directory. Reftables are only read from the common directory.
+func newRefStore(gitDir, commonDir string) RefStore {
+	if cfg, err := OpenConfigFile(filepath.Join(commonDir, "config")); err == nil {
+		if storage, err := cfg.Get("extensions.refStorage"); err == nil {
+			if storage == "reftable" {
+				return newReftableStore(commonDir)
+			}
+			return newFileRefStore(gitDir, commonDir)
+		}
+	}
+
+	if isFile(filepath.Join(commonDir, "reftable", "tables.list")) {
+		return newReftableStore(commonDir)
+	}
+	return newFileRefStore(gitDir, commonDir)
+}
+
+// resolveRef follows symbolic references starting at name and returns the
+// first reference pointing to an object.
+func resolveRef(store RefStore, name string) (*Reference, error) {
+	for depth := 0; ; depth++ {
+		ref, err := store.Ref(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ref.IsSymbolic() {
+			return ref, nil
+		}
+		if depth >= maxSymbolicRefDepth {
+			return nil, ErrRefLoop
+		}
+		name = ref.Target
+	}
+}
+
+func sortRefs(refs []*Reference) {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+}
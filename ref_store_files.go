@@ -0,0 +1,572 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// fileRefStore keeps references the classic way: one loose file per
+// reference below the repository path, plus the packed-refs file.
+// Loose references take precedence over packed ones.
+//
+// In a linked work tree, HEAD and the other per-worktree references are
+// kept in its own git directory, everything else in the common directory.
+type fileRefStore struct {
+	path   string // common directory
+	gitDir string
+
+	// the last packed-refs file read, shared by concurrent callers
+	mu         sync.Mutex
+	packed     *packedRefs
+	packedStat os.FileInfo
+}
+
+func newFileRefStore(gitDir, commonDir string) *fileRefStore {
+	return &fileRefStore{path: commonDir, gitDir: gitDir}
+}
+
+// dir returns the directory the reference called name is stored in.
+func (s *fileRefStore) dir(name string) string {
+	if isPerWorktreeRef(name) {
+		return s.gitDir
+	}
+	return s.path
+}
+
+func (s *fileRefStore) refPath(name string) string {
+	return filepath.Join(s.dir(name), filepath.FromSlash(name))
+}
+
+func (s *fileRefStore) Ref(name string) (*Reference, error) {
+	ref, err := s.looseRef(name)
+	if err != ErrRefNotExist {
+		return ref, err
+	}
+
+	packed, err := s.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	if packed == nil {
+		return nil, ErrRefNotExist
+	}
+	ref, err = packed.ref(name)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, ErrRefNotExist
+	}
+	return ref, nil
+}
+
+func (s *fileRefStore) Refs(prefix string) ([]*Reference, error) {
+	// start at the deepest directory covered by the prefix
+	dir := "refs"
+	if strings.HasPrefix(prefix, "refs/") {
+		dir = path.Dir(prefix + "x")
+	}
+
+	byName := make(map[string]*Reference)
+
+	packed, err := s.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	if packed != nil {
+		all, err := packed.all()
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range all {
+			byName[ref.Name] = ref
+		}
+	}
+
+	names, err := readRefDir(s.path, dir)
+	if err != nil {
+		return nil, err
+	}
+	if s.gitDir != s.path {
+		// per-worktree references come from the work tree's git directory
+		own, err := readRefDir(s.gitDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		names = append(filterRefNames(names, false), filterRefNames(own, true)...)
+	}
+	for _, name := range names {
+		ref, err := s.looseRef(name)
+		if err == ErrRefNotExist {
+			// removed in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		byName[name] = ref
+	}
+
+	refs := make([]*Reference, 0, len(byName))
+	for name, ref := range byName {
+		if strings.HasPrefix(name, prefix) {
+			refs = append(refs, ref)
+		}
+	}
+	sortRefs(refs)
+	return refs, nil
+}
+
+func (s *fileRefStore) SetRef(ref *Reference) error {
+	lock, err := newLockFile(s.refPath(ref.Name))
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	if err := lock.Write(looseRefContent(ref)); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+func (s *fileRefStore) Update(updates ...*RefUpdate) error {
+	if err := checkRefUpdates(updates); err != nil {
+		return err
+	}
+
+	locks := make([]*lockFile, 0, len(updates))
+	defer func() {
+		for _, lock := range locks {
+			lock.Rollback()
+		}
+	}()
+
+	// lock every reference and prepare its new value
+	deleted := make(map[string]bool)
+	entries := make([]*ReflogEntry, len(updates))
+	loose := make([]*Reference, len(updates))
+	for i, u := range updates {
+		lock, err := newLockFile(s.refPath(u.Name))
+		if err != nil {
+			return err
+		}
+		locks = append(locks, lock)
+
+		if err := checkRefOld(s.Ref, u); err != nil {
+			return err
+		}
+		if ref, err := s.looseRef(u.Name); err == nil {
+			loose[i] = ref
+		} else if err != ErrRefNotExist {
+			return err
+		}
+
+		if u.deletes() {
+			deleted[u.Name] = true
+			continue
+		}
+		if err := lock.Write(looseRefContent(&Reference{Name: u.Name, Id: u.New, Target: u.Target})); err != nil {
+			return err
+		}
+		old, new := u.loggedIds(s)
+		entries[i] = &ReflogEntry{Old: old, New: new, Committer: u.committer(), Message: u.Message}
+	}
+
+	// nobody can create a conflicting reference while they are locked
+	if err := checkRefConflicts(s, updates); err != nil {
+		return err
+	}
+
+	// deleted references have to leave packed-refs as well
+	packedLock, err := s.preparePackedDelete(deleted)
+	if err != nil {
+		return err
+	}
+
+	// packed-refs goes last, so that a failure can be undone by restoring
+	// the loose references
+	applied := 0
+	err = func() error {
+		for i, u := range updates {
+			if u.deletes() {
+				if err := os.Remove(s.refPath(u.Name)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			} else if err := locks[i].Commit(); err != nil {
+				return err
+			}
+			applied = i + 1
+		}
+		if packedLock != nil {
+			return packedLock.Commit()
+		}
+		return nil
+	}()
+	if packedLock != nil {
+		packedLock.Rollback()
+		s.mu.Lock()
+		s.packed = nil
+		s.mu.Unlock()
+	}
+	if err != nil {
+		s.restoreLooseRefs(updates[:applied], loose[:applied])
+		return err
+	}
+
+	for i, u := range updates {
+		if !u.deletes() {
+			if err := s.appendReflog(u.Name, entries[i]); err != nil {
+				return err
+			}
+			// updates of the branch a HEAD points to show up in its log
+			// as well
+			for _, name := range headsOf(u.Name) {
+				head, err := s.looseRef(name)
+				if err != nil || head.Target != u.Name {
+					continue
+				}
+				if err := s.appendReflog(name, entries[i]); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		locks[i].Rollback()
+		removeEmptyDirs(s.dir(u.Name), path.Dir(u.Name))
+
+		if err := os.Remove(s.logPath(u.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyDirs(filepath.Join(s.dir(u.Name), "logs"), path.Dir(u.Name))
+	}
+
+	return nil
+}
+
+// restoreLooseRefs undoes the updates of a failed transaction. loose holds
+// the loose reference each update replaced, nil if there was none.
+func (s *fileRefStore) restoreLooseRefs(updates []*RefUpdate, loose []*Reference) {
+	for i, u := range updates {
+		if loose[i] == nil {
+			os.Remove(s.refPath(u.Name))
+			continue
+		}
+		ioutil.WriteFile(s.refPath(u.Name), looseRefContent(loose[i]), 0644)
+	}
+}
+
+func (s *fileRefStore) Rename(oldName, newName string, committer *Signature) error {
+	if !IsValidRefName(newName) {
+		return fmt.Errorf("invalid reference name %q", newName)
+	}
+	ref, err := s.Ref(oldName)
+	if err != nil {
+		return err
+	}
+	if ref.IsSymbolic() {
+		return fmt.Errorf("cannot rename symbolic reference %q", oldName)
+	}
+	if _, err := s.Ref(newName); err == nil {
+		return ErrRefChanged
+	} else if err != ErrRefNotExist {
+		return err
+	}
+	if err := checkRefNameConflict(s, newName, map[string]bool{oldName: true}); err != nil {
+		return err
+	}
+
+	// keep the log out of the way, deleting oldName would remove it
+	oldLog, newLog := s.logPath(oldName), s.logPath(newName)
+	tmpLog, err := s.stashLog(oldName)
+	if err != nil {
+		return err
+	}
+
+	// oldName goes first, newName may need its place for a directory
+	if err := s.Update(&RefUpdate{Name: oldName, Old: ref.Id, CheckOld: true}); err != nil {
+		if tmpLog != "" {
+			os.Rename(tmpLog, oldLog)
+		}
+		return err
+	}
+
+	movedLog := false
+	if tmpLog != "" {
+		if err = os.MkdirAll(filepath.Dir(newLog), 0755); err == nil {
+			err = os.Rename(tmpLog, newLog)
+		}
+		movedLog = err == nil
+	}
+	if err == nil {
+		err = s.Update(&RefUpdate{Name: newName, New: ref.Id, CheckOld: true,
+			Committer: committer, Message: "Branch: renamed " + oldName + " to " + newName})
+	}
+	if err == nil {
+		return nil
+	}
+
+	// put oldName and its log back
+	if movedLog && os.Rename(newLog, tmpLog) != nil {
+		tmpLog = ""
+	}
+	removeEmptyDirs(filepath.Join(s.dir(newName), "logs"), path.Dir(newName))
+	if tmpLog != "" && os.MkdirAll(filepath.Dir(oldLog), 0755) == nil {
+		os.Rename(tmpLog, oldLog)
+	}
+	s.SetRef(ref)
+	return err
+}
+
+// stashLog moves the log of the reference to a new temporary file and
+// returns its path, or an empty path if the reference is not logged.
+func (s *fileRefStore) stashLog(name string) (string, error) {
+	if !isFile(s.logPath(name)) {
+		return "", nil
+	}
+
+	f, err := ioutil.TempFile(filepath.Join(s.dir(name), "logs"), "tmp-renamed-log")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if err := os.Rename(s.logPath(name), f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (s *fileRefStore) Reflog(name string) ([]*ReflogEntry, error) {
+	data, err := ioutil.ReadFile(s.logPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseReflog(data)
+}
+
+// appendReflog adds entry to the log of the reference, if the reference
+// is logged.
+func (s *fileRefStore) appendReflog(name string, entry *ReflogEntry) error {
+	logPath := s.logPath(name)
+	if !shouldLogRef(name) && !isFile(logPath) {
+		return nil
+	}
+	return appendReflog(logPath, entry)
+}
+
+func (s *fileRefStore) logPath(name string) string {
+	return filepath.Join(s.dir(name), "logs", filepath.FromSlash(name))
+}
+
+// isPerWorktreeRef reports whether each work tree has its own reference
+// called name: HEAD and other pseudo references, and everything below
+// refs/worktree/, refs/bisect/ and refs/rewritten/.
+func isPerWorktreeRef(name string) bool {
+	return !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/worktree/") ||
+		strings.HasPrefix(name, "refs/bisect/") || strings.HasPrefix(name, "refs/rewritten/")
+}
+
+// removeEmptyDirs removes the directory dir below base and its parents as
+// long as they are empty, but keeps the top two levels like refs/heads.
+func removeEmptyDirs(base, dir string) {
+	for strings.Count(dir, "/") >= 2 {
+		if err := os.Remove(filepath.Join(base, filepath.FromSlash(dir))); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// preparePackedDelete locks packed-refs and writes a version without the
+// deleted references to the lock file. It returns nil if none of the
+// references is packed.
+func (s *fileRefStore) preparePackedDelete(deleted map[string]bool) (*lockFile, error) {
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	packedPath := filepath.Join(s.path, "packed-refs")
+	if !isFile(packedPath) {
+		return nil, nil
+	}
+
+	lock, err := newLockFile(packedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// read again now that nobody else can change it
+	data, err := ioutil.ReadFile(packedPath)
+	if err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	packed, err := parsePackedRefs(data)
+	if err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+
+	kept := make([]*Reference, 0, len(packed.refs))
+	for _, ref := range packed.refs {
+		if !deleted[ref.Name] {
+			kept = append(kept, ref)
+		}
+	}
+	if len(kept) == len(packed.refs) {
+		lock.Rollback()
+		return nil, nil
+	}
+
+	if err := lock.Write(packed.encode(kept)); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (s *fileRefStore) looseRef(name string) (*Reference, error) {
+	refPath := s.refPath(name)
+	if !isFile(refPath) {
+		return nil, ErrRefNotExist
+	}
+
+	data, err := ioutil.ReadFile(refPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRefNotExist
+		}
+		return nil, err
+	}
+
+	return parseLooseRef(name, data)
+}
+
+func filterRefNames(names []string, perWorktree bool) []string {
+	var filtered []string
+	for _, name := range names {
+		if isPerWorktreeRef(name) == perWorktree {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// readRefDir returns the names of all loose references below dir in the
+// git directory base.
+func readRefDir(base, dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(base, filepath.FromSlash(dir)))
+	if err != nil {
+		if os.IsNotExist(err) || isNotDir(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		return nil, err
+	}
+
+	fis, err := f.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if strings.Contains(fi.Name(), ".DS_Store") || strings.HasSuffix(fi.Name(), ".lock") {
+			continue
+		}
+
+		name := dir + "/" + fi.Name()
+		if fi.IsDir() {
+			subnames, err := readRefDir(base, name)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, subnames...)
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// packedRefs returns the content of the packed-refs file, nil if there is
+// none. The file is only read again once it changed on disk.
+func (s *fileRefStore) packedRefs() (*packedRefs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	packedPath := filepath.Join(s.path, "packed-refs")
+	fi, err := os.Stat(packedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.packed, s.packedStat = nil, nil
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if s.packed != nil && os.SameFile(fi, s.packedStat) &&
+		fi.ModTime().Equal(s.packedStat.ModTime()) && fi.Size() == s.packedStat.Size() {
+		return s.packed, nil
+	}
+
+	data, err := ioutil.ReadFile(packedPath)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := parsePackedRefsHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.packed, s.packedStat = packed, fi
+	return packed, nil
+}
+
+func isNotDir(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == syscall.ENOTDIR
+	}
+	return false
+}
+
+func parseLooseRef(name string, data []byte) (*Reference, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("ref: ")) {
+		return &Reference{Name: name, Target: string(data[5:])}, nil
+	}
+
+	if !IsSha1(string(data)) {
+		return nil, fmt.Errorf("bad reference %s: %q", name, data)
+	}
+	id, err := NewIdFromString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &Reference{Name: name, Id: id}, nil
+}
+
+func looseRefContent(ref *Reference) []byte {
+	if ref.IsSymbolic() {
+		return []byte("ref: " + ref.Target + "\n")
+	}
+	return []byte(ref.Id.String() + "\n")
+}
@@ -0,0 +1,120 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// namespaceRefStore shows only the references below refs/namespaces/<ns>/
+// of another store, with the prefix stripped, the way git does for
+// GIT_NAMESPACE.
+type namespaceRefStore struct {
+	store  RefStore
+	prefix string // e.g. "refs/namespaces/foo/"
+}
+
+// namespaceRefPrefix returns the reference prefix of the namespace ns.
+// Nested namespaces are separated by slashes: "a/b" is stored below
+// "refs/namespaces/a/refs/namespaces/b/".
+func namespaceRefPrefix(ns string) (string, error) {
+	var prefix string
+	for _, part := range strings.Split(strings.Trim(ns, "/"), "/") {
+		prefix += "refs/namespaces/" + part + "/"
+	}
+	if !IsValidRefName(prefix + "HEAD") {
+		return "", fmt.Errorf("invalid namespace %q", ns)
+	}
+	return prefix, nil
+}
+
+// outside converts a name inside the namespace to the name in the
+// underlying store.
+func (s *namespaceRefStore) outside(name string) string {
+	return s.prefix + name
+}
+
+// inside converts a reference of the underlying store into the namespace.
+// Symbolic references to anything outside the namespace cannot be
+// represented, inside reports false for them.
+func (s *namespaceRefStore) inside(ref *Reference) (*Reference, bool) {
+	if ref.IsSymbolic() && !strings.HasPrefix(ref.Target, s.prefix) {
+		return nil, false
+	}
+	r := *ref
+	r.Name = strings.TrimPrefix(r.Name, s.prefix)
+	r.Target = strings.TrimPrefix(r.Target, s.prefix)
+	return &r, true
+}
+
+func (s *namespaceRefStore) Ref(name string) (*Reference, error) {
+	ref, err := s.store.Ref(s.outside(name))
+	if err != nil {
+		return nil, err
+	}
+	r, ok := s.inside(ref)
+	if !ok {
+		return nil, ErrRefNotExist
+	}
+	return r, nil
+}
+
+func (s *namespaceRefStore) Refs(prefix string) ([]*Reference, error) {
+	refs, err := s.store.Refs(s.outside(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var inside []*Reference
+	for _, ref := range refs {
+		// skip the namespace's HEAD and anything else outside refs/
+		if r, ok := s.inside(ref); ok && strings.HasPrefix(r.Name, "refs/") {
+			inside = append(inside, r)
+		}
+	}
+	return inside, nil
+}
+
+func (s *namespaceRefStore) SetRef(ref *Reference) error {
+	r := *ref
+	r.Name = s.outside(r.Name)
+	if r.IsSymbolic() {
+		r.Target = s.outside(r.Target)
+	}
+	return s.store.SetRef(&r)
+}
+
+func (s *namespaceRefStore) Update(updates ...*RefUpdate) error {
+	outside := make([]*RefUpdate, len(updates))
+	for i, u := range updates {
+		o := *u
+		o.Name = s.outside(o.Name)
+		if len(o.Target) > 0 {
+			o.Target = s.outside(o.Target)
+		}
+		outside[i] = &o
+	}
+	return s.store.Update(outside...)
+}
+
+func (s *namespaceRefStore) Rename(oldName, newName string, committer *Signature) error {
+	return s.store.Rename(s.outside(oldName), s.outside(newName), committer)
+}
+
+func (s *namespaceRefStore) Reflog(name string) ([]*ReflogEntry, error) {
+	return s.store.Reflog(s.outside(name))
+}
+
+// Namespace returns a view of the repository which only sees the references
+// of the namespace ns, like git does with GIT_NAMESPACE set. Branches, tags,
+// HEAD and the reference listing all work inside the namespace, while
+// objects are shared with repo.
+func (repo *Repository) Namespace(ns string) (*Repository, error) {
+	prefix, err := namespaceRefPrefix(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	view := *repo
+	view.refs = &namespaceRefStore{store: repo.refs, prefix: prefix}
+	return &view, nil
+}
@@ -0,0 +1,490 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reftableStore keeps references in a stack of reftables below
+// "reftable/". The file "reftable/tables.list" names the tables, oldest
+// first. Every update adds a new table on top of the stack, and small
+// tables are merged again to keep the stack short.
+type reftableStore struct {
+	path string
+
+	// tables never change once written, so the tables of the last stack
+	// read are cached by name
+	mu     sync.Mutex
+	tables map[string]*reftable
+}
+
+func newReftableStore(repoPath string) *reftableStore {
+	return &reftableStore{
+		path:   filepath.Join(repoPath, "reftable"),
+		tables: make(map[string]*reftable),
+	}
+}
+
+func (s *reftableStore) listPath() string {
+	return filepath.Join(s.path, "tables.list")
+}
+
+// stack returns the names and the tables of the current stack, oldest
+// first.
+func (s *reftableStore) stack() ([]string, []*reftable, error) {
+	for retry := 0; ; retry++ {
+		names, tables, err := s.readStack()
+		// the stack may have been compacted in the meantime
+		if os.IsNotExist(err) && retry < 3 {
+			continue
+		}
+		return names, tables, err
+	}
+}
+
+func (s *reftableStore) readStack() ([]string, []*reftable, error) {
+	data, err := ioutil.ReadFile(s.listPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	cached := s.tables
+	s.mu.Unlock()
+
+	// tables no longer on the stack leave the cache
+	names := strings.Fields(string(data))
+	tables := make([]*reftable, len(names))
+	current := make(map[string]*reftable, len(names))
+	for i, name := range names {
+		t, ok := cached[name]
+		if !ok {
+			data, err := ioutil.ReadFile(filepath.Join(s.path, name))
+			if err != nil {
+				return nil, nil, err
+			}
+			if t, err = decodeReftable(data); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		tables[i] = t
+		current[name] = t
+	}
+
+	s.mu.Lock()
+	s.tables = current
+	s.mu.Unlock()
+	return names, tables, nil
+}
+
+func (s *reftableStore) Ref(name string) (*Reference, error) {
+	_, tables, err := s.stack()
+	if err != nil {
+		return nil, err
+	}
+	return mergedRef(tables, name)
+}
+
+// mergedRef looks name up in tables, newer tables shadow older ones.
+func mergedRef(tables []*reftable, name string) (*Reference, error) {
+	for i := len(tables) - 1; i >= 0; i-- {
+		if rec := tables[i].ref(name); rec != nil {
+			if rec.deleted {
+				return nil, ErrRefNotExist
+			}
+			ref := rec.Reference
+			return &ref, nil
+		}
+	}
+	return nil, ErrRefNotExist
+}
+
+func (s *reftableStore) Refs(prefix string) ([]*Reference, error) {
+	_, tables, err := s.stack()
+	if err != nil {
+		return nil, err
+	}
+
+	// only references below refs/ are listed
+	if strings.HasPrefix("refs/", prefix) {
+		prefix = "refs/"
+	} else if !strings.HasPrefix(prefix, "refs/") {
+		return nil, nil
+	}
+
+	var refs []*Reference
+	for _, rec := range mergeRefRecords(tables, prefix, false) {
+		ref := rec.Reference
+		refs = append(refs, &ref)
+	}
+	return refs, nil
+}
+
+// mergeRefRecords returns the newest record of every reference in tables
+// whose name starts with prefix, sorted by name. Deletion records are
+// dropped unless keepDeleted is set. The tables are already sorted, so
+// they are merged starting at prefix.
+func mergeRefRecords(tables []*reftable, prefix string, keepDeleted bool) []*refRecord {
+	pos := make([]int, len(tables))
+	for i, t := range tables {
+		pos[i] = sort.Search(len(t.refs), func(j int) bool {
+			return t.refs[j].Name >= prefix
+		})
+	}
+
+	var recs []*refRecord
+	for {
+		// the smallest name left, newer tables win
+		var next *refRecord
+		for i, t := range tables {
+			if pos[i] == len(t.refs) {
+				continue
+			}
+			if rec := t.refs[pos[i]]; !strings.HasPrefix(rec.Name, prefix) {
+				pos[i] = len(t.refs)
+			} else if next == nil || rec.Name <= next.Name {
+				next = rec
+			}
+		}
+		if next == nil {
+			return recs
+		}
+
+		for i, t := range tables {
+			if pos[i] < len(t.refs) && t.refs[pos[i]].Name == next.Name {
+				pos[i]++
+			}
+		}
+		if keepDeleted || !next.deleted {
+			recs = append(recs, next)
+		}
+	}
+}
+
+// mergedLogs returns the log of name in tables, newest entry first.
+// Records of newer tables shadow those with the same update index in older
+// tables, deletion records are dropped.
+func mergedLogs(tables []*reftable, name string) []*logRecord {
+	byIndex := make(map[uint64]*logRecord)
+	for _, t := range tables {
+		for _, rec := range t.logsOf(name) {
+			byIndex[rec.updateIndex] = rec
+		}
+	}
+
+	recs := make([]*logRecord, 0, len(byIndex))
+	for _, rec := range byIndex {
+		if !rec.deleted {
+			recs = append(recs, rec)
+		}
+	}
+	sortLogRecords(recs)
+	return recs
+}
+
+// mergeLogRecords returns the newest record of every log entry of all
+// references in tables, sorted like a table. Deletion records are dropped
+// unless keepDeleted is set. Only compaction needs all of them.
+func mergeLogRecords(tables []*reftable, keepDeleted bool) []*logRecord {
+	type logKey struct {
+		name        string
+		updateIndex uint64
+	}
+
+	byKey := make(map[logKey]*logRecord)
+	for _, t := range tables {
+		for _, rec := range t.logs {
+			byKey[logKey{rec.name, rec.updateIndex}] = rec
+		}
+	}
+
+	recs := make([]*logRecord, 0, len(byKey))
+	for _, rec := range byKey {
+		if keepDeleted || !rec.deleted {
+			recs = append(recs, rec)
+		}
+	}
+	sortLogRecords(recs)
+	return recs
+}
+
+func (s *reftableStore) Reflog(name string) ([]*ReflogEntry, error) {
+	_, tables, err := s.stack()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ReflogEntry
+	for _, rec := range mergedLogs(tables, name) {
+		entries = append(entries, rec.entry)
+	}
+
+	// oldest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+func (s *reftableStore) SetRef(ref *Reference) error {
+	return s.addTable(func(tables []*reftable, next uint64) ([]*refRecord, []*logRecord, uint64, error) {
+		rec := &refRecord{Reference: *ref, updateIndex: next}
+		return []*refRecord{rec}, nil, next, nil
+	})
+}
+
+func (s *reftableStore) Update(updates ...*RefUpdate) error {
+	if err := checkRefUpdates(updates); err != nil {
+		return err
+	}
+
+	return s.addTable(func(tables []*reftable, next uint64) ([]*refRecord, []*logRecord, uint64, error) {
+		// the stack is locked, so it cannot change anymore
+		if err := checkRefConflicts(s, updates); err != nil {
+			return nil, nil, 0, err
+		}
+
+		lookup := func(name string) (*Reference, error) {
+			return mergedRef(tables, name)
+		}
+
+		var refs []*refRecord
+		var logs []*logRecord
+		for _, u := range updates {
+			if err := checkRefOld(lookup, u); err != nil {
+				return nil, nil, 0, err
+			}
+
+			if u.deletes() {
+				refs = append(refs, &refRecord{Reference: Reference{Name: u.Name}, updateIndex: next, deleted: true})
+				// the log goes away with the reference
+				for _, rec := range mergedLogs(tables, u.Name) {
+					logs = append(logs, &logRecord{name: rec.name, updateIndex: rec.updateIndex, deleted: true})
+				}
+				continue
+			}
+			refs = append(refs, &refRecord{Reference: Reference{Name: u.Name, Id: u.New, Target: u.Target}, updateIndex: next})
+
+			old, new := u.loggedIds(s)
+			entry := &ReflogEntry{Old: old, New: new, Committer: u.committer(), Message: u.Message}
+			if shouldLogRef(u.Name) || hasLogRecords(tables, u.Name) {
+				logs = append(logs, &logRecord{name: u.Name, updateIndex: next, entry: entry})
+			}
+			// updates of the branch a HEAD points to show up in its log
+			// as well
+			for _, name := range headsOf(u.Name) {
+				if head, err := lookup(name); err == nil && head.Target == u.Name {
+					logs = append(logs, &logRecord{name: name, updateIndex: next, entry: entry})
+				}
+			}
+		}
+		return refs, logs, next, nil
+	})
+}
+
+func (s *reftableStore) Rename(oldName, newName string, committer *Signature) error {
+	if !IsValidRefName(newName) {
+		return fmt.Errorf("invalid reference name %q", newName)
+	}
+	if err := checkRefNameConflict(s, newName, map[string]bool{oldName: true}); err != nil {
+		return err
+	}
+
+	return s.addTable(func(tables []*reftable, next uint64) ([]*refRecord, []*logRecord, uint64, error) {
+		ref, err := mergedRef(tables, oldName)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if ref.IsSymbolic() {
+			return nil, nil, 0, fmt.Errorf("cannot rename symbolic reference %q", oldName)
+		}
+		if _, err := mergedRef(tables, newName); err == nil {
+			return nil, nil, 0, ErrRefChanged
+		}
+
+		refs := []*refRecord{{Reference: Reference{Name: oldName}, updateIndex: next, deleted: true}}
+		var logs []*logRecord
+
+		// move the log: delete the old entries and copy them to the new
+		// name with fresh update indexes, oldest first
+		var moved []*logRecord
+		for _, rec := range mergedLogs(tables, oldName) {
+			logs = append(logs, &logRecord{name: oldName, updateIndex: rec.updateIndex, deleted: true})
+			moved = append(moved, rec)
+		}
+		max := next
+		for i := len(moved) - 1; i >= 0; i-- {
+			max++
+			logs = append(logs, &logRecord{name: newName, updateIndex: max, entry: moved[i].entry})
+		}
+
+		max++
+		refs = append(refs, &refRecord{Reference: Reference{Name: newName, Id: ref.Id}, updateIndex: max})
+		if committer == nil {
+			committer = defaultSignature()
+		}
+		logs = append(logs, &logRecord{name: newName, updateIndex: max, entry: &ReflogEntry{
+			Old:       ref.Id,
+			New:       ref.Id,
+			Committer: committer,
+			Message:   "Branch: renamed " + oldName + " to " + newName,
+		}})
+		return refs, logs, max, nil
+	})
+}
+
+func hasLogRecords(tables []*reftable, name string) bool {
+	for _, t := range tables {
+		if len(t.logsOf(name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addTable locks the stack and adds the table build returns. build gets
+// the current stack and the next free update index and returns the
+// records and the highest update index used.
+func (s *reftableStore) addTable(build func(tables []*reftable, next uint64) ([]*refRecord, []*logRecord, uint64, error)) error {
+	lock, err := newLockFile(s.listPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	names, tables, err := s.stack()
+	if err != nil {
+		return err
+	}
+
+	next := uint64(1)
+	if len(tables) > 0 {
+		next = tables[len(tables)-1].maxUpdate + 1
+	}
+
+	refs, logs, max, err := build(tables, next)
+	if err != nil {
+		return err
+	}
+	sortRefRecords(refs)
+	sortLogRecords(logs)
+
+	name, t, err := s.writeTable(next, max, refs, logs)
+	if err != nil {
+		return err
+	}
+	names = append(names, name)
+	tables = append(tables, t)
+
+	names, obsolete, err := s.compact(names, tables)
+	if err != nil {
+		os.Remove(filepath.Join(s.path, name))
+		return err
+	}
+
+	var list bytes.Buffer
+	for _, name := range names {
+		list.WriteString(name + "\n")
+	}
+	if err := lock.Write(list.Bytes()); err != nil {
+		return err
+	}
+	if err := lock.Commit(); err != nil {
+		return err
+	}
+
+	for _, name := range obsolete {
+		os.Remove(filepath.Join(s.path, name))
+	}
+	return nil
+}
+
+// writeTable writes a new table file and returns its name.
+func (s *reftableStore) writeTable(min, max uint64, refs []*refRecord, logs []*logRecord) (string, *reftable, error) {
+	data, err := encodeReftable(min, max, refs, logs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	f, err := ioutil.TempFile(s.path, "tmp_table_")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+
+	name := fmt.Sprintf("0x%012x-0x%012x-%08x.ref", min, max, rand.Uint32())
+	if err := os.Rename(f.Name(), filepath.Join(s.path, name)); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+
+	t := &reftable{
+		blockSize: reftableBlockSize,
+		minUpdate: min,
+		maxUpdate: max,
+		size:      len(data),
+		refs:      refs,
+		logs:      logs,
+	}
+	s.mu.Lock()
+	s.tables[name] = t
+	s.mu.Unlock()
+	return name, t, nil
+}
+
+// compact merges the newest tables of the stack as long as a table is
+// not at least twice as large as all newer tables together. This keeps
+// the stack at a logarithmic number of tables. It returns the new list of
+// table names and the names of the replaced tables.
+func (s *reftableStore) compact(names []string, tables []*reftable) ([]string, []string, error) {
+	n := len(tables)
+	first, size := n-1, tables[n-1].size
+	for first > 0 && tables[first-1].size < 2*size {
+		first--
+		size += tables[first].size
+	}
+	if first == n-1 {
+		return names, nil, nil
+	}
+
+	// deletions can only be dropped if nothing is below them
+	full := first == 0
+	refs := mergeRefRecords(tables[first:], "", !full)
+	logs := mergeLogRecords(tables[first:], !full)
+
+	name, _, err := s.writeTable(tables[first].minUpdate, tables[n-1].maxUpdate, refs, logs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obsolete := append([]string(nil), names[first:]...)
+	return append(names[:first:first], name), obsolete, nil
+}
+
+func sortRefRecords(refs []*refRecord) {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+}
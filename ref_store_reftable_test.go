@@ -0,0 +1,192 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReftableStore(t *testing.T) {
+	r := copyTestRepo(t)
+	if err := os.Mkdir(filepath.Join(r.Path, "reftable"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "reftable", "tables.list"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenRepository(r.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.refs.(*reftableStore); !ok {
+		t.Fatalf("expected reftable store, got %T", r.refs)
+	}
+
+	master := "c3ca89834257974d7375ac7915ed58d01afe7d4b"
+	if err := r.SetHead("refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	// enough branches to span several blocks
+	for i := 0; i < 500; i++ {
+		if err := r.CreateBranch(fmt.Sprintf("branch-%03d", i), master); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.CreateBranch("master", master); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenameBranch("master", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteBranch("branch-007"); err != nil {
+		t.Fatal(err)
+	}
+
+	// read everything back from disk
+	r, err = OpenRepository(r.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ioutil.ReadFile(filepath.Join(r.Path, "reftable", "tables.list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Fields(string(names))); n > 12 {
+		t.Fatalf("stack was not compacted: %d tables", n)
+	}
+
+	branches, err := r.GetBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 500 || r.IsBranchExist("branch-007") || r.IsBranchExist("master") {
+		t.Fatalf("unexpected branches: %d", len(branches))
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name != "refs/heads/main" || head.Id.String() != master {
+		t.Fatalf("unexpected HEAD %+v", head)
+	}
+
+	entries, err := r.Reflog("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Message != "Branch: renamed refs/heads/master to refs/heads/main" {
+		t.Fatalf("unexpected reflog %+v", entries)
+	}
+}
+
+func TestReftableEncoding(t *testing.T) {
+	var refs []*refRecord
+	var logs []*logRecord
+	id, _ := NewIdFromString("c3ca89834257974d7375ac7915ed58d01afe7d4b")
+	sig, _ := newSignatureFromCommitline([]byte("A U Thor <author@example.com> 1453752573 -0130"))
+	for i := 0; i < 2000; i++ {
+		name := fmt.Sprintf("refs/tags/v%04d", i)
+		refs = append(refs, &refRecord{Reference: Reference{Name: name, Id: id, Peeled: id}, updateIndex: 3})
+		logs = append(logs, &logRecord{name: name, updateIndex: 3, entry: &ReflogEntry{New: id, Committer: sig, Message: "tag"}})
+	}
+	refs = append(refs, &refRecord{Reference: Reference{Name: "refs/x", Target: "refs/tags/v0001"}, updateIndex: 2})
+
+	data, err := encodeReftable(2, 3, refs, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := decodeReftable(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.refs) != len(refs) || len(table.logs) != len(logs) {
+		t.Fatalf("decoded %d refs and %d logs", len(table.refs), len(table.logs))
+	}
+	if rec := table.ref("refs/tags/v1234"); rec == nil || rec.Peeled != id || rec.updateIndex != 3 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if rec := table.ref("refs/x"); rec == nil || rec.Target != "refs/tags/v0001" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if e := table.logs[5].entry; e.Committer.When.Format("-0700") != "-0130" || e.Message != "tag" {
+		t.Fatalf("unexpected log entry %+v", e)
+	}
+}
+
+func TestReftableMergeRefs(t *testing.T) {
+	rec := func(name string, update uint64, deleted bool) *refRecord {
+		return &refRecord{Reference: Reference{Name: name}, updateIndex: update, deleted: deleted}
+	}
+	tables := []*reftable{
+		{refs: []*refRecord{rec("refs/heads/a", 1, false), rec("refs/heads/b", 1, false), rec("refs/tags/v1", 1, false)}},
+		{refs: []*refRecord{rec("refs/heads/b", 2, true), rec("refs/heads/c", 2, false), rec("refs/heads/d", 2, false)}},
+		{refs: []*refRecord{rec("refs/heads/a", 3, false), rec("refs/heads/b", 3, false), rec("refs/heads/d", 3, true)}},
+	}
+
+	var got []string
+	for _, rec := range mergeRefRecords(tables, "refs/heads/", false) {
+		got = append(got, fmt.Sprintf("%s@%d", rec.Name, rec.updateIndex))
+	}
+	if s := strings.Join(got, " "); s != "refs/heads/a@3 refs/heads/b@3 refs/heads/c@2" {
+		t.Fatalf("unexpected merge %s", s)
+	}
+
+	if recs := mergeRefRecords(tables, "refs/heads/d", true); len(recs) != 1 || !recs[0].deleted {
+		t.Fatalf("unexpected deletion records %+v", recs)
+	}
+	if recs := mergeRefRecords(tables, "refs/tags/", false); len(recs) != 1 || recs[0].Name != "refs/tags/v1" {
+		t.Fatalf("unexpected tags %+v", recs)
+	}
+}
+
+func TestReftableConcurrentLookups(t *testing.T) {
+	r := copyTestRepo(t)
+	if err := os.Mkdir(filepath.Join(r.Path, "reftable"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "reftable", "tables.list"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenRepository(r.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := "c3ca89834257974d7375ac7915ed58d01afe7d4b"
+	if err := r.CreateBranch("master", master); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := r.CreateBranch(fmt.Sprintf("branch-%d", i), master); err != nil {
+				t.Error(err)
+			}
+			if _, err := r.Ref("refs/heads/master"); err != nil {
+				t.Error(err)
+			}
+			if _, err := r.Reflog("refs/heads/master"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	branches, err := r.GetBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 9 {
+		t.Fatalf("unexpected branches: %d", len(branches))
+	}
+}
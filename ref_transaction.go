@@ -0,0 +1,239 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrRefChanged = errors.New("reference does not have the expected value")
+)
+
+// RefUpdate is the change of a single reference. Updates do not follow
+// symbolic references, they replace the named reference itself.
+type RefUpdate struct {
+	Name string
+
+	// New is the id the reference will point to. A zero id deletes the
+	// reference.
+	New sha1
+
+	// Target makes the reference a symbolic reference to another one
+	// instead, New is ignored then.
+	Target string
+
+	// If CheckOld is set, the reference must point to Old before the
+	// update. A zero Old requires that the reference does not exist.
+	Old      sha1
+	CheckOld bool
+
+	// Committer and Message are recorded in the reflog. Committer
+	// defaults to the identity from the environment.
+	Committer *Signature
+	Message   string
+}
+
+// deletes reports whether the update removes the reference.
+func (u *RefUpdate) deletes() bool {
+	return u.New.IsZero() && len(u.Target) == 0
+}
+
+// committer returns the identity the update is logged as.
+func (u *RefUpdate) committer() *Signature {
+	if u.Committer == nil {
+		return defaultSignature()
+	}
+	return u.Committer
+}
+
+// loggedIds returns the ids a log entry of the update records: the old
+// and the new value of the reference, with symbolic references resolved.
+// Missing references are recorded as zero ids.
+func (u *RefUpdate) loggedIds(store RefStore) (old, new sha1) {
+	if ref, err := resolveRef(store, u.Name); err == nil {
+		old = ref.Id
+	}
+	new = u.New
+	if len(u.Target) > 0 {
+		new = sha1{}
+		if ref, err := resolveRef(store, u.Target); err == nil {
+			new = ref.Id
+		}
+	}
+	return old, new
+}
+
+// RefTransaction collects reference updates which are applied all at once
+// or not at all.
+type RefTransaction struct {
+	// Committer and Message are logged for all updates which do not
+	// set their own. Committer defaults to the identity of the repository.
+	Committer *Signature
+	Message   string
+
+	store   RefStore
+	updates []*RefUpdate
+}
+
+// NewRefTransaction starts a new reference transaction.
+func (repo *Repository) NewRefTransaction() *RefTransaction {
+	return &RefTransaction{store: repo.refs, Committer: repo.signature()}
+}
+
+// Create creates the reference name pointing to id. The reference must not
+// exist yet.
+func (tx *RefTransaction) Create(name string, id sha1) *RefTransaction {
+	return tx.add(&RefUpdate{Name: name, New: id, CheckOld: true})
+}
+
+// Update points name to newId, if it still points to oldId.
+func (tx *RefTransaction) Update(name string, newId, oldId sha1) *RefTransaction {
+	return tx.add(&RefUpdate{Name: name, New: newId, Old: oldId, CheckOld: true})
+}
+
+// Set points name to id, regardless of its previous value.
+func (tx *RefTransaction) Set(name string, id sha1) *RefTransaction {
+	return tx.add(&RefUpdate{Name: name, New: id})
+}
+
+// Delete removes name, regardless of its previous value.
+func (tx *RefTransaction) Delete(name string) *RefTransaction {
+	return tx.add(&RefUpdate{Name: name})
+}
+
+// DeleteIf removes name, if it still points to oldId.
+func (tx *RefTransaction) DeleteIf(name string, oldId sha1) *RefTransaction {
+	return tx.add(&RefUpdate{Name: name, Old: oldId, CheckOld: true})
+}
+
+func (tx *RefTransaction) add(u *RefUpdate) *RefTransaction {
+	tx.updates = append(tx.updates, u)
+	return tx
+}
+
+// Commit applies all updates of the transaction. If any reference is locked
+// or does not have the expected old value, no reference is changed.
+func (tx *RefTransaction) Commit() error {
+	for _, u := range tx.updates {
+		if u.Committer == nil {
+			u.Committer = tx.Committer
+		}
+		if len(u.Message) == 0 {
+			u.Message = tx.Message
+		}
+	}
+	return tx.store.Update(tx.updates...)
+}
+
+// headsOf returns the HEADs an update of name is mirrored to when they
+// point to it: the repository's HEAD and the HEAD of every namespace the
+// reference is in.
+func headsOf(name string) []string {
+	heads := []string{"HEAD"}
+	prefix := ""
+	for rest := name; strings.HasPrefix(rest, "refs/namespaces/"); {
+		i := strings.IndexByte(rest[len("refs/namespaces/"):], '/')
+		if i < 0 {
+			break
+		}
+		n := len("refs/namespaces/") + i + 1
+		prefix += rest[:n]
+		rest = rest[n:]
+		heads = append(heads, prefix+"HEAD")
+	}
+	return heads
+}
+
+// checkRefUpdates verifies the names of the updates before any reference
+// is locked.
+func checkRefUpdates(updates []*RefUpdate) error {
+	names := make(map[string]bool, len(updates))
+	for _, u := range updates {
+		if !IsValidRefName(u.Name) {
+			return fmt.Errorf("invalid reference name %q", u.Name)
+		}
+		if names[u.Name] {
+			return fmt.Errorf("multiple updates for reference %q", u.Name)
+		}
+		names[u.Name] = true
+	}
+	return nil
+}
+
+// checkRefConflicts makes sure that the updated references can coexist
+// with the existing ones. The references have to be locked, otherwise a
+// conflicting reference may be created in the meantime.
+func checkRefConflicts(store RefStore, updates []*RefUpdate) error {
+	names := make(map[string]bool, len(updates))
+	for _, u := range updates {
+		names[u.Name] = true
+	}
+
+	for _, u := range updates {
+		if u.deletes() {
+			continue
+		}
+		if err := checkRefNameConflict(store, u.Name, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRefNameConflict makes sure that name can coexist with the other
+// references: "refs/heads/a" and "refs/heads/a/b" cannot both exist.
+// References in ignore are about to be deleted or replaced.
+func checkRefNameConflict(store RefStore, name string, ignore map[string]bool) error {
+	for i := strings.IndexByte(name, '/'); i >= 0; i = nextSlash(name, i) {
+		parent := name[:i]
+		if ignore[parent] {
+			continue
+		}
+		if _, err := store.Ref(parent); err == nil {
+			return fmt.Errorf("reference %q conflicts with existing %q", name, parent)
+		} else if err != ErrRefNotExist {
+			return err
+		}
+	}
+
+	children, err := store.Refs(name + "/")
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if !ignore[child.Name] {
+			return fmt.Errorf("reference %q conflicts with existing %q", name, child.Name)
+		}
+	}
+	return nil
+}
+
+func nextSlash(s string, i int) int {
+	next := strings.IndexByte(s[i+1:], '/')
+	if next < 0 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+// checkRefOld verifies the expected old value of an update against the
+// current value of the reference as returned by lookup.
+func checkRefOld(lookup func(name string) (*Reference, error), u *RefUpdate) error {
+	if !u.CheckOld {
+		return nil
+	}
+
+	cur, err := lookup(u.Name)
+	switch {
+	case err == ErrRefNotExist:
+		if !u.Old.IsZero() {
+			return ErrRefChanged
+		}
+	case err != nil:
+		return err
+	case u.Old.IsZero() || cur.IsSymbolic() || cur.Id != u.Old:
+		return ErrRefChanged
+	}
+	return nil
+}
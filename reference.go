@@ -3,32 +3,79 @@ package git
 import (
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 )
 
 // UnpackRefs unpacks 'packed-refs' to git repository.
 func UnpackRefs(repoPath string) error {
-	refs, err := ioutil.ReadFile(filepath.Join(repoPath, "packed-refs"))
+	data, err := ioutil.ReadFile(filepath.Join(repoPath, "packed-refs"))
 	if err != nil {
 		return err
 	}
 
-	for _, ref := range strings.Split(string(refs), "\n") {
-		if len(ref) == 0 || ref[0] == '#' {
-			continue
-		} else if !strings.Contains(ref, "heads") && !strings.Contains(ref, "tags") {
+	packed, err := parsePackedRefs(data)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range packed.refs {
+		if !strings.HasPrefix(ref.Name, "refs/heads/") && !strings.HasPrefix(ref.Name, "refs/tags/") {
 			continue
 		}
 
-		infos := strings.Split(ref, " ")
-		refPath := filepath.Join(repoPath, infos[1])
+		refPath := filepath.Join(repoPath, filepath.FromSlash(ref.Name))
 		os.RemoveAll(refPath)
-		os.MkdirAll(path.Dir(refPath), os.ModePerm)
-		if err = ioutil.WriteFile(refPath, []byte(infos[0]), os.ModePerm); err != nil {
+		os.MkdirAll(filepath.Dir(refPath), os.ModePerm)
+		if err = ioutil.WriteFile(refPath, []byte(ref.Id.String()), os.ModePerm); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// Reference is a named pointer to an object. A symbolic reference points
+// to another reference instead, like HEAD usually does.
+type Reference struct {
+	Name   string
+	Id     sha1   // The id the reference points to, zero for symbolic references
+	Target string // The name of the referenced ref of a symbolic reference
+	Peeled sha1   // The id an annotated tag peels to, zero if not known
+}
+
+// IsSymbolic returns true if the reference points to another reference.
+func (ref *Reference) IsSymbolic() bool {
+	return len(ref.Target) > 0
+}
+
+// IsPeeled returns true if the peeled id of the reference is known.
+func (ref *Reference) IsPeeled() bool {
+	return !ref.Peeled.IsZero()
+}
+
+// IsValidRefName checks name against the rules of git check-ref-format.
+// HEAD and other all-caps names outside of refs/ are accepted as well.
+func IsValidRefName(name string) bool {
+	if len(name) == 0 || name == "@" || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") ||
+		strings.Contains(name, "@{") {
+		return false
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if len(component) == 0 || component[0] == '.' || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+
+	if !strings.HasPrefix(name, "refs/") {
+		return strings.ToUpper(name) == name && !strings.Contains(name, "/")
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrReflogNotExist = errors.New("reflog entry does not exist")
+)
+
+// ReflogEntry is a single change of a reference recorded in its log.
+type ReflogEntry struct {
+	Old       sha1 // Zero if the reference was created
+	New       sha1 // Zero if the reference was deleted
+	Committer *Signature
+	Message   string
+}
+
+// Parse a single reflog line:
+//
+//	<old> <new> Name <email> 1378823654 +0200\t<message>
+func parseReflogEntry(line []byte) (*ReflogEntry, error) {
+	if len(line) < 83 || line[40] != ' ' || line[81] != ' ' {
+		return nil, fmt.Errorf("malformed reflog entry %q", line)
+	}
+
+	entry := new(ReflogEntry)
+	var err error
+	if entry.Old, err = NewIdFromString(string(line[:40])); err != nil {
+		return nil, err
+	}
+	if entry.New, err = NewIdFromString(string(line[41:81])); err != nil {
+		return nil, err
+	}
+
+	ident := line[82:]
+	if tab := bytes.IndexByte(ident, '\t'); tab >= 0 {
+		entry.Message = string(ident[tab+1:])
+		ident = ident[:tab]
+	}
+	if entry.Committer, err = newSignatureFromCommitline(ident); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// parseReflog parses the content of a reflog, oldest entry first.
+func parseReflog(data []byte) ([]*ReflogEntry, error) {
+	var entries []*ReflogEntry
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := parseReflogEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (entry *ReflogEntry) encode() []byte {
+	return []byte(entry.Old.String() + " " + entry.New.String() + " " +
+		entry.Committer.encode() + "\t" + trimReflogMessage(entry.Message) + "\n")
+}
+
+// trimReflogMessage limits a reflog message to a single line.
+func trimReflogMessage(msg string) string {
+	return strings.Replace(strings.TrimSpace(msg), "\n", " ", -1)
+}
+
+// shouldLogRef reports whether updates of the reference are logged even if
+// it has no log yet.
+func shouldLogRef(name string) bool {
+	// references of a namespace are logged like those of a repository
+	for strings.HasPrefix(name, "refs/namespaces/") {
+		i := strings.IndexByte(name[len("refs/namespaces/"):], '/')
+		if i < 0 {
+			break
+		}
+		name = name[len("refs/namespaces/")+i+1:]
+	}
+	return name == "HEAD" || strings.HasPrefix(name, "refs/heads/") ||
+		strings.HasPrefix(name, "refs/remotes/") || strings.HasPrefix(name, "refs/notes/")
+}
+
+// appendReflog appends entry to the log file at logPath.
+func appendReflog(logPath string, entry *ReflogEntry) error {
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(entry.encode()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Reflog returns the log of the reference refName, newest entry first, so
+// entry n is what "refName@{n}" refers to.
+func (repo *Repository) Reflog(refName string) ([]*ReflogEntry, error) {
+	entries, err := repo.refs.Reflog(refName)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// ReflogAt returns the n-th prior value of refName, like "refName@{n}".
+// Zero is the current value.
+func (repo *Repository) ReflogAt(refName string, n int) (*ReflogEntry, error) {
+	entries, err := repo.Reflog(refName)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= len(entries) {
+		return nil, ErrReflogNotExist
+	}
+	return entries[n], nil
+}
+
+// ReflogAtTime returns the id refName pointed to at the given time, like
+// "refName@{date}". If the log does not reach back that far, the oldest
+// known value is returned.
+func (repo *Repository) ReflogAtTime(refName string, when time.Time) (sha1, error) {
+	entries, err := repo.Reflog(refName)
+	if err != nil {
+		return sha1{}, err
+	}
+	if len(entries) == 0 {
+		return sha1{}, ErrReflogNotExist
+	}
+
+	for _, entry := range entries {
+		if !entry.Committer.When.After(when) {
+			return entry.New, nil
+		}
+	}
+
+	oldest := entries[len(entries)-1]
+	if oldest.Old.IsZero() {
+		return oldest.New, nil
+	}
+	return oldest.Old, nil
+}
+
+// ResolveReflog returns the id of a reflog expression like "master@{2}",
+// "HEAD@{yesterday}" or "@{1}", which refers to the current branch.
+func (repo *Repository) ResolveReflog(spec string) (string, error) {
+	at := strings.Index(spec, "@{")
+	if at < 0 || !strings.HasSuffix(spec, "}") {
+		return "", fmt.Errorf("invalid reflog expression %q", spec)
+	}
+	refName, selector := spec[:at], spec[at+2:len(spec)-1]
+
+	var err error
+	if len(refName) == 0 {
+		refName = "HEAD"
+		if head, err := repo.refs.Ref("HEAD"); err == nil && head.IsSymbolic() {
+			refName = head.Target
+		}
+	} else if refName, err = repo.expandRefName(refName); err != nil {
+		return "", err
+	}
+
+	if n, err := strconv.Atoi(selector); err == nil {
+		entry, err := repo.ReflogAt(refName, n)
+		if err != nil {
+			return "", err
+		}
+		return entry.New.String(), nil
+	}
+
+	when, err := parseReflogDate(selector, time.Now())
+	if err != nil {
+		return "", err
+	}
+	id, err := repo.ReflogAtTime(refName, when)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+var reflogDateUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// parseReflogDate understands absolute dates like "2016-01-25 23:09:11" or
+// RFC 3339 and relative ones like "yesterday" or "3 days ago".
+func parseReflogDate(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "ago") {
+		// git also accepts "3.days.ago"
+		s = strings.Replace(s, ".", " ", -1)
+	}
+
+	switch s {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 3 && fields[2] == "ago" {
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", s)
+		}
+		unit := strings.TrimSuffix(fields[1], "s")
+		switch unit {
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+		if d, ok := reflogDateUnits[unit]; ok {
+			return now.Add(-time.Duration(n) * d), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
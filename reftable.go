@@ -0,0 +1,663 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The reftable file format, see Documentation/technical/reftable.txt in
+// git. A table is laid out as
+//
+//	header, ref blocks, ref index, obj blocks, obj index, log blocks,
+//	log index, footer
+//
+// where the header is part of the first block. Only version 1 (SHA-1)
+// tables are supported. Obj blocks are optional and neither read nor
+// written.
+const (
+	reftableMagic           = "REFT"
+	reftableVersion         = 1
+	reftableHeaderSize      = 24
+	reftableFooterSize      = 68
+	reftableBlockSize       = 4096
+	reftableRestartInterval = 16
+	reftableMinIndexBlocks  = 4
+
+	reftableBlockRef   = 'r'
+	reftableBlockLog   = 'g'
+	reftableBlockIndex = 'i'
+
+	reftableRefDeletion = 0
+	reftableRefId       = 1
+	reftableRefPeeled   = 2
+	reftableRefSymbolic = 3
+
+	reftableLogDeletion = 0
+	reftableLogUpdate   = 1
+)
+
+var (
+	errReftableCorrupt = errors.New("corrupt reftable")
+)
+
+// refRecord is a reference stored in a reftable. Deletion records hide
+// the reference in older tables.
+type refRecord struct {
+	Reference
+	updateIndex uint64
+	deleted     bool
+}
+
+// logRecord is a reflog entry stored in a reftable, identified by the
+// reference name and update index. Deletion records hide the entry in
+// older tables.
+type logRecord struct {
+	name        string
+	updateIndex uint64
+	deleted     bool
+	entry       *ReflogEntry
+}
+
+func (rec *logRecord) key() []byte {
+	key := make([]byte, len(rec.name)+9)
+	copy(key, rec.name)
+	binary.BigEndian.PutUint64(key[len(rec.name)+1:], ^rec.updateIndex)
+	return key
+}
+
+// reftable is a single parsed table of a reftable stack.
+type reftable struct {
+	blockSize int
+	minUpdate uint64
+	maxUpdate uint64
+	size      int
+
+	refs []*refRecord // sorted by name
+	logs []*logRecord // sorted by name, newest first
+}
+
+func putReftableVarint(buf []byte, v uint64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v != 0; v >>= 7 {
+		v--
+		i--
+		tmp[i] = 0x80 | byte(v&0x7f)
+	}
+	return append(buf, tmp[i:]...)
+}
+
+func readReftableVarint(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errReftableCorrupt
+	}
+	v := uint64(data[0] & 0x7f)
+	n := 1
+	for data[n-1]&0x80 != 0 {
+		if n >= len(data) || n > 9 {
+			return 0, 0, errReftableCorrupt
+		}
+		v = ((v + 1) << 7) | uint64(data[n]&0x7f)
+		n++
+	}
+	return v, n, nil
+}
+
+func putUint24(buf []byte, v int) {
+	buf[0], buf[1], buf[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func getUint24(buf []byte) int {
+	return int(buf[0])<<16 | int(buf[1])<<8 | int(buf[2])
+}
+
+// reftableBlockWriter collects prefix compressed records of a single block
+// and adds restart points every reftableRestartInterval records.
+type reftableBlockWriter struct {
+	typ       byte
+	headerOff int
+	blockSize int
+	buf       []byte
+	restarts  []int
+	entries   int
+	lastKey   []byte
+}
+
+func newReftableBlockWriter(typ byte, headerOff, blockSize int) *reftableBlockWriter {
+	return &reftableBlockWriter{
+		typ:       typ,
+		headerOff: headerOff,
+		blockSize: blockSize,
+		buf:       make([]byte, headerOff+4, blockSize),
+	}
+}
+
+// add appends a record. It returns false if the block is full, but always
+// takes the first record of a block.
+func (w *reftableBlockWriter) add(key []byte, valueType byte, value []byte) bool {
+	restart := w.entries%reftableRestartInterval == 0
+
+	prefix := 0
+	if !restart {
+		for prefix < len(key) && prefix < len(w.lastKey) && key[prefix] == w.lastKey[prefix] {
+			prefix++
+		}
+	}
+
+	rec := putReftableVarint(nil, uint64(prefix))
+	rec = putReftableVarint(rec, uint64(len(key)-prefix)<<3|uint64(valueType))
+	rec = append(rec, key[prefix:]...)
+	rec = append(rec, value...)
+
+	restarts := len(w.restarts)
+	if restart {
+		restarts++
+	}
+	if w.entries > 0 && len(w.buf)+len(rec)+3*restarts+2 > w.blockSize {
+		return false
+	}
+
+	if restart {
+		w.restarts = append(w.restarts, len(w.buf))
+	}
+	w.buf = append(w.buf, rec...)
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.entries++
+	return true
+}
+
+// finish returns the encoded block. The first headerOff bytes are left
+// for the file header.
+func (w *reftableBlockWriter) finish() ([]byte, error) {
+	var tmp [3]byte
+	for _, off := range w.restarts {
+		putUint24(tmp[:], off)
+		w.buf = append(w.buf, tmp[:]...)
+	}
+	w.buf = append(w.buf, byte(len(w.restarts)>>8), byte(len(w.restarts)))
+
+	w.buf[w.headerOff] = w.typ
+	putUint24(w.buf[w.headerOff+1:], len(w.buf))
+
+	if w.typ != reftableBlockLog {
+		return w.buf, nil
+	}
+
+	// log blocks are compressed after the block header
+	var compressed bytes.Buffer
+	compressed.Write(w.buf[:w.headerOff+4])
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(w.buf[w.headerOff+4:]); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+func encodeRefRecord(rec *refRecord, minUpdate uint64) (byte, []byte) {
+	value := putReftableVarint(nil, rec.updateIndex-minUpdate)
+	switch {
+	case rec.deleted:
+		return reftableRefDeletion, value
+	case rec.IsSymbolic():
+		value = putReftableVarint(value, uint64(len(rec.Target)))
+		return reftableRefSymbolic, append(value, rec.Target...)
+	case rec.IsPeeled():
+		value = append(value, rec.Id[:]...)
+		return reftableRefPeeled, append(value, rec.Peeled[:]...)
+	}
+	return reftableRefId, append(value, rec.Id[:]...)
+}
+
+func encodeLogRecord(rec *logRecord) (byte, []byte) {
+	if rec.deleted {
+		return reftableLogDeletion, nil
+	}
+
+	e := rec.entry
+	// messages end with exactly one line feed
+	msg := trimReflogMessage(e.Message) + "\n"
+
+	// like git, store the offset as the decimal number of its "+hhmm"
+	// notation, not in minutes as the format description suggests
+	tz, _ := strconv.Atoi(e.Committer.When.Format("-0700"))
+
+	value := append([]byte(nil), e.Old[:]...)
+	value = append(value, e.New[:]...)
+	value = putReftableVarint(value, uint64(len(e.Committer.Name)))
+	value = append(value, e.Committer.Name...)
+	value = putReftableVarint(value, uint64(len(e.Committer.Email)))
+	value = append(value, e.Committer.Email...)
+	value = putReftableVarint(value, uint64(e.Committer.When.Unix()))
+	value = append(value, byte(uint16(int16(tz))>>8), byte(uint16(int16(tz))))
+	value = putReftableVarint(value, uint64(len(msg)))
+	value = append(value, msg...)
+	return reftableLogUpdate, value
+}
+
+// encodeReftable writes a complete table. refs must be sorted by name,
+// logs by key.
+func encodeReftable(minUpdate, maxUpdate uint64, refs []*refRecord, logs []*logRecord) ([]byte, error) {
+	var out []byte
+	var refIndexPos, logPos, logIndexPos int
+
+	type blockRef struct {
+		lastKey []byte
+		pos     int
+	}
+
+	// writeSection writes the records of one section and its index, if
+	// the section is large enough. It returns the position of the
+	// section and of its index.
+	writeSection := func(typ byte, n int, record func(i int) ([]byte, byte, []byte)) (int, int, error) {
+		start := len(out)
+		var blocks []blockRef
+		var w *reftableBlockWriter
+
+		flush := func() error {
+			data, err := w.finish()
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, blockRef{append([]byte(nil), w.lastKey...), len(out)})
+			out = append(out, data...)
+			if typ != reftableBlockLog {
+				// pad to the block size
+				for len(out)%reftableBlockSize != 0 {
+					out = append(out, 0)
+				}
+			}
+			w = nil
+			return nil
+		}
+
+		for i := 0; i < n; i++ {
+			key, valueType, value := record(i)
+			if w == nil {
+				headerOff := 0
+				if len(out) == 0 {
+					headerOff = reftableHeaderSize
+				}
+				w = newReftableBlockWriter(typ, headerOff, reftableBlockSize)
+			}
+			if !w.add(key, valueType, value) {
+				if err := flush(); err != nil {
+					return 0, 0, err
+				}
+				i--
+			}
+		}
+		if w != nil {
+			if err := flush(); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		// index blocks point to the last key of every block. Indexes
+		// spanning several blocks are indexed again.
+		indexPos := 0
+		for len(blocks) >= reftableMinIndexBlocks || (indexPos > 0 && len(blocks) > 1) {
+			level := blocks
+			blocks = nil
+			for i := 0; i < len(level); i++ {
+				if w == nil {
+					w = newReftableBlockWriter(reftableBlockIndex, 0, reftableBlockSize)
+				}
+				if !w.add(level[i].lastKey, 0, putReftableVarint(nil, uint64(level[i].pos))) {
+					if err := flush(); err != nil {
+						return 0, 0, err
+					}
+					i--
+				}
+			}
+			if err := flush(); err != nil {
+				return 0, 0, err
+			}
+			indexPos = blocks[len(blocks)-1].pos
+		}
+		return start, indexPos, nil
+	}
+
+	var err error
+	_, refIndexPos, err = writeSection(reftableBlockRef, len(refs), func(i int) ([]byte, byte, []byte) {
+		valueType, value := encodeRefRecord(refs[i], minUpdate)
+		return []byte(refs[i].Name), valueType, value
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(logs) > 0 {
+		logPos, logIndexPos, err = writeSection(reftableBlockLog, len(logs), func(i int) ([]byte, byte, []byte) {
+			valueType, value := encodeLogRecord(logs[i])
+			return logs[i].key(), valueType, value
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	header := encodeReftableHeader(minUpdate, maxUpdate)
+	if len(out) == 0 {
+		// an empty table still has a header
+		out = append(out, header...)
+	} else {
+		copy(out, header)
+	}
+
+	footer := append([]byte(nil), header...)
+	for _, pos := range []int{refIndexPos, 0, 0, logPos, logIndexPos} {
+		footer = append(footer, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(footer[len(footer)-8:], uint64(pos))
+	}
+	footer = append(footer, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(footer[len(footer)-4:], crc32.ChecksumIEEE(footer[:len(footer)-4]))
+
+	return append(out, footer...), nil
+}
+
+func encodeReftableHeader(minUpdate, maxUpdate uint64) []byte {
+	header := make([]byte, reftableHeaderSize)
+	copy(header, reftableMagic)
+	header[4] = reftableVersion
+	putUint24(header[5:], reftableBlockSize)
+	binary.BigEndian.PutUint64(header[8:], minUpdate)
+	binary.BigEndian.PutUint64(header[16:], maxUpdate)
+	return header
+}
+
+// decodeReftable parses a complete table.
+func decodeReftable(data []byte) (*reftable, error) {
+	if len(data) < reftableHeaderSize+reftableFooterSize ||
+		string(data[:4]) != reftableMagic {
+		return nil, errReftableCorrupt
+	}
+	if data[4] != reftableVersion {
+		return nil, fmt.Errorf("unsupported reftable version %d", data[4])
+	}
+
+	footer := data[len(data)-reftableFooterSize:]
+	if !bytes.Equal(footer[:reftableHeaderSize], data[:reftableHeaderSize]) ||
+		crc32.ChecksumIEEE(footer[:64]) != binary.BigEndian.Uint32(footer[64:]) {
+		return nil, errReftableCorrupt
+	}
+
+	t := &reftable{
+		blockSize: getUint24(data[5:]),
+		minUpdate: binary.BigEndian.Uint64(data[8:]),
+		maxUpdate: binary.BigEndian.Uint64(data[16:]),
+		size:      len(data),
+	}
+	logPos := int(binary.BigEndian.Uint64(footer[48:]))
+	body := data[:len(data)-reftableFooterSize]
+
+	// the first block tells which sections are present
+	if len(body) > reftableHeaderSize {
+		switch body[reftableHeaderSize] {
+		case reftableBlockRef:
+			if err := t.decodeSection(body, 0, reftableBlockRef); err != nil {
+				return nil, err
+			}
+		case reftableBlockLog:
+			logPos = 0
+		}
+	}
+	if logPos > 0 || (len(body) > reftableHeaderSize && body[reftableHeaderSize] == reftableBlockLog) {
+		if err := t.decodeSection(body, logPos, reftableBlockLog); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// decodeSection reads the blocks of type typ starting at pos.
+func (t *reftable) decodeSection(body []byte, pos int, typ byte) error {
+	for pos < len(body) {
+		headerOff := 0
+		if pos == 0 {
+			headerOff = reftableHeaderSize
+		}
+		if pos+headerOff+4 > len(body) || body[pos+headerOff] != typ {
+			return nil
+		}
+
+		block, next, err := t.readBlock(body, pos, headerOff)
+		if err != nil {
+			return err
+		}
+		if err := t.decodeBlock(block, headerOff, typ); err != nil {
+			return err
+		}
+		pos = next
+	}
+	return nil
+}
+
+// readBlock returns the (inflated) block at pos and the position of the
+// following block.
+func (t *reftable) readBlock(body []byte, pos, headerOff int) ([]byte, int, error) {
+	blockLen := getUint24(body[pos+headerOff+1:])
+	if blockLen < headerOff+4+2 {
+		return nil, 0, errReftableCorrupt
+	}
+
+	if body[pos+headerOff] == reftableBlockLog {
+		r := bytes.NewReader(body[pos+headerOff+4:])
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		inflated, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(inflated) != blockLen-headerOff-4 {
+			return nil, 0, errReftableCorrupt
+		}
+		block := append(append([]byte(nil), body[pos:pos+headerOff+4]...), inflated...)
+		return block, len(body) - r.Len(), nil
+	}
+
+	if pos+blockLen > len(body) {
+		return nil, 0, errReftableCorrupt
+	}
+	next := pos + blockLen
+	// blocks are padded to the block size, unless the next block
+	// follows immediately
+	if t.blockSize > 0 && blockLen < t.blockSize && next < len(body) && body[next] == 0 {
+		next = pos + t.blockSize
+	}
+	return body[pos : pos+blockLen], next, nil
+}
+
+func (t *reftable) decodeBlock(block []byte, headerOff int, typ byte) error {
+	restartCount := int(binary.BigEndian.Uint16(block[len(block)-2:]))
+	end := len(block) - 2 - 3*restartCount
+	if end < headerOff+4 {
+		return errReftableCorrupt
+	}
+
+	var key []byte
+	for pos := headerOff + 4; pos < end; {
+		prefix, n, err := readReftableVarint(block[pos:end])
+		if err != nil {
+			return err
+		}
+		pos += n
+		suffix, n, err := readReftableVarint(block[pos:end])
+		if err != nil {
+			return err
+		}
+		pos += n
+		valueType := byte(suffix & 7)
+		suffixLen := int(suffix >> 3)
+		if int(prefix) > len(key) || pos+suffixLen > end {
+			return errReftableCorrupt
+		}
+		key = append(key[:prefix], block[pos:pos+suffixLen]...)
+		pos += suffixLen
+
+		if typ == reftableBlockRef {
+			n, err = t.decodeRefValue(string(key), valueType, block[pos:end])
+		} else {
+			n, err = t.decodeLogValue(key, valueType, block[pos:end])
+		}
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+	return nil
+}
+
+func (t *reftable) decodeRefValue(name string, valueType byte, data []byte) (int, error) {
+	delta, pos, err := readReftableVarint(data)
+	if err != nil {
+		return 0, err
+	}
+	rec := &refRecord{Reference: Reference{Name: name}, updateIndex: t.minUpdate + delta}
+
+	switch valueType {
+	case reftableRefDeletion:
+		rec.deleted = true
+	case reftableRefId, reftableRefPeeled:
+		if pos+20 > len(data) {
+			return 0, errReftableCorrupt
+		}
+		copy(rec.Id[:], data[pos:])
+		pos += 20
+		if valueType == reftableRefPeeled {
+			if pos+20 > len(data) {
+				return 0, errReftableCorrupt
+			}
+			copy(rec.Peeled[:], data[pos:])
+			pos += 20
+		}
+	case reftableRefSymbolic:
+		target, n, err := readReftableString(data[pos:])
+		if err != nil {
+			return 0, err
+		}
+		rec.Target = target
+		pos += n
+	default:
+		return 0, errReftableCorrupt
+	}
+
+	t.refs = append(t.refs, rec)
+	return pos, nil
+}
+
+func (t *reftable) decodeLogValue(key []byte, valueType byte, data []byte) (int, error) {
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return 0, errReftableCorrupt
+	}
+	rec := &logRecord{
+		name:        string(key[:len(key)-9]),
+		updateIndex: ^binary.BigEndian.Uint64(key[len(key)-8:]),
+	}
+
+	if valueType == reftableLogDeletion {
+		rec.deleted = true
+		t.logs = append(t.logs, rec)
+		return 0, nil
+	}
+	if valueType != reftableLogUpdate || len(data) < 40 {
+		return 0, errReftableCorrupt
+	}
+
+	e := &ReflogEntry{Committer: new(Signature)}
+	copy(e.Old[:], data)
+	copy(e.New[:], data[20:])
+	pos := 40
+
+	name, n, err := readReftableString(data[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+	email, n, err := readReftableString(data[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+	seconds, n, err := readReftableVarint(data[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+	if pos+2 > len(data) {
+		return 0, errReftableCorrupt
+	}
+	tz := int(int16(binary.BigEndian.Uint16(data[pos:])))
+	pos += 2
+	msg, n, err := readReftableString(data[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+
+	e.Committer.Name = name
+	e.Committer.Email = email
+	offset := (tz/100*60 + tz%100) * 60
+	e.Committer.When = time.Unix(int64(seconds), 0).In(time.FixedZone("", offset))
+	e.Message = trimReflogMessage(msg)
+	rec.entry = e
+
+	t.logs = append(t.logs, rec)
+	return pos, nil
+}
+
+func readReftableString(data []byte) (string, int, error) {
+	l, n, err := readReftableVarint(data)
+	if err != nil {
+		return "", 0, err
+	}
+	if n+int(l) > len(data) {
+		return "", 0, errReftableCorrupt
+	}
+	return string(data[n : n+int(l)]), n + int(l), nil
+}
+
+// ref returns the record for name or nil if the table has none.
+func (t *reftable) ref(name string) *refRecord {
+	i := sort.Search(len(t.refs), func(i int) bool {
+		return t.refs[i].Name >= name
+	})
+	if i < len(t.refs) && t.refs[i].Name == name {
+		return t.refs[i]
+	}
+	return nil
+}
+
+// logsOf returns the log records of name in the table, newest first.
+func (t *reftable) logsOf(name string) []*logRecord {
+	i := sort.Search(len(t.logs), func(i int) bool {
+		return t.logs[i].name >= name
+	})
+	j := i
+	for j < len(t.logs) && t.logs[j].name == name {
+		j++
+	}
+	return t.logs[i:j]
+}
+
+func sortLogRecords(logs []*logRecord) {
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].name != logs[j].name {
+			return logs[i].name < logs[j].name
+		}
+		return logs[i].updateIndex > logs[j].updateIndex
+	})
+}
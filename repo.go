@@ -32,22 +32,44 @@ type idxFile struct {
 // A Repository is the base of all other actions. If you need to lookup a
 // commit, tree or blob, you do it from here.
 type Repository struct {
-	Path       string
+	// Path is the git directory, e.g. "/src/project/.git".
+	Path string
+	// CommonDir holds objects, references and config shared by all work
+	// trees. It is Path except for linked work trees.
+	CommonDir string
+	// WorkTree is the root of the working tree, empty for bare
+	// repositories.
+	WorkTree string
+
 	indexfiles map[string]*idxFile
+	refs       RefStore
 
 	commitCache map[sha1]*Commit
 	tagCache    map[sha1]*Tag
+
+	commitGraph       []*commitGraphLayer
+	commitGraphLoaded bool
 }
 
-// Open the repository at the given path.
+// Open the repository at the given path, which is either a git directory
+// or the root of a working tree containing ".git".
 func OpenRepository(path string) (*Repository, error) {
-	repo := new(Repository)
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
 	}
-	repo.Path = path
-	fm, err := os.Stat(path)
+
+	if gitDir, err := readGitDirLink(filepath.Join(path, ".git")); err == nil {
+		return openRepository(gitDir, "", path)
+	}
+	return openRepository(path, "", "")
+}
+
+// openRepository opens the git directory gitDir. An empty commonDir is read
+// from gitDir/commondir, an empty workTree is derived from the git
+// directory and its config.
+func openRepository(gitDir, commonDir, workTree string) (*Repository, error) {
+	fm, err := os.Stat(gitDir)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +77,19 @@ func OpenRepository(path string) (*Repository, error) {
 		return nil, fmt.Errorf("%q is not a directory.", fm.Name())
 	}
 
-	indexfiles, err := filepath.Glob(filepath.Join(path, "objects/pack/*idx"))
+	repo := &Repository{Path: gitDir, CommonDir: commonDir, WorkTree: workTree}
+	if len(repo.CommonDir) == 0 {
+		repo.CommonDir = readCommonDir(gitDir)
+	}
+	if err := checkObjectFormat(repo.CommonDir); err != nil {
+		return nil, err
+	}
+	if len(repo.WorkTree) == 0 {
+		repo.WorkTree = defaultWorkTree(gitDir, repo.CommonDir)
+	}
+	repo.refs = newRefStore(repo.Path, repo.CommonDir)
+
+	indexfiles, err := filepath.Glob(filepath.Join(repo.CommonDir, "objects/pack/*idx"))
 	if err != nil {
 		return nil, err
 	}
@@ -70,3 +104,8 @@ func OpenRepository(path string) (*Repository, error) {
 
 	return repo, nil
 }
+
+// IsBare reports whether the repository has no working tree.
+func (repo *Repository) IsBare() bool {
+	return len(repo.WorkTree) == 0
+}
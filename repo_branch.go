@@ -2,105 +2,108 @@ package git
 
 import (
 	"errors"
-	"io"
-	"os"
-	"path/filepath"
-	"strings"
 )
 
 var (
 	ErrBranchExisted = errors.New("branch has existed")
+	ErrBranchIsHead  = errors.New("branch is the current HEAD")
 )
 
 func IsBranchExist(repoPath, branchName string) bool {
-	branchPath := filepath.Join(repoPath, "refs/heads", branchName)
-	return isFile(branchPath)
+	return isRefExist(openRefStore(repoPath), "refs/heads/"+branchName)
 }
 
 func (repo *Repository) IsBranchExist(branchName string) bool {
-	branchPath := filepath.Join(repo.Path, "refs/heads", branchName)
-	return isFile(branchPath)
+	return isRefExist(repo.refs, "refs/heads/"+branchName)
 }
 
 func (repo *Repository) GetBranches() ([]string, error) {
-	return repo.readRefDir("refs/heads", "")
+	return repo.refNames("refs/heads/")
 }
 
 func (repo *Repository) CreateBranch(branchName, idStr string) error {
-	return repo.createRef("heads", branchName, idStr)
+	return createRef(repo.refs, repo.signature(), "heads", branchName, idStr)
 }
 
-func (repo *Repository) createRef(head, branchName, idStr string) error {
+func CreateBranch(repoPath, branchName, id string) error {
+	return CreateRef("heads", repoPath, branchName, id)
+}
+
+func CreateRef(head, repoPath, branchName, id string) error {
+	return createRef(openRefStore(repoPath), defaultSignature(), head, branchName, id)
+}
+
+func createRef(store RefStore, committer *Signature, head, branchName, idStr string) error {
 	id, err := NewIdFromString(idStr)
 	if err != nil {
 		return err
 	}
 
-	branchPath := filepath.Join(repo.Path, "refs/"+head, branchName)
-	if isFile(branchPath) {
+	name := "refs/" + head + "/" + branchName
+	if isRefExist(store, name) {
 		return ErrBranchExisted
 	}
 
-	f, err := os.Create(branchPath)
-	if err != nil {
-		return err
+	err = store.Update(&RefUpdate{
+		Name:      name,
+		New:       id,
+		CheckOld:  true,
+		Committer: committer,
+		Message:   "branch: Created from " + idStr,
+	})
+	if err == ErrRefChanged {
+		return ErrBranchExisted
 	}
-
-	defer f.Close()
-
-	_, err = io.WriteString(f, id.String())
 	return err
 }
 
-func (repo *Repository) readRefDir(prefix, relPath string) ([]string, error) {
-	dirPath := filepath.Join(repo.Path, prefix, relPath)
-	f, err := os.Open(dirPath)
-	if err != nil {
-		return nil, err
+func isRefExist(store RefStore, name string) bool {
+	_, err := store.Ref(name)
+	return err == nil
+}
+
+// DeleteBranch removes the branch, whether it is stored loose or packed.
+// The branch HEAD points to cannot be deleted.
+func (repo *Repository) DeleteBranch(branchName string) error {
+	name := "refs/heads/" + branchName
+	if head, err := repo.refs.Ref("HEAD"); err == nil && head.Target == name {
+		return ErrBranchIsHead
 	}
-	defer f.Close()
+	return deleteRef(repo.refs, name)
+}
 
-	fis, err := f.Readdir(0)
-	if err != nil {
-		return nil, err
+// RenameBranch renames a branch together with its reflog. The HEAD of every
+// work tree follows the branch if it pointed to it.
+func (repo *Repository) RenameBranch(oldName, newName string) error {
+	oldRef, newRef := "refs/heads/"+oldName, "refs/heads/"+newName
+	if isRefExist(repo.refs, newRef) {
+		return ErrBranchExisted
 	}
 
-	names := make([]string, 0, len(fis))
-	for _, fi := range fis {
-		if strings.Contains(fi.Name(), ".DS_Store") {
-			continue
-		}
+	if err := repo.refs.Rename(oldRef, newRef, repo.signature()); err != nil {
+		return err
+	}
 
-		relFileName := filepath.Join(relPath, fi.Name())
-		if fi.IsDir() {
-			subnames, err := repo.readRefDir(prefix, relFileName)
+	// like git branch -m, every work tree on the branch follows it
+	for _, store := range repo.headStores() {
+		if head, err := store.Ref("HEAD"); err == nil && head.Target == oldRef {
+			err := repo.setHead(store, &RefUpdate{Name: "HEAD", Target: newRef},
+				"Branch: renamed "+oldRef+" to "+newRef)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			names = append(names, subnames...)
-			continue
 		}
-
-		names = append(names, relFileName)
 	}
-
-	return names, nil
-}
-
-func CreateBranch(repoPath, branchName, id string) error {
-	return CreateRef("heads", repoPath, branchName, id)
+	return nil
 }
 
-func CreateRef(head, repoPath, branchName, id string) error {
-	branchPath := filepath.Join(repoPath, "refs/"+head, branchName)
-	if isFile(branchPath) {
-		return ErrBranchExisted
-	}
-	f, err := os.Create(branchPath)
+func deleteRef(store RefStore, name string) error {
+	ref, err := store.Ref(name)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	_, err = io.WriteString(f, id)
-	return err
+	if ref.IsSymbolic() {
+		return store.Update(&RefUpdate{Name: name})
+	}
+	return store.Update(&RefUpdate{Name: name, Old: ref.Id, CheckOld: true})
 }
@@ -1,15 +1,8 @@
 package git
 
 import (
-	"bufio"
 	"container/list"
-	"errors"
-	"fmt"
 	"io/ioutil"
-	"os"
-	"path/filepath"
-	"regexp"
-	"strings"
 	"sync"
 )
 
@@ -18,10 +11,6 @@ const (
 	ItemsPerSearch = 100
 )
 
-var (
-	refRexp = regexp.MustCompile("ref: (.*)\n")
-)
-
 // get branch's last commit or a special commit by id string
 func (repo *Repository) GetCommitOfBranch(branchName string) (*Commit, error) {
 	commitId, err := repo.GetCommitIdOfBranch(branchName)
@@ -50,52 +39,11 @@ func (repo *Repository) GetCommitIdOfTag(tagName string) (string, error) {
 }
 
 func (repo *Repository) getCommitIdOfRef(refpath string) (string, error) {
-start:
-	f, err := ioutil.ReadFile(filepath.Join(repo.Path, refpath))
-	if err != nil {
-		f, err = repo.getCommitIdOfPackedRef(refpath)
-	}
+	ref, err := repo.ResolveRef(refpath)
 	if err != nil {
 		return "", err
 	}
-
-	allMatches := refRexp.FindAllStringSubmatch(string(f), 1)
-	if allMatches == nil {
-		// let's assume this is a sha1
-		if len(f) < 40 {
-			return "", errors.New("sha1 hash too short")
-		}
-		sha1 := string(f[:40])
-		if !IsSha1(sha1) {
-			return "", fmt.Errorf("heads file wrong sha1 string %s", sha1)
-		}
-		return sha1, nil
-	}
-	// yes, it's "ref: something". Now let's lookup "something"
-	refpath = allMatches[0][1]
-	goto start
-}
-
-func (repo *Repository) getCommitIdOfPackedRef(refpath string) ([]byte, error) {
-	f, err := os.Open(filepath.Join(repo.Path, "packed-refs"))
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	scan := bufio.NewScanner(f)
-
-	for scan.Scan() {
-		if strings.Contains(scan.Text(), refpath) {
-			return scan.Bytes(), nil
-		}
-	}
-
-	if err := scan.Err(); err != nil {
-		return nil, err
-	}
-
-	return nil, errors.New("Ref not found in packed-refs")
+	return ref.Id.String(), nil
 }
 
 // Find the commit object in the repository.
@@ -200,29 +148,19 @@ func (repo *Repository) fileCommitsCount(id sha1, file string) (int, error) {
 	return getter(), nil
 }
 
-// used only for single tree, (]
+// CommitsBetween returns the commits reachable from last but not from
+// before, newest first, like git rev-list before..last.
 func (repo *Repository) CommitsBetween(last *Commit, before *Commit) (*list.List, error) {
-	l := list.New()
-	if last == nil || last.ParentCount() == 0 {
-		return l, nil
+	if last == nil {
+		return list.New(), nil
 	}
 
-	var err error
-	cur := last
-	for {
-		if cur.Id.Equal(before.Id) {
-			break
-		}
-		l.PushBack(cur)
-		if cur.ParentCount() == 0 {
-			break
-		}
-		cur, err = cur.Parent(0)
-		if err != nil {
-			return nil, err
-		}
+	r := repo.newRevisionRange()
+	r.add(last, 0)
+	if before != nil {
+		r.add(before, rangeExcluded)
 	}
-	return l, nil
+	return r.Commits()
 }
 
 // CommitsBefore returns a list of all commits before commitId and *also*
@@ -313,19 +251,11 @@ func (repo *Repository) SearchCommits(commitId, keyword string) (*list.List, err
 }
 
 func (repo *Repository) searchCommits(id sha1, keyword string) (*list.List, error) {
-	commit, err := repo.getCommit(id)
-	if err != nil {
-		return nil, err
-	}
-
-	searcher, err := makeHistorySearcher(keyword)
-	if err != nil {
-		return nil, err
-	}
-
-	pager := makePager(searcher, 0, ItemsPerSearch)
-
-	return walkHistory(commit, pager)
+	return repo.Log(LogOptions{
+		Revisions: []string{id.String()},
+		Grep:      []string{keyword},
+		Limit:     ItemsPerSearch,
+	})
 }
 
 // GetCommitsByRange returns certain number of commits with given page of repository.
@@ -339,14 +269,11 @@ func (repo *Repository) CommitsByRange(commitId string, page int) (*list.List, e
 }
 
 func (repo *Repository) commitsByRange(id sha1, page int) (*list.List, error) {
-	commit, err := repo.getCommit(id)
-	if err != nil {
-		return nil, err
-	}
-
-	pager := makePager(nil, (page-1)*ItemsPerPage, ItemsPerPage)
-
-	return walkHistory(commit, pager)
+	return repo.Log(LogOptions{
+		Revisions: []string{id.String()},
+		Skip:      (page - 1) * ItemsPerPage,
+		Limit:     ItemsPerPage,
+	})
 }
 
 func (repo *Repository) CommitsByFileAndRange(branch, file string, page int) (*list.List, error) {
@@ -364,16 +291,12 @@ func (repo *Repository) CommitsByFileAndRange(branch, file string, page int) (*l
 }
 
 func (repo *Repository) commitsByFileAndRange(id sha1, path string, page int) (*list.List, error) {
-	commit, err := repo.getCommit(id)
-	if err != nil {
-		return nil, err
-	}
-
-	checker := makePathChecker(path)
-	pager := makePager(checker, (page-1)*ItemsPerPage, ItemsPerPage)
-	comparator := makePathComparator(path)
-
-	return walkFilteredHistory(commit, pager, comparator)
+	return repo.Log(LogOptions{
+		Revisions: []string{id.String()},
+		Paths:     []string{path},
+		Skip:      (page - 1) * ItemsPerPage,
+		Limit:     ItemsPerPage,
+	})
 }
 
 func (repo *Repository) GetCommitOfRelPath(commitId, relPath string) (*Commit, error) {
@@ -0,0 +1,199 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotRepository = errors.New("not a git repository")
+)
+
+// DiscoverRepository finds the repository path belongs to, like git does
+// when run in path: it looks for ".git" in path and each of its parents,
+// which may be a directory or a "gitdir:" file of a linked work tree or
+// submodule, and also accepts bare repositories.
+//
+// GIT_DIR, GIT_WORK_TREE, GIT_COMMON_DIR and GIT_CEILING_DIRECTORIES are
+// honored. With GIT_DIR set and no GIT_WORK_TREE, path is the root of the
+// working tree unless the repository is bare.
+func DiscoverRepository(path string) (*Repository, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	commonDir := os.Getenv("GIT_COMMON_DIR")
+	if len(commonDir) > 0 {
+		if commonDir, err = filepath.Abs(commonDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if gitDir := os.Getenv("GIT_DIR"); len(gitDir) > 0 {
+		if gitDir, err = filepath.Abs(gitDir); err != nil {
+			return nil, err
+		}
+		if gitDir, err = readGitDirLink(gitDir); err != nil {
+			return nil, err
+		}
+
+		workTree := os.Getenv("GIT_WORK_TREE")
+		if len(workTree) > 0 {
+			if workTree, err = filepath.Abs(workTree); err != nil {
+				return nil, err
+			}
+		} else if len(commonDir) == 0 {
+			commonDir = readCommonDir(gitDir)
+		}
+		if len(workTree) == 0 && !isBareConfig(commonDir) {
+			if workTree = configWorkTree(gitDir, commonDir); len(workTree) == 0 {
+				workTree = path
+			}
+		}
+		return openRepository(gitDir, commonDir, workTree)
+	}
+
+	ceilings := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")) {
+		if filepath.IsAbs(dir) {
+			ceilings[filepath.Clean(dir)] = true
+		}
+	}
+
+	for dir := path; ; {
+		if gitDir, err := readGitDirLink(filepath.Join(dir, ".git")); err == nil {
+			return openRepository(gitDir, commonDir, dir)
+		}
+		if isGitDir(dir) {
+			return openRepository(dir, commonDir, "")
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || ceilings[parent] {
+			return nil, ErrNotRepository
+		}
+		dir = parent
+	}
+}
+
+// readGitDirLink returns the git directory path stands for: path itself if
+// it is a git directory, or the target of a "gitdir: <path>" file.
+func readGitDirLink(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	gitDir := path
+	if !fi.IsDir() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir:") {
+			return "", fmt.Errorf("invalid gitfile format: %s", path)
+		}
+		gitDir = strings.TrimSpace(line[len("gitdir:"):])
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(filepath.Dir(path), gitDir)
+		}
+	}
+
+	if !isGitDir(gitDir) {
+		return "", ErrNotRepository
+	}
+	return filepath.Clean(gitDir), nil
+}
+
+// readCommonDir returns the common directory of gitDir, which a linked work
+// tree names in its commondir file.
+func readCommonDir(gitDir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+
+	dir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// isGitDir reports whether dir looks like a git directory: it has a HEAD,
+// and objects and refs in its common directory.
+func isGitDir(dir string) bool {
+	commonDir := readCommonDir(dir)
+	return isFile(filepath.Join(dir, "HEAD")) &&
+		isDir(filepath.Join(commonDir, "objects")) && isDir(filepath.Join(commonDir, "refs"))
+}
+
+func isBareConfig(commonDir string) bool {
+	cfg, err := OpenConfigFile(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return false
+	}
+	value, err := cfg.Get("core.bare")
+	if err != nil {
+		return false
+	}
+	bare, _ := ParseConfigBool(value)
+	return bare
+}
+
+// checkObjectFormat fails with ErrUnsupportedObjectFormat if the repository
+// uses another hash algorithm than sha1.
+func checkObjectFormat(commonDir string) error {
+	cfg, err := OpenConfigFile(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return nil
+	}
+	format, err := cfg.Get("extensions.objectformat")
+	if err != nil || strings.EqualFold(format, "sha1") {
+		return nil
+	}
+	return ErrUnsupportedObjectFormat
+}
+
+// configWorkTree returns core.worktree, which is relative to the git
+// directory.
+func configWorkTree(gitDir, commonDir string) string {
+	cfg, err := OpenConfigFile(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return ""
+	}
+	workTree, err := cfg.Get("core.worktree")
+	if err != nil || len(workTree) == 0 {
+		return ""
+	}
+	if !filepath.IsAbs(workTree) {
+		workTree = filepath.Join(gitDir, workTree)
+	}
+	return filepath.Clean(workTree)
+}
+
+// defaultWorkTree guesses the working tree of the git directory gitDir when
+// it is opened directly.
+func defaultWorkTree(gitDir, commonDir string) string {
+	if isBareConfig(commonDir) {
+		return ""
+	}
+
+	// a linked work tree records the path of its ".git" file
+	if data, err := ioutil.ReadFile(filepath.Join(gitDir, "gitdir")); err == nil && gitDir != commonDir {
+		return filepath.Dir(strings.TrimSpace(string(data)))
+	}
+	if workTree := configWorkTree(gitDir, commonDir); len(workTree) > 0 {
+		return workTree
+	}
+	if filepath.Base(gitDir) == ".git" {
+		return filepath.Dir(gitDir)
+	}
+	return ""
+}
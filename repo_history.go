@@ -36,62 +36,160 @@ func walkFilteredHistory(start *Commit, callback CommitWalkCallback,
 	return walkHistoryLoop([]*Commit{start}, callback, eq)
 }
 
+// walkOrder is the order in which commits are passed to the callback.
+type walkOrder int
+
+const (
+	// newest committer date first
+	walkDateOrder walkOrder = iota
+	// parents after all of their children, else newest committer date
+	// first
+	walkStrictDateOrder
+	// parents after all of their children, else newest author date first
+	walkAuthorDateOrder
+	// parents after all of their children, the commits of a branch
+	// together
+	walkTopoOrder
+)
+
+// walkOptions changes how walkHistoryLoopWith follows the history.
+type walkOptions struct {
+	order walkOrder
+
+	// follow only the first parent of merges
+	firstParent bool
+
+	// do not drop commits the comparator considers equal to the parents of
+	// a commit, only skip equal parents
+	keepEqualRoots bool
+}
+
+// parentCount returns the number of parents of commit to follow.
+func (opts walkOptions) parentCount(commit *Commit) int {
+	if opts.firstParent && commit.ParentCount() > 1 {
+		return 1
+	}
+	return commit.ParentCount()
+}
+
+// historyWalker hands out the commits taken by the callback of a walk one
+// at a time. next returns nil at the end of the walk.
+type historyWalker interface {
+	next() (*Commit, error)
+}
+
 // roots must be not equal to each other
 func walkHistoryLoop(roots []*Commit, callback CommitWalkCallback,
 	eq CommitComparator) (*list.List, error) {
 
-	results := list.New()
-	seen := make(map[sha1]struct{})
+	return walkHistoryLoopWith(roots, callback, eq, walkOptions{})
+}
+
+func walkHistoryLoopWith(roots []*Commit, callback CommitWalkCallback,
+	eq CommitComparator, opts walkOptions) (*list.List, error) {
+
+	w, err := newHistoryWalker(roots, callback, eq, opts)
+	if err != nil {
+		return nil, err
+	}
+	return collectHistory(w)
+}
 
+func newHistoryWalker(roots []*Commit, callback CommitWalkCallback,
+	eq CommitComparator, opts walkOptions) (historyWalker, error) {
+
+	if opts.order != walkDateOrder {
+		return newTopoWalker(roots, callback, eq, opts)
+	}
+	return &dateWalker{
+		roots:    roots,
+		callback: callback,
+		eq:       eq,
+		opts:     opts,
+		seen:     make(map[sha1]struct{}),
+	}, nil
+}
+
+// collectHistory returns all commits of a walk.
+func collectHistory(w historyWalker) (*list.List, error) {
+	results := list.New()
 	for {
-		if len(roots) == 0 {
+		commit, err := w.next()
+		if err != nil {
+			return nil, err
+		}
+		if commit == nil {
 			return results, nil
 		}
+		results.PushBack(commit)
+	}
+}
 
+// dateWalker walks the history newest committer date first.
+type dateWalker struct {
+	roots    []*Commit
+	callback CommitWalkCallback
+	eq       CommitComparator
+	opts     walkOptions
+	seen     map[sha1]struct{}
+	stopped  bool
+}
+
+func (w *dateWalker) next() (*Commit, error) {
+	for !w.stopped && len(w.roots) > 0 {
 		var err error
 
-		roots, err = simplifyRoots(roots, eq, seen)
+		w.roots, err = simplifyRoots(w.roots, w.eq, w.seen, w.opts)
 		if err != nil {
+			w.stopped = true
 			return nil, err
 		}
 
-		if len(roots) == 0 {
-			return results, nil
+		if len(w.roots) == 0 {
+			break
 		}
 
 		var next *Commit
-		next, roots = extractNewestCommit(roots)
+		next, w.roots = extractNewestCommit(w.roots)
 
-		action, err := callback(next)
+		action, err := w.callback(next)
 		if err != nil {
+			w.stopped = true
 			return nil, err
 		}
 
 		if action&HWTakeCommit > 0 {
 			// witness commit
-			results.PushBack(next)
-			seen[next.Id] = struct{}{}
+			w.seen[next.Id] = struct{}{}
 		}
 
 		if action&HWFollowParents > 0 {
 			// follow all parents of commit
-			pars, err := parents(next)
+			pars, err := parents(next, w.opts)
 			if err != nil {
+				w.stopped = true
 				return nil, err
 			}
-			roots = mergeRoots(pars, roots, eq, seen)
+			mergeEq := w.eq
+			if w.opts.keepEqualRoots {
+				mergeEq = nopComparator
+			}
+			w.roots = mergeRoots(pars, w.roots, mergeEq, w.seen)
 		}
 
 		if action&HWStop > 0 {
-			return results, nil
+			w.stopped = true
 		}
-	}
 
-	return results, nil
+		if action&HWTakeCommit > 0 {
+			return next, nil
+		}
+	}
+	return nil, nil
 }
 
-func parents(commit *Commit) ([]*Commit, error) {
-	parents := make([]*Commit, commit.ParentCount())
+func parents(commit *Commit, opts walkOptions) ([]*Commit, error) {
+	parents := make([]*Commit, opts.parentCount(commit))
 	for idx := 0; idx < len(parents); idx++ {
 		var err error
 		parents[idx], err = commit.Parent(idx)
@@ -130,7 +228,7 @@ func mergeRoots(base, merging []*Commit, eq CommitComparator, seen map[sha1]stru
 // that equals to current commit the current commit will be dropped and parent will be followed
 // see "History Simplification" chapter of git-log man for full details.
 func skipEqualCommits(commit *Commit, eq CommitComparator,
-	seen map[sha1]struct{}) (*Commit, error) {
+	seen map[sha1]struct{}, opts walkOptions) (*Commit, error) {
 
 	for {
 		// we already seen that commit, no point to traverse further
@@ -143,7 +241,7 @@ func skipEqualCommits(commit *Commit, eq CommitComparator,
 		}
 
 		var found bool
-		for idx := 0; idx < commit.ParentCount(); idx++ {
+		for idx := 0; idx < opts.parentCount(commit); idx++ {
 			parent, err := commit.Parent(idx)
 			if err != nil {
 				return nil, err
@@ -168,11 +266,11 @@ func skipEqualCommits(commit *Commit, eq CommitComparator,
 }
 
 func simplifyRoots(roots []*Commit, eq CommitComparator,
-	seen map[sha1]struct{}) ([]*Commit, error) {
+	seen map[sha1]struct{}, opts walkOptions) ([]*Commit, error) {
 
 	newRoots := []*Commit{}
 	for _, commit := range roots {
-		commit, err := skipEqualCommits(commit, eq, seen)
+		commit, err := skipEqualCommits(commit, eq, seen, opts)
 		if err != nil {
 			return nil, err
 		}
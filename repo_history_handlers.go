@@ -1,9 +1,5 @@
 package git
 
-import (
-	"regexp"
-)
-
 func commitRootComparator(current, parent *Commit) bool {
 	return current.TreeId().Equal(parent.TreeId())
 }
@@ -25,6 +21,22 @@ func makePathComparator(path string) CommitComparator {
 	}
 }
 
+// makePathsComparator considers commits equal if none of paths differ.
+func makePathsComparator(paths []string) CommitComparator {
+	eqs := make([]CommitComparator, len(paths))
+	for i, path := range paths {
+		eqs[i] = makePathComparator(path)
+	}
+	return func(current, parent *Commit) bool {
+		for _, eq := range eqs {
+			if !eq(current, parent) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 func makePathChecker(path string) (cb CommitWalkCallback) {
 	return func(commit *Commit) (HistoryWalkerAction, error) {
 		_, err := commit.GetTreeEntryByPath(path)
@@ -40,21 +52,6 @@ func makePathChecker(path string) (cb CommitWalkCallback) {
 	}
 }
 
-func makeHistorySearcher(needle string) (cb CommitWalkCallback, err error) {
-	matcher, err := regexp.Compile(needle)
-	if err != nil {
-		return nil, err
-	}
-
-	return func(commit *Commit) (HistoryWalkerAction, error) {
-		if matcher.MatchString(commit.CommitMessage) {
-			return HWTakeAndFollow, nil
-		}
-
-		return HWFollowParents, nil
-	}, nil
-}
-
 func nopCallback(*Commit) (HistoryWalkerAction, error) {
 	return HWTakeAndFollow, nil
 }
@@ -0,0 +1,221 @@
+package git
+
+import (
+	"sort"
+)
+
+// topoWalker passes commits to the callback only after all of their
+// children, like git's incremental topological walk. The children of a
+// commit are counted by walking down to its generation number, which
+// covers the whole history without a commit-graph.
+type topoWalker struct {
+	repo     *Repository
+	callback CommitWalkCallback
+	eq       CommitComparator
+	opts     walkOptions
+	stopped  bool
+
+	// 1 + the number of children not passed yet, 0 if not counted
+	indegree map[sha1]int
+	pending  *commitQueue
+	minGen   uint32
+
+	// commits whose children were all passed, in output order
+	ready *commitQueue
+	stack []*Commit
+
+	// commits reached from a followed child
+	followed map[sha1]bool
+}
+
+func newTopoWalker(roots []*Commit, callback CommitWalkCallback,
+	eq CommitComparator, opts walkOptions) (*topoWalker, error) {
+
+	w := &topoWalker{
+		callback: callback,
+		eq:       eq,
+		opts:     opts,
+		indegree: make(map[sha1]int),
+		minGen:   generationInfinity,
+		ready:    &commitQueue{byAuthor: opts.order == walkAuthorDateOrder},
+		followed: make(map[sha1]bool),
+	}
+	if len(roots) == 0 {
+		return w, nil
+	}
+
+	// like git, start with the newest root
+	roots = append([]*Commit(nil), roots...)
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].Committer.When.After(roots[j].Committer.When)
+	})
+
+	w.repo = roots[0].repo
+	w.pending = &commitQueue{generation: w.repo.generation}
+	for _, c := range roots {
+		if w.indegree[c.Id] == 0 {
+			w.indegree[c.Id] = 1
+			w.pending.push(c)
+		}
+		w.followed[c.Id] = true
+		if gen := w.repo.generation(c); gen < w.minGen {
+			w.minGen = gen
+		}
+	}
+	if err := w.countChildren(); err != nil {
+		return nil, err
+	}
+	// the first root is passed first from the stack as well
+	pushed := make(map[sha1]bool)
+	for i := range roots {
+		c := roots[i]
+		if opts.order == walkTopoOrder {
+			c = roots[len(roots)-1-i]
+		}
+		if w.indegree[c.Id] == 1 && !pushed[c.Id] {
+			pushed[c.Id] = true
+			w.push(c)
+		}
+	}
+	return w, nil
+}
+
+func (w *topoWalker) next() (*Commit, error) {
+	for !w.stopped {
+		c := w.pop()
+		if c == nil {
+			break
+		}
+
+		taken, err := w.step(c)
+		if err != nil {
+			w.stopped = true
+			return nil, err
+		}
+		if taken {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// step passes c to the callback and its parents the child c.
+func (w *topoWalker) step(c *Commit) (bool, error) {
+	// like skipEqualCommits, follow only the parent equal to c
+	var same *Commit
+	for i := 0; i < w.opts.parentCount(c); i++ {
+		parent, err := c.Parent(i)
+		if err != nil {
+			return false, err
+		}
+		if w.eq(c, parent) {
+			same = parent
+			break
+		}
+	}
+
+	action := HWFollowParents
+	if same == nil {
+		var err error
+		if action, err = w.callback(c); err != nil {
+			return false, err
+		}
+	}
+	if action&HWStop > 0 {
+		w.stopped = true
+		return action&HWTakeCommit > 0, nil
+	}
+
+	for i := 0; i < w.opts.parentCount(c); i++ {
+		parent, err := c.Parent(i)
+		if err != nil {
+			return false, err
+		}
+		if action&HWFollowParents > 0 && (same == nil || same == parent) {
+			w.followed[parent.Id] = true
+		}
+		if err := w.passChild(parent); err != nil {
+			return false, err
+		}
+	}
+	return action&HWTakeCommit > 0, nil
+}
+
+// countChildren counts the children of the commits down to minGen.
+func (w *topoWalker) countChildren() error {
+	for w.pending.Len() > 0 && w.repo.generation(w.pending.items[0].commit) >= w.minGen {
+		c := w.pending.pop()
+		for i := 0; i < w.opts.parentCount(c); i++ {
+			parent, err := c.Parent(i)
+			if err != nil {
+				return err
+			}
+			if w.indegree[parent.Id] > 0 {
+				w.indegree[parent.Id]++
+				continue
+			}
+			w.indegree[parent.Id] = 2
+			w.pending.push(parent)
+		}
+	}
+	return nil
+}
+
+// passChild notes that a child of parent was passed to the callback or
+// skipped. Commits which are not followed are skipped as soon as all of
+// their children are, so that their parents do not wait for them.
+func (w *topoWalker) passChild(parent *Commit) error {
+	todo := []*Commit{parent}
+	for len(todo) > 0 {
+		c := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		if gen := w.repo.generation(c); gen < w.minGen {
+			// all children of c have a higher generation
+			w.minGen = gen
+			if err := w.countChildren(); err != nil {
+				return err
+			}
+		}
+		w.indegree[c.Id]--
+		if w.indegree[c.Id] != 1 {
+			continue
+		}
+		if w.followed[c.Id] {
+			w.push(c)
+			continue
+		}
+
+		for i := 0; i < w.opts.parentCount(c); i++ {
+			p, err := c.Parent(i)
+			if err != nil {
+				return err
+			}
+			todo = append(todo, p)
+		}
+	}
+	return nil
+}
+
+func (w *topoWalker) push(c *Commit) {
+	if w.opts.order == walkTopoOrder {
+		w.stack = append(w.stack, c)
+	} else {
+		w.ready.push(c)
+	}
+}
+
+func (w *topoWalker) pop() *Commit {
+	if w.opts.order == walkTopoOrder {
+		if len(w.stack) == 0 {
+			return nil
+		}
+		c := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+		return c
+	}
+	if w.ready.Len() == 0 {
+		return nil
+	}
+	return w.ready.pop()
+}
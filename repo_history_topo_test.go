@@ -0,0 +1,107 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTopologicalWalk(t *testing.T) {
+	r := copyTestRepo(t)
+	tree := storeTestTree(t, r, nil)
+
+	// p has a newer commit date than its child x
+	p := storeTestCommit(t, r, tree, 40)
+	x := storeTestCommit(t, r, tree, 30, p.Id)
+	m := storeTestCommit(t, r, tree, 50, x.Id, p.Id)
+	names := map[sha1]string{p.Id: "p", x.Id: "x", m.Id: "m"}
+
+	log := func(order LogOrder) string {
+		l, err := r.Log(LogOptions{Revisions: []string{m.Id.String()}, Order: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for e := l.Front(); e != nil; e = e.Next() {
+			s = append(s, names[e.Value.(*Commit).Id])
+		}
+		return strings.Join(s, " ")
+	}
+
+	check := func() {
+		for order, expected := range map[LogOrder]string{
+			OrderDate:       "m p x",
+			OrderStrictDate: "m x p",
+			OrderTopo:       "m x p",
+		} {
+			if got := log(order); got != expected {
+				t.Errorf("unexpected order %d: %s", order, got)
+			}
+		}
+	}
+	check()
+
+	// the same with generation numbers from a commit-graph
+	writeTestCommitGraph(t, r, map[sha1]uint32{p.Id: 1, x.Id: 2, m.Id: 3})
+	r.commitGraphLoaded = false
+	if gen := r.generation(x); gen != 2 {
+		t.Errorf("unexpected generation %d", gen)
+	}
+	check()
+}
+
+// writeTestCommitGraph writes a commit-graph with only the generation
+// numbers filled in.
+func writeTestCommitGraph(t *testing.T, r *Repository, gens map[sha1]uint32) {
+	var ids []sha1
+	for id := range gens {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	fanout := make([]byte, 256*4)
+	for b := 0; b < 256; b++ {
+		n := 0
+		for _, id := range ids {
+			if int(id[0]) <= b {
+				n++
+			}
+		}
+		binary.BigEndian.PutUint32(fanout[4*b:], uint32(n))
+	}
+	var oids, data []byte
+	for _, id := range ids {
+		oids = append(oids, id[:]...)
+		entry := make([]byte, 36)
+		binary.BigEndian.PutUint32(entry[28:], gens[id]<<2)
+		data = append(data, entry...)
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{'C', 'G', 'P', 'H', 1, 1, 3, 0})
+	offset := uint64(8 + 12*4)
+	for _, chunk := range []struct {
+		id   string
+		data []byte
+	}{{"OIDF", fanout}, {"OIDL", oids}, {"CDAT", data}, {"\x00\x00\x00\x00", nil}} {
+		buf.WriteString(chunk.id)
+		binary.Write(&buf, binary.BigEndian, offset)
+		offset += uint64(len(chunk.data))
+	}
+	buf.Write(fanout)
+	buf.Write(oids)
+	buf.Write(data)
+
+	dir := filepath.Join(r.CommonDir, "objects", "info")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "commit-graph"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrRepoExisted             = errors.New("repository has existed")
+	ErrUnsupportedObjectFormat = errors.New("unsupported object format")
+)
+
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository.\n"
+
+// InitOptions configures InitRepository.
+type InitOptions struct {
+	// InitialBranch is the branch HEAD points to. It defaults to
+	// init.defaultBranch from the global config, or "master".
+	InitialBranch string
+
+	// ObjectFormat is the hash algorithm of the repository. This package
+	// only handles "sha1", the default; other formats like "sha256" fail
+	// with ErrUnsupportedObjectFormat.
+	ObjectFormat string
+
+	// Shared makes the repository writable by other users like
+	// git init --shared: "group" (or "true"), "all" (or "world",
+	// "everybody"), an octal file mode like "0660", or "umask" (or
+	// "false", the default) to use the permissions of the process.
+	Shared string
+
+	// Description is written to the description file.
+	Description string
+}
+
+// sharedPerm returns the permissions for files and directories and the
+// value of core.sharedRepository for a --shared option. A zero file mode
+// leaves permissions to the umask.
+func sharedPerm(shared string) (file, dir os.FileMode, value string, err error) {
+	switch strings.ToLower(shared) {
+	case "", "umask", "false":
+		return 0, 0, "", nil
+	case "group", "true":
+		return 0660, 0770 | os.ModeSetgid, "1", nil
+	case "all", "world", "everybody":
+		return 0664, 0775 | os.ModeSetgid, "2", nil
+	}
+
+	mode, err := strconv.ParseUint(shared, 8, 32)
+	if err != nil || mode&0600 != 0600 || mode > 0777 {
+		return 0, 0, "", fmt.Errorf("invalid shared permissions %q", shared)
+	}
+	file = os.FileMode(mode)
+	// directories are searchable wherever files are readable
+	dir = file | file&0444>>2 | os.ModeSetgid
+	return file, dir, fmt.Sprintf("0%o", mode), nil
+}
+
+// InitRepository creates a new repository at path, like git init. For a
+// bare repository path is the git directory itself, otherwise the git
+// directory is path/.git. An existing repository is not touched.
+func InitRepository(path string, bare bool, opts InitOptions) (*Repository, error) {
+	gitDir := path
+	if !bare {
+		gitDir = filepath.Join(path, ".git")
+	}
+	if isFile(filepath.Join(gitDir, "HEAD")) {
+		return nil, ErrRepoExisted
+	}
+
+	branch := opts.InitialBranch
+	if len(branch) == 0 {
+		branch = "master"
+		if cfg, err := LoadConfig(""); err == nil {
+			if name, err := cfg.Get("init.defaultBranch"); err == nil && len(name) > 0 {
+				branch = name
+			}
+		}
+	}
+	if !IsValidRefName("refs/heads/" + branch) {
+		return nil, fmt.Errorf("invalid initial branch name %q", branch)
+	}
+
+	format := strings.ToLower(opts.ObjectFormat)
+	if len(format) == 0 {
+		format = "sha1"
+	}
+	if format != "sha1" {
+		return nil, ErrUnsupportedObjectFormat
+	}
+
+	filePerm, dirPerm, sharedValue, err := sharedPerm(opts.Shared)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range []string{"", "objects", "objects/info", "objects/pack", "refs", "refs/heads", "refs/tags", "hooks", "info"} {
+		if err := mkdirShared(filepath.Join(gitDir, dir), dirPerm); err != nil {
+			return nil, err
+		}
+	}
+
+	description := opts.Description
+	if len(description) == 0 {
+		description = defaultDescription
+	} else if !strings.HasSuffix(description, "\n") {
+		description += "\n"
+	}
+	files := map[string]string{
+		"HEAD":        "ref: refs/heads/" + branch + "\n",
+		"description": description,
+	}
+	for name, content := range files {
+		if err := writeShared(filepath.Join(gitDir, name), []byte(content), filePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := OpenConfigFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	settings := [][2]string{
+		{"core.repositoryformatversion", "0"},
+		{"core.filemode", "true"},
+		{"core.bare", strconv.FormatBool(bare)},
+	}
+	if !bare {
+		settings = append(settings, [2]string{"core.logallrefupdates", "true"})
+	}
+	if len(sharedValue) > 0 {
+		settings = append(settings, [2]string{"core.sharedrepository", sharedValue})
+	}
+	for _, kv := range settings {
+		if err := cfg.Set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeShared(cfg.Path, cfg.Bytes(), filePerm); err != nil {
+		return nil, err
+	}
+	return OpenRepository(gitDir)
+}
+
+func mkdirShared(dir string, perm os.FileMode) error {
+	if perm == 0 {
+		return os.MkdirAll(dir, 0755)
+	}
+	if err := os.MkdirAll(dir, perm.Perm()); err != nil {
+		return err
+	}
+	// set explicitly, the umask applies to MkdirAll
+	return os.Chmod(dir, perm)
+}
+
+func writeShared(path string, data []byte, perm os.FileMode) error {
+	if perm == 0 {
+		return ioutil.WriteFile(path, data, 0644)
+	}
+	if err := ioutil.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
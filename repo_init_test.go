@@ -0,0 +1,161 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitRepository(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "work")
+	r, err := InitRepository(dir, false, InitOptions{InitialBranch: "main", Shared: "group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Path != filepath.Join(dir, ".git") {
+		t.Fatalf("unexpected git dir %q", r.Path)
+	}
+
+	head, err := r.Ref("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Target != "refs/heads/main" {
+		t.Fatalf("HEAD points to %q", head.Target)
+	}
+
+	cfg, err := OpenConfigFile(filepath.Join(r.Path, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bare, _ := cfg.Get("core.bare"); bare != "false" {
+		t.Fatalf("core.bare = %q", bare)
+	}
+	if shared, _ := cfg.Get("core.sharedRepository"); shared != "1" {
+		t.Fatalf("core.sharedRepository = %q", shared)
+	}
+
+	fi, err := os.Stat(filepath.Join(r.Path, "refs", "heads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSetgid == 0 || fi.Mode().Perm() != 0770 {
+		t.Fatalf("unexpected shared directory mode %v", fi.Mode())
+	}
+
+	if _, err := InitRepository(dir, false, InitOptions{}); err != ErrRepoExisted {
+		t.Fatalf("expected ErrRepoExisted, got %v", err)
+	}
+	for _, format := range []string{"md5", "sha256"} {
+		if _, err := InitRepository(filepath.Join(t.TempDir(), "x.git"), true, InitOptions{ObjectFormat: format}); err != ErrUnsupportedObjectFormat {
+			t.Fatalf("expected ErrUnsupportedObjectFormat for %s, got %v", format, err)
+		}
+	}
+
+	// repositories created by git with another hash cannot be opened
+	sha256Dir := filepath.Join(t.TempDir(), "y.git")
+	if _, err := InitRepository(sha256Dir, true, InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = OpenConfigFile(filepath.Join(sha256Dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("extensions.objectformat", "sha256"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRepository(sha256Dir); err != ErrUnsupportedObjectFormat {
+		t.Fatalf("expected ErrUnsupportedObjectFormat, got %v", err)
+	}
+}
+
+func TestDiscoverRepository(t *testing.T) {
+	t.Setenv("GIT_DIR", "")
+	t.Setenv("GIT_COMMON_DIR", "")
+	t.Setenv("GIT_CEILING_DIRECTORIES", "")
+
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main")
+	if _, err := InitRepository(main, false, InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(main, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := DiscoverRepository(filepath.Join(main, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Path != filepath.Join(main, ".git") || r.WorkTree != main || r.IsBare() {
+		t.Fatalf("unexpected repository %q, work tree %q", r.Path, r.WorkTree)
+	}
+
+	// a linked work tree as created by git worktree add
+	wt := filepath.Join(dir, "wt")
+	wtGitDir := filepath.Join(main, ".git", "worktrees", "wt")
+	files := map[string]string{
+		filepath.Join(wtGitDir, "HEAD"):      "ref: refs/heads/topic\n",
+		filepath.Join(wtGitDir, "commondir"): "../..\n",
+		filepath.Join(wtGitDir, "gitdir"):    filepath.Join(wt, ".git") + "\n",
+		filepath.Join(wt, ".git"):            "gitdir: " + wtGitDir + "\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err = DiscoverRepository(wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Path != wtGitDir || r.CommonDir != filepath.Join(main, ".git") || r.WorkTree != wt {
+		t.Fatalf("unexpected linked work tree %q, %q, %q", r.Path, r.CommonDir, r.WorkTree)
+	}
+	head, err := r.Ref("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Target != "refs/heads/topic" {
+		t.Fatalf("work tree HEAD points to %q", head.Target)
+	}
+
+	r, err = OpenRepository(wtGitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.WorkTree != wt {
+		t.Fatalf("work tree of linked git directory is %q", r.WorkTree)
+	}
+
+	// renaming the branch from the main work tree moves the linked HEAD
+	mainRepo, err := OpenRepository(filepath.Join(main, ".git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mainRepo.CreateBranch("topic", "c3ca89834257974d7375ac7915ed58d01afe7d4b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mainRepo.RenameBranch("topic", "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := r.Ref("HEAD"); err != nil || head.Target != "refs/heads/feature" {
+		t.Fatalf("linked work tree HEAD did not follow rename: %+v %v", head, err)
+	}
+	if head, err := mainRepo.Ref("HEAD"); err != nil || head.Target != "refs/heads/master" {
+		t.Fatalf("main HEAD changed: %+v %v", head, err)
+	}
+
+	t.Setenv("GIT_CEILING_DIRECTORIES", dir)
+	if _, err := DiscoverRepository(filepath.Join(dir, "elsewhere")); err != ErrNotRepository {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+}
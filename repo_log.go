@@ -0,0 +1,253 @@
+package git
+
+import (
+	"container/list"
+	"regexp"
+	"time"
+)
+
+// LogOrder is the order of the commits returned by Log.
+type LogOrder int
+
+const (
+	// newest committer date first
+	OrderDate LogOrder = iota
+	// newest author date first, but parents after all of their children
+	OrderAuthorDate
+	// parents after all of their children, the commits of a branch
+	// together
+	OrderTopo
+	// newest committer date first, but parents after all of their children
+	OrderStrictDate
+)
+
+var logWalkOrders = map[LogOrder]walkOrder{
+	OrderDate:       walkDateOrder,
+	OrderAuthorDate: walkAuthorDateOrder,
+	OrderTopo:       walkTopoOrder,
+	OrderStrictDate: walkStrictDateOrder,
+}
+
+// LogOptions selects the commits returned by Log. All filters have to
+// match, the zero value lists the history of HEAD.
+type LogOptions struct {
+	// Revisions to start from, with exclusions and ranges as taken by
+	// RevisionRange. HEAD if empty.
+	Revisions []string
+
+	// Paths limits the history to the commits changing any of these files
+	// or directories.
+	Paths []string
+
+	// Author and Committer are regular expressions matched against
+	// "Name <email>".
+	Author    string
+	Committer string
+
+	// Grep are regular expressions matched against the commit message. Any
+	// of them has to match, or all of them with AllMatch.
+	Grep     []string
+	AllMatch bool
+
+	// Since and Until limit the committer date if set. The history is not
+	// followed past commits older than Since.
+	Since time.Time
+	Until time.Time
+
+	// FirstParent follows only the first parent of merges.
+	FirstParent bool
+
+	// NoMerges and MergesOnly select commits by their number of parents,
+	// as do MinParents and MaxParents if not zero.
+	NoMerges   bool
+	MergesOnly bool
+	MinParents int
+	MaxParents int
+
+	// Skip that many commits, then return up to Limit, all if zero.
+	Skip  int
+	Limit int
+
+	Order LogOrder
+
+	// Reverse returns the selected commits oldest first.
+	Reverse bool
+}
+
+// Log returns the commits selected by opts, like git log.
+func (repo *Repository) Log(opts LogOptions) (*list.List, error) {
+	it, err := repo.LogIter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return collectHistory(it.w)
+}
+
+// LogIter is Log, returning the commits while walking the history. With
+// Reverse all commits are walked first.
+func (repo *Repository) LogIter(opts LogOptions) (*CommitIter, error) {
+	revs := opts.Revisions
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	r, err := repo.RevisionRange(revs...)
+	if err != nil {
+		return nil, err
+	}
+	match, err := makeLogMatcher(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	eq := nopComparator
+	if len(opts.Paths) > 0 {
+		eq = makePathsComparator(opts.Paths)
+	}
+	walkOpts := walkOptions{
+		order:          logWalkOrders[opts.Order],
+		firstParent:    opts.FirstParent,
+		keepEqualRoots: true,
+	}
+
+	count := opts.Limit
+	if count == 0 {
+		// a negative count does not limit the pager
+		count = -1
+	}
+	pager := makePager(makeLogWalker(opts, eq, walkOpts, match), opts.Skip, count)
+	w, err := r.walker(pager, eq, walkOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Reverse {
+		var commits []*Commit
+		for {
+			c, err := w.next()
+			if err != nil {
+				return nil, err
+			}
+			if c == nil {
+				break
+			}
+			commits = append(commits, c)
+		}
+		for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+			commits[i], commits[j] = commits[j], commits[i]
+		}
+		w = &sliceWalker{commits}
+	}
+	return &CommitIter{w: w}, nil
+}
+
+// makeLogWalker takes the commits of the walk which change the paths, are
+// in the date limits and match.
+func makeLogWalker(opts LogOptions, eq CommitComparator, walkOpts walkOptions, match func(*Commit) bool) CommitWalkCallback {
+	// the walk only remembers taken commits, do not revisit the others
+	visited := make(map[sha1]bool)
+	return func(commit *Commit) (HistoryWalkerAction, error) {
+		if visited[commit.Id] {
+			return HWDrop, nil
+		}
+		visited[commit.Id] = true
+		if !opts.Since.IsZero() && commit.Committer.When.Before(opts.Since) {
+			return HWDrop, nil
+		}
+		if !opts.Until.IsZero() && commit.Committer.When.After(opts.Until) {
+			return HWFollowParents, nil
+		}
+		if len(opts.Paths) > 0 {
+			changed, err := changesPaths(commit, opts.Paths, eq, walkOpts)
+			if err != nil {
+				return HWStop, err
+			}
+			if !changed {
+				return HWFollowParents, nil
+			}
+		}
+		if !match(commit) {
+			return HWFollowParents, nil
+		}
+		return HWTakeAndFollow, nil
+	}
+}
+
+// changesPaths reports whether the commit differs from any of its parents
+// in paths. The walk already skips commits equal to a parent, but not to
+// excluded ones.
+func changesPaths(commit *Commit, paths []string, eq CommitComparator, opts walkOptions) (bool, error) {
+	n := opts.parentCount(commit)
+	if n == 0 {
+		for _, path := range paths {
+			if _, err := commit.GetTreeEntryByPath(path); err == nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	for i := 0; i < n; i++ {
+		parent, err := commit.Parent(i)
+		if err != nil {
+			return false, err
+		}
+		if !eq(commit, parent) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// makeLogMatcher returns whether a commit passes the filters of opts.
+func makeLogMatcher(opts LogOptions) (func(*Commit) bool, error) {
+	var author, committer *regexp.Regexp
+	var err error
+	if len(opts.Author) > 0 {
+		if author, err = regexp.Compile(opts.Author); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Committer) > 0 {
+		if committer, err = regexp.Compile(opts.Committer); err != nil {
+			return nil, err
+		}
+	}
+	grep := make([]*regexp.Regexp, len(opts.Grep))
+	for i, expr := range opts.Grep {
+		if grep[i], err = regexp.Compile(expr); err != nil {
+			return nil, err
+		}
+	}
+
+	minParents, maxParents := opts.MinParents, opts.MaxParents
+	if opts.MergesOnly && minParents < 2 {
+		minParents = 2
+	}
+	if opts.NoMerges && (maxParents == 0 || maxParents > 1) {
+		maxParents = 1
+	}
+
+	return func(c *Commit) bool {
+		n := c.ParentCount()
+		if n < minParents || maxParents > 0 && n > maxParents {
+			return false
+		}
+		if author != nil && !author.MatchString(c.Author.String()) {
+			return false
+		}
+		if committer != nil && !committer.MatchString(c.Committer.String()) {
+			return false
+		}
+		if len(grep) > 0 {
+			matched := 0
+			for _, re := range grep {
+				if re.MatchString(c.CommitMessage) {
+					matched++
+				}
+			}
+			if matched == 0 || opts.AllMatch && matched < len(grep) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	r, err := OpenRepository("testdata/test.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := []string{"master"}
+	tests := []struct {
+		opts     LogOptions
+		expected string
+	}{
+		{LogOptions{Revisions: master, Order: OrderTopo}, "c3ca898 0db8902 eff6dbc ee1fe12 11d6aed c08a875 d6360cd 8d78696 629bc57 8ac1880 49e1fdd 48a0b58 b1188d0 d0eac37"},
+		{LogOptions{Revisions: master, FirstParent: true, NoMerges: true}, "629bc57 8ac1880 49e1fdd 48a0b58 b1188d0 d0eac37"},
+		{LogOptions{Revisions: master, MergesOnly: true, Reverse: true}, "d6360cd 11d6aed eff6dbc c3ca898"},
+		{LogOptions{Revisions: master, Grep: []string{"main", "3"}, AllMatch: true}, "c08a875 49e1fdd"},
+		{LogOptions{Revisions: master, Skip: 2, Limit: 3}, "ee1fe12 0db8902 11d6aed"},
+		{LogOptions{Revisions: master, Paths: []string{"data"}}, "c3ca898 0db8902 629bc57 8ac1880 49e1fdd 48a0b58 b1188d0"},
+		{LogOptions{Revisions: master, Author: "Jianfei"}, "d0eac37"},
+		{LogOptions{Revisions: master, Paths: []string{"data"}, Order: OrderTopo}, "c3ca898 0db8902 629bc57 8ac1880 49e1fdd 48a0b58 b1188d0"},
+		{LogOptions{Revisions: master, Paths: []string{"data"}, Order: OrderStrictDate}, "c3ca898 0db8902 629bc57 8ac1880 49e1fdd 48a0b58 b1188d0"},
+		{LogOptions{Revisions: master, Paths: []string{"data"}, Order: OrderAuthorDate}, "c3ca898 0db8902 629bc57 8ac1880 49e1fdd 48a0b58 b1188d0"},
+		{LogOptions{Revisions: master, Paths: []string{"hello"}, Order: OrderTopo}, "d0eac37"},
+		{LogOptions{Revisions: master, Paths: []string{"hello"}, Order: OrderStrictDate}, "d0eac37"},
+		{LogOptions{Revisions: master, Paths: []string{"hello"}, Order: OrderAuthorDate}, "d0eac37"},
+	}
+	for _, test := range tests {
+		l, err := r.Log(test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for e := l.Front(); e != nil; e = e.Next() {
+			s = append(s, e.Value.(*Commit).Id.String()[:7])
+		}
+		if got := strings.Join(s, " "); got != test.expected {
+			t.Errorf("unexpected log with %+v: %s", test.opts, got)
+		}
+	}
+}
+
+func TestLogIter(t *testing.T) {
+	r, err := OpenRepository("testdata/test.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []LogOptions{
+		{Revisions: []string{"master"}},
+		{Revisions: []string{"master"}, Order: OrderTopo, Reverse: true},
+	} {
+		l, err := r.Log(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := r.LogIter(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := l.Front()
+		for ; it.Next(); e = e.Next() {
+			if e == nil || it.Commit() != e.Value.(*Commit) {
+				t.Fatalf("iterator differs from log with %+v", opts)
+			}
+		}
+		if it.Err() != nil || e != nil {
+			t.Errorf("iterator ended early with %+v: %v", opts, it.Err())
+		}
+	}
+
+	// the walk only goes as far as the commits taken
+	rr, err := r.RevisionRange("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	visited := 0
+	it, err := rr.Iter(func(*Commit) (HistoryWalkerAction, error) {
+		visited++
+		return HWTakeAndFollow, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.Next()
+	it.Next()
+	if visited != 2 {
+		t.Errorf("walked %d commits for 2", visited)
+	}
+}
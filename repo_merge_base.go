@@ -0,0 +1,323 @@
+package git
+
+import (
+	"container/heap"
+)
+
+// flags of commits during walks painting them by reachability
+type commitFlags uint8
+
+const (
+	paintParent1 commitFlags = 1 << iota
+	paintParent2
+	paintStale
+	paintResult
+
+	// used by revision ranges
+	rangeSeen
+	rangeExcluded
+	rangeLeft
+	rangeRight
+)
+
+// commitQueue is a priority queue of commits, the newest first and
+// commits of the same time in the order they were pushed.
+type commitQueue struct {
+	items []commitQueueItem
+	seq   int
+
+	// order by author date instead of committer date
+	byAuthor bool
+	// order by the generation numbers first if set
+	generation func(*Commit) uint32
+}
+
+type commitQueueItem struct {
+	commit *Commit
+	seq    int
+}
+
+func (q *commitQueue) Len() int { return len(q.items) }
+
+func (q *commitQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.generation != nil {
+		if aGen, bGen := q.generation(a.commit), q.generation(b.commit); aGen != bGen {
+			return aGen > bGen
+		}
+	}
+	aWhen, bWhen := a.commit.Committer.When, b.commit.Committer.When
+	if q.byAuthor {
+		aWhen, bWhen = a.commit.Author.When, b.commit.Author.When
+	}
+	if !aWhen.Equal(bWhen) {
+		return aWhen.After(bWhen)
+	}
+	return a.seq < b.seq
+}
+
+func (q *commitQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *commitQueue) Push(x interface{}) {
+	q.items = append(q.items, commitQueueItem{x.(*Commit), q.seq})
+	q.seq++
+}
+
+func (q *commitQueue) Pop() interface{} {
+	item := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return item.commit
+}
+
+func (q *commitQueue) push(c *Commit) { heap.Push(q, c) }
+func (q *commitQueue) pop() *Commit   { return heap.Pop(q).(*Commit) }
+
+// commitPainter walks the history of two sides, marking the commits
+// reachable from either, like git's paint_down_to_common.
+type commitPainter struct {
+	flags map[sha1]commitFlags
+	queue commitQueue
+}
+
+func newCommitPainter() *commitPainter {
+	return &commitPainter{flags: make(map[sha1]commitFlags)}
+}
+
+func (p *commitPainter) mark(c *Commit, flags commitFlags) {
+	p.flags[c.Id] |= flags
+	p.queue.push(c)
+}
+
+// hasUnmarked reports whether the queue still has commits without flag.
+func (p *commitPainter) hasUnmarked(flag commitFlags) bool {
+	for _, item := range p.queue.items {
+		if p.flags[item.commit.Id]&flag == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// paint walks down from one and twos and returns the commits reachable
+// from both which are not reachable from another such commit, newest
+// first, though some may still be ancestors of others.
+func (p *commitPainter) paint(one *Commit, twos []*Commit) ([]*Commit, error) {
+	p.mark(one, paintParent1)
+	for _, c := range twos {
+		p.mark(c, paintParent2)
+	}
+
+	var result []*Commit
+	for p.hasUnmarked(paintStale) {
+		c := p.queue.pop()
+		flags := p.flags[c.Id] & (paintParent1 | paintParent2 | paintStale)
+		if flags == paintParent1|paintParent2 {
+			if p.flags[c.Id]&paintResult == 0 {
+				p.flags[c.Id] |= paintResult
+				result = append(result, c)
+			}
+			// the ancestors of a common commit are not the best ones
+			flags |= paintStale
+		}
+
+		for i := 0; i < c.ParentCount(); i++ {
+			parent, err := c.Parent(i)
+			if err != nil {
+				return nil, err
+			}
+			if p.flags[parent.Id]&flags == flags {
+				continue
+			}
+			p.mark(parent, flags)
+		}
+	}
+
+	// a result may only have been found common after it was queued
+	var fresh []*Commit
+	for _, c := range result {
+		if p.flags[c.Id]&paintStale == 0 {
+			fresh = append(fresh, c)
+		}
+	}
+	return fresh, nil
+}
+
+// MergeBase returns the best common ancestors of a and a merge of others,
+// like git merge-base --all. Usually there is one.
+func (repo *Repository) MergeBase(a string, others ...string) ([]*Commit, error) {
+	commits, err := repo.getCommits(append([]string{a}, others...))
+	if err != nil {
+		return nil, err
+	}
+	return repo.mergeBases(commits[0], commits[1:])
+}
+
+// MergeBaseOctopus returns the best common ancestors of all commits, like
+// git merge-base --octopus.
+func (repo *Repository) MergeBaseOctopus(ids ...string) ([]*Commit, error) {
+	commits, err := repo.getCommits(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Commit
+	for i, c := range commits {
+		if i == 0 {
+			result = []*Commit{c}
+			continue
+		}
+		var next []*Commit
+		for _, r := range result {
+			bases, err := repo.mergeBases(c, []*Commit{r})
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, bases...)
+		}
+		result = next
+	}
+	return repo.reduceCommits(result)
+}
+
+// IndependentCommits returns the commits which are not reachable from any
+// other of them, in their order, like git merge-base --independent.
+func (repo *Repository) IndependentCommits(ids ...string) ([]*Commit, error) {
+	commits, err := repo.getCommits(ids)
+	if err != nil {
+		return nil, err
+	}
+	return repo.reduceCommits(commits)
+}
+
+// IsAncestor reports whether the commit a is reachable from b, including b
+// itself.
+func (repo *Repository) IsAncestor(a, b string) (bool, error) {
+	commits, err := repo.getCommits([]string{a, b})
+	if err != nil {
+		return false, err
+	}
+	return repo.isAncestor(commits[0], commits[1])
+}
+
+func (repo *Repository) isAncestor(a, b *Commit) (bool, error) {
+	if a.Id == b.Id {
+		return true, nil
+	}
+	p := newCommitPainter()
+	if _, err := p.paint(a, []*Commit{b}); err != nil {
+		return false, err
+	}
+	return p.flags[a.Id]&paintParent2 != 0, nil
+}
+
+// AheadBehind counts the commits reachable from a but not from b, and the
+// other way around, like git rev-list --left-right --count a...b.
+func (repo *Repository) AheadBehind(a, b string) (ahead, behind int, err error) {
+	commits, err := repo.getCommits([]string{a, b})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	r := repo.newRevisionRange()
+	if err := r.addSymmetricCommits(commits[0], commits[1]); err != nil {
+		return 0, 0, err
+	}
+	l, err := r.Commits()
+	if err != nil {
+		return 0, 0, err
+	}
+	for e := l.Front(); e != nil; e = e.Next() {
+		switch r.Side(e.Value.(*Commit)) {
+		case SideLeft:
+			ahead++
+		case SideRight:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+func (repo *Repository) getCommits(ids []string) ([]*Commit, error) {
+	commits := make([]*Commit, len(ids))
+	for i, idStr := range ids {
+		id, err := NewIdFromString(idStr)
+		if err != nil {
+			return nil, err
+		}
+		if commits[i], err = repo.getCommit(id); err != nil {
+			return nil, err
+		}
+	}
+	return commits, nil
+}
+
+// mergeBases returns the best common ancestors of one and a merge of twos.
+func (repo *Repository) mergeBases(one *Commit, twos []*Commit) ([]*Commit, error) {
+	for _, c := range twos {
+		if c.Id == one.Id {
+			return []*Commit{one}, nil
+		}
+	}
+
+	result, err := newCommitPainter().paint(one, twos)
+	if err != nil {
+		return nil, err
+	}
+	return repo.removeRedundant(result)
+}
+
+// reduceCommits drops duplicates and commits reachable from the others.
+func (repo *Repository) reduceCommits(commits []*Commit) ([]*Commit, error) {
+	seen := make(map[sha1]bool)
+	var unique []*Commit
+	for _, c := range commits {
+		if !seen[c.Id] {
+			seen[c.Id] = true
+			unique = append(unique, c)
+		}
+	}
+	return repo.removeRedundant(unique)
+}
+
+// removeRedundant drops the commits which are reachable from another one.
+func (repo *Repository) removeRedundant(commits []*Commit) ([]*Commit, error) {
+	if len(commits) < 2 {
+		return commits, nil
+	}
+
+	redundant := make([]bool, len(commits))
+	for i, c := range commits {
+		if redundant[i] {
+			continue
+		}
+		var others []*Commit
+		var index []int
+		for j, o := range commits {
+			if j != i && !redundant[j] {
+				others = append(others, o)
+				index = append(index, j)
+			}
+		}
+
+		p := newCommitPainter()
+		if _, err := p.paint(c, others); err != nil {
+			return nil, err
+		}
+		if p.flags[c.Id]&paintParent2 != 0 {
+			redundant[i] = true
+		}
+		for k, o := range others {
+			if p.flags[o.Id]&paintParent1 != 0 {
+				redundant[index[k]] = true
+			}
+		}
+	}
+
+	var result []*Commit
+	for i, c := range commits {
+		if !redundant[i] {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
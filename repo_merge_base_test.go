@@ -0,0 +1,86 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeBase(t *testing.T) {
+	r, err := OpenRepository("testdata/test.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(commits []*Commit) string {
+		var s []string
+		for _, c := range commits {
+			s = append(s, c.Id.String()[:7])
+		}
+		return strings.Join(s, " ")
+	}
+
+	tests := []struct {
+		a, b          string
+		base          string
+		ahead, behind int
+	}{
+		{"629bc57636d5543bce1fa104f841f5d36784ca82", "8d7869631c72d85780d39ecbe0ae8e50a9997f09", "d0eac37", 5, 1},
+		{"ee1fe129bc618ee9a4f59430da2ffcdee8918ef4", "0db89028be407852110616025d1459e19050196f", "48a0b58", 1, 3},
+		{"c08a875c2363d382d95f021c6de76f0b40366689", "ee1fe129bc618ee9a4f59430da2ffcdee8918ef4", "48a0b58", 2, 1},
+	}
+	for _, test := range tests {
+		bases, err := r.MergeBase(test.a, test.b)
+		if err != nil || ids(bases) != test.base {
+			t.Errorf("unexpected merge base of %.7s and %.7s: %s %v", test.a, test.b, ids(bases), err)
+		}
+		ahead, behind, err := r.AheadBehind(test.a, test.b)
+		if err != nil || ahead != test.ahead || behind != test.behind {
+			t.Errorf("unexpected ahead/behind of %.7s and %.7s: %d %d %v", test.a, test.b, ahead, behind, err)
+		}
+	}
+
+	main5 := "629bc57636d5543bce1fa104f841f5d36784ca82"
+	bad := "ee1fe129bc618ee9a4f59430da2ffcdee8918ef4"
+	conflict := "0db89028be407852110616025d1459e19050196f"
+	main1 := "b1188d0fb7fe7dbc5f72cb6c2f8911b8874c5661"
+	if bases, err := r.MergeBaseOctopus(main5, bad, conflict); err != nil || ids(bases) != "48a0b58" {
+		t.Errorf("unexpected octopus merge base: %s %v", ids(bases), err)
+	}
+	if commits, err := r.IndependentCommits(main5, bad, conflict, main1); err != nil || ids(commits) != "629bc57 ee1fe12 0db8902" {
+		t.Errorf("unexpected independent commits: %s %v", ids(commits), err)
+	}
+	if ok, err := r.IsAncestor(main1, main5); err != nil || !ok {
+		t.Errorf("%.7s is an ancestor of %.7s: %v", main1, main5, err)
+	}
+	if ok, err := r.IsAncestor(main5, main1); err != nil || ok {
+		t.Errorf("%.7s is not an ancestor of %.7s: %v", main5, main1, err)
+	}
+}
+
+func TestAheadBehindSameTime(t *testing.T) {
+	r := copyTestRepo(t)
+	tree := storeTestTree(t, r, nil)
+
+	// all commits are made within the same second
+	parent := storeTestCommit(t, r, tree, 0)
+	child := storeTestCommit(t, r, tree, 0, parent.Id)
+	other := storeTestTree(t, r, []*TreeEntry{{name: "empty", mode: ModeTree, Id: tree}})
+	side := storeTestCommit(t, r, other, 0, parent.Id)
+	merge := storeTestCommit(t, r, tree, 0, side.Id, child.Id)
+
+	tests := []struct {
+		a, b          *Commit
+		ahead, behind int
+	}{
+		{parent, child, 0, 1},
+		{child, parent, 1, 0},
+		{child, side, 1, 1},
+		{parent, merge, 0, 3},
+		{merge, child, 2, 0},
+	}
+	for _, test := range tests {
+		ahead, behind, err := r.AheadBehind(test.a.Id.String(), test.b.Id.String())
+		if err != nil || ahead != test.ahead || behind != test.behind {
+			t.Errorf("unexpected ahead/behind of %.7s and %.7s: %d %d %v", test.a.Id, test.b.Id, ahead, behind, err)
+		}
+	}
+}
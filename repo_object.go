@@ -51,7 +51,7 @@ func (repo *Repository) HaveObject(idStr string) (found, packed bool, err error)
 
 func (repo *Repository) haveObject(id sha1) (found, packed bool, err error) {
 	sha1 := id.String()
-	_, err = os.Stat(filepathFromSHA1(repo.Path, sha1))
+	_, err = os.Stat(filepathFromSHA1(repo.CommonDir, sha1))
 	if err == nil {
 		found = true
 		return
@@ -80,7 +80,7 @@ func (repo *Repository) getRawObject(id sha1, metaOnly bool) (ObjectType, int64,
 		return 0, 0, nil, fmt.Errorf("Object not found %s", sha1)
 
 	case !packed:
-		return readObjectFile(filepathFromSHA1(repo.Path, sha1), metaOnly)
+		return readObjectFile(filepathFromSHA1(repo.CommonDir, sha1), metaOnly)
 	}
 
 	pack, offset := repo.findObjectPack(id)
@@ -0,0 +1,302 @@
+package git
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CommitSide tells from which end of a symmetric range A...B a commit is
+// reachable.
+type CommitSide int
+
+const (
+	SideNone CommitSide = iota
+	SideLeft
+	SideRight
+)
+
+// rangeSlop is how many commits are walked after all queued ones are
+// excluded and older than the range, in case of clock skew.
+const rangeSlop = 5
+
+// RevisionRange is the set of commits reachable from some included commits
+// but from none of the excluded ones.
+type RevisionRange struct {
+	repo *Repository
+	tips []*Commit
+	init map[sha1]commitFlags
+
+	// flags of the commits after limiting the range
+	flags map[sha1]commitFlags
+}
+
+// RevisionRange returns the range given by revisions like the arguments of
+// git rev-list: "B", "^A", "A..B", "A...B" and "--not", which inverts the
+// meaning of "^" for the following arguments. A revision is a commit id, a
+// reference or a reflog expression, an empty one in "A.." means HEAD.
+func (repo *Repository) RevisionRange(args ...string) (*RevisionRange, error) {
+	r := repo.newRevisionRange()
+	not := false
+	for _, arg := range args {
+		if arg == "--not" {
+			not = !not
+			continue
+		}
+
+		var err error
+		if i := strings.Index(arg, "..."); i >= 0 {
+			err = r.addSymmetric(arg[:i], arg[i+3:], not)
+		} else if i := strings.Index(arg, ".."); i >= 0 {
+			if err = r.addRevision(arg[:i], !not); err == nil {
+				err = r.addRevision(arg[i+2:], not)
+			}
+		} else if strings.HasPrefix(arg, "^") {
+			err = r.addRevision(arg[1:], !not)
+		} else {
+			err = r.addRevision(arg, not)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (repo *Repository) newRevisionRange() *RevisionRange {
+	return &RevisionRange{repo: repo, init: make(map[sha1]commitFlags)}
+}
+
+func (r *RevisionRange) add(c *Commit, flags commitFlags) {
+	if _, ok := r.init[c.Id]; !ok {
+		r.tips = append(r.tips, c)
+	}
+	r.init[c.Id] |= flags | rangeSeen
+	r.flags = nil
+}
+
+func (r *RevisionRange) addRevision(name string, exclude bool) error {
+	c, err := r.repo.resolveRevision(name)
+	if err != nil {
+		return err
+	}
+	if exclude {
+		r.add(c, rangeExcluded)
+	} else {
+		r.add(c, 0)
+	}
+	return nil
+}
+
+// addSymmetric adds the commits reachable from either a or b but not both.
+func (r *RevisionRange) addSymmetric(a, b string, not bool) error {
+	left, err := r.repo.resolveRevision(a)
+	if err != nil {
+		return err
+	}
+	right, err := r.repo.resolveRevision(b)
+	if err != nil {
+		return err
+	}
+	if not {
+		r.add(left, rangeExcluded)
+		r.add(right, rangeExcluded)
+		return nil
+	}
+	return r.addSymmetricCommits(left, right)
+}
+
+// addSymmetricCommits adds the commits reachable from either left or right
+// but not both, marked with their side.
+func (r *RevisionRange) addSymmetricCommits(left, right *Commit) error {
+	bases, err := r.repo.mergeBases(left, []*Commit{right})
+	if err != nil {
+		return err
+	}
+	r.add(left, rangeLeft)
+	r.add(right, rangeRight)
+	for _, c := range bases {
+		r.add(c, rangeExcluded)
+	}
+	return nil
+}
+
+// resolveRevision returns the commit a revision names.
+func (repo *Repository) resolveRevision(name string) (*Commit, error) {
+	if len(name) == 0 {
+		name = "HEAD"
+	}
+
+	id, err := NewIdFromString(name)
+	if strings.Contains(name, "@{") {
+		var idStr string
+		if idStr, err = repo.ResolveReflog(name); err != nil {
+			return nil, err
+		}
+		id, err = NewIdFromString(idStr)
+	} else if err != nil {
+		var full string
+		if full, err = repo.expandRefName(name); err != nil {
+			return nil, err
+		}
+		var ref *Reference
+		if ref, err = repo.ResolveRef(full); err == nil {
+			id = ref.Id
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := repo.peelToCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, fmt.Errorf("%s is not a commit", name)
+	}
+	return c, nil
+}
+
+// limit marks the commits reachable from excluded ones, like git's
+// limit_list. The walk stops once only excluded commits are left, none of
+// them as new as the last commit of the range.
+func (r *RevisionRange) limit() error {
+	if r.flags != nil {
+		return nil
+	}
+	var all commitFlags
+	for _, flags := range r.init {
+		all |= flags
+	}
+	if all&(rangeExcluded|rangeLeft|rangeRight) == 0 {
+		// everything reachable is in the range
+		r.flags = make(map[sha1]commitFlags)
+		return nil
+	}
+
+	p := newCommitPainter()
+	for _, c := range r.tips {
+		p.mark(c, r.init[c.Id])
+	}
+
+	// the date of the last commit in the range
+	var date time.Time
+	slop := rangeSlop
+	for p.queue.Len() > 0 {
+		c := p.queue.pop()
+		flags := p.flags[c.Id]
+		for i := 0; i < c.ParentCount(); i++ {
+			parent, err := c.Parent(i)
+			if err != nil {
+				return err
+			}
+			if p.flags[parent.Id]&flags == flags {
+				continue
+			}
+			p.mark(parent, flags)
+		}
+
+		if flags&rangeExcluded == 0 {
+			date = c.Committer.When
+			continue
+		}
+		// a queued commit as new as the range may still be excluded,
+		// like git's still_interesting
+		if p.queue.Len() == 0 {
+			break
+		}
+		next := p.queue.items[0].commit
+		if !date.IsZero() && !date.After(next.Committer.When) || p.hasUnmarked(rangeExcluded) {
+			slop = rangeSlop
+		} else if slop--; slop == 0 {
+			break
+		}
+	}
+	r.flags = p.flags
+	return nil
+}
+
+// Walk walks the commits of the range, newest first, like the other
+// history walks. Commits outside of the range are not passed to callback.
+func (r *RevisionRange) Walk(callback CommitWalkCallback) (*list.List, error) {
+	w, err := r.walker(callback, nopComparator, walkOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return collectHistory(w)
+}
+
+// Iter is Walk, returning the commits while walking.
+func (r *RevisionRange) Iter(callback CommitWalkCallback) (*CommitIter, error) {
+	w, err := r.walker(callback, nopComparator, walkOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &CommitIter{w: w}, nil
+}
+
+func (r *RevisionRange) walker(callback CommitWalkCallback, eq CommitComparator, opts walkOptions) (historyWalker, error) {
+	if err := r.limit(); err != nil {
+		return nil, err
+	}
+	if callback == nil {
+		callback = nopCallback
+	}
+
+	var roots []*Commit
+	for _, c := range r.tips {
+		if r.init[c.Id]&rangeExcluded == 0 {
+			roots = append(roots, c)
+		}
+	}
+	// never skip over excluded commits
+	rangeEq := func(current, parent *Commit) bool {
+		return r.flags[current.Id]&rangeExcluded == 0 &&
+			r.flags[parent.Id]&rangeExcluded == 0 && eq(current, parent)
+	}
+	filter := func(c *Commit) (HistoryWalkerAction, error) {
+		if r.flags[c.Id]&rangeExcluded != 0 {
+			return HWDrop, nil
+		}
+		return callback(c)
+	}
+	return newHistoryWalker(roots, filter, rangeEq, opts)
+}
+
+// Commits returns the commits of the range, newest first.
+func (r *RevisionRange) Commits() (*list.List, error) {
+	return r.Walk(nil)
+}
+
+// Contains reports whether the commit is in the range.
+func (r *RevisionRange) Contains(c *Commit) (bool, error) {
+	if err := r.limit(); err != nil {
+		return false, err
+	}
+	if len(r.flags) == 0 {
+		for _, tip := range r.tips {
+			if ok, err := r.repo.isAncestor(c, tip); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	// commits not reached while limiting are below excluded ones
+	flags := r.flags[c.Id]
+	return flags&rangeSeen != 0 && flags&rangeExcluded == 0, nil
+}
+
+// Side returns the end of a symmetric range the commit is reachable from,
+// once the range has been walked.
+func (r *RevisionRange) Side(c *Commit) CommitSide {
+	flags := r.flags[c.Id]
+	switch {
+	case flags&rangeLeft != 0:
+		return SideLeft
+	case flags&rangeRight != 0:
+		return SideRight
+	}
+	return SideNone
+}
@@ -0,0 +1,82 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRevisionRange(t *testing.T) {
+	r, err := OpenRepository("testdata/test.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"main-bad..main-conflict"}, "0db8902 8ac1880 49e1fdd"},
+		{[]string{"main-bad...main-conflict"}, "<ee1fe12 >0db8902 >8ac1880 >49e1fdd"},
+		{[]string{"master", "--not", "main-alternate", "^main-bad"}, "c3ca898 eff6dbc ee1fe12 0db8902 11d6aed d6360cd 8d78696 629bc57 8ac1880"},
+		{[]string{"main-conflict..main-conflict"}, ""},
+	}
+	for _, test := range tests {
+		rr, err := r.RevisionRange(test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := rr.Commits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for e := l.Front(); e != nil; e = e.Next() {
+			c := e.Value.(*Commit)
+			side := map[CommitSide]string{SideLeft: "<", SideRight: ">"}[rr.Side(c)]
+			s = append(s, side+c.Id.String()[:7])
+		}
+		if got := strings.Join(s, " "); got != test.expected {
+			t.Errorf("unexpected commits of %v: %s", test.args, got)
+		}
+	}
+
+	// the base commit is not on the first-parent chain of master
+	last, _ := r.GetCommit("c3ca89834257974d7375ac7915ed58d01afe7d4b")
+	before, _ := r.GetCommit("8d7869631c72d85780d39ecbe0ae8e50a9997f09")
+	l, err := r.CommitsBetween(last, before)
+	if err != nil || l.Len() != 12 {
+		t.Errorf("unexpected commits between: %v %v", l.Len(), err)
+	}
+}
+
+func TestRevisionRangeSameTime(t *testing.T) {
+	r := copyTestRepo(t)
+	tree := storeTestTree(t, r, nil)
+
+	// a chain of commits made within the same second
+	chain := []*Commit{storeTestCommit(t, r, tree, 0)}
+	for i := 1; i < 11; i++ {
+		chain = append(chain, storeTestCommit(t, r, tree, 0, chain[i-1].Id))
+	}
+	first, tip := chain[0].Id.String(), chain[10].Id.String()
+
+	rr, err := r.RevisionRange(first, "^"+tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := rr.Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 0 {
+		t.Errorf("expected no commits, got %d", l.Len())
+	}
+
+	rr, err = r.RevisionRange(chain[3].Id.String() + ".." + tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err = rr.Commits(); err != nil || l.Len() != 7 {
+		t.Errorf("expected 7 commits, got %d: %v", l.Len(), err)
+	}
+}
@@ -0,0 +1,141 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ref returns the reference called name without following symbolic
+// references.
+func (repo *Repository) Ref(name string) (*Reference, error) {
+	return repo.refs.Ref(name)
+}
+
+// ResolveRef follows symbolic references starting at name and returns the
+// reference which finally points to an object.
+func (repo *Repository) ResolveRef(name string) (*Reference, error) {
+	return resolveRef(repo.refs, name)
+}
+
+// Refs returns all references whose name starts with prefix, loose and
+// packed ones, sorted by name.
+func (repo *Repository) Refs(prefix string) ([]*Reference, error) {
+	return repo.refs.Refs(prefix)
+}
+
+// Head returns the reference HEAD resolves to. For a detached HEAD this is
+// HEAD itself.
+func (repo *Repository) Head() (*Reference, error) {
+	return repo.ResolveRef("HEAD")
+}
+
+// SetHead points HEAD at the reference refName, e.g. "refs/heads/master".
+// The reference does not need to exist yet.
+func (repo *Repository) SetHead(refName string) error {
+	if !strings.HasPrefix(refName, "refs/") {
+		return fmt.Errorf("invalid HEAD target %q", refName)
+	}
+	return repo.setHead(repo.refs, &RefUpdate{Name: "HEAD", Target: refName}, "")
+}
+
+// SetHeadDetached points HEAD directly at the commit idStr.
+func (repo *Repository) SetHeadDetached(idStr string) error {
+	id, err := NewIdFromString(idStr)
+	if err != nil {
+		return err
+	}
+	return repo.setHead(repo.refs, &RefUpdate{Name: "HEAD", New: id}, "")
+}
+
+// setHead applies the update of the HEAD in store and logs it with
+// message, which defaults to a checkout message like git's.
+func (repo *Repository) setHead(store RefStore, u *RefUpdate, message string) error {
+	if len(message) == 0 {
+		from := "(nothing)"
+		if head, err := store.Ref("HEAD"); err == nil {
+			from = checkoutName(head)
+		}
+		message = "checkout: moving from " + from + " to " +
+			checkoutName(&Reference{Id: u.New, Target: u.Target})
+	}
+
+	u.Committer = repo.signature()
+	u.Message = message
+	return store.Update(u)
+}
+
+// headStores returns the reference stores of all work trees, which differ
+// in their HEAD. Only the own store is returned for namespaced views and
+// reftables, whose linked work trees are not supported.
+func (repo *Repository) headStores() []RefStore {
+	stores := []RefStore{repo.refs}
+	if _, ok := repo.refs.(*fileRefStore); !ok {
+		return stores
+	}
+
+	gitDirs, _ := filepath.Glob(filepath.Join(repo.CommonDir, "worktrees", "*"))
+	gitDirs = append(gitDirs, repo.CommonDir)
+	for _, gitDir := range gitDirs {
+		if filepath.Clean(gitDir) != filepath.Clean(repo.Path) && isFile(filepath.Join(gitDir, "HEAD")) {
+			stores = append(stores, newFileRefStore(gitDir, repo.CommonDir))
+		}
+	}
+	return stores
+}
+
+// checkoutName returns how checkout messages name what HEAD points to:
+// the branch name or the commit id.
+func checkoutName(ref *Reference) string {
+	if ref.IsSymbolic() {
+		return strings.TrimPrefix(ref.Target, "refs/heads/")
+	}
+	return ref.Id.String()
+}
+
+// signature returns the identity recorded in reference logs. The
+// environment takes precedence over user.name and user.email.
+func (repo *Repository) signature() *Signature {
+	sig := defaultSignature()
+	cfg, err := repo.Config()
+	if err != nil {
+		return sig
+	}
+	if name, err := cfg.Get("user.name"); err == nil && len(os.Getenv("GIT_COMMITTER_NAME")) == 0 {
+		sig.Name = name
+	}
+	if email, err := cfg.Get("user.email"); err == nil && len(os.Getenv("GIT_COMMITTER_EMAIL")) == 0 {
+		sig.Email = email
+	}
+	return sig
+}
+
+// expandRefName finds the reference a short name like "master" refers to,
+// trying the same locations in the same order as git rev-parse.
+func (repo *Repository) expandRefName(name string) (string, error) {
+	for _, format := range []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"} {
+		full := fmt.Sprintf(format, name)
+		if _, err := repo.refs.Ref(full); err == nil {
+			return full, nil
+		} else if err != ErrRefNotExist {
+			return "", err
+		}
+	}
+	return "", ErrRefNotExist
+}
+
+// refNames returns the names of all references below prefix with prefix
+// stripped off.
+func (repo *Repository) refNames(prefix string) ([]string, error) {
+	refs, err := repo.refs.Refs(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = strings.TrimPrefix(ref.Name, prefix)
+	}
+	return names, nil
+}
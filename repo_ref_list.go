@@ -0,0 +1,169 @@
+package git
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type RefSortOrder int
+
+const (
+	// sort by reference name
+	RefSortByName RefSortOrder = iota
+	// sort by committer date of the commit the reference points to,
+	// newest first. Annotated tags are peeled.
+	RefSortByCommitterDate
+)
+
+// RefListOptions selects and orders references for ListRefs.
+type RefListOptions struct {
+	// Patterns select references like git for-each-ref does: a pattern
+	// matches a reference if it is a prefix ending at a "/" boundary,
+	// e.g. "refs/remotes/origin", or a glob, e.g. "refs/pull/*/head". No
+	// patterns select every reference.
+	Patterns []string
+
+	Sort    RefSortOrder
+	Reverse bool
+
+	// Limit the number of references returned, 0 for no limit.
+	Count int
+}
+
+// ListedRef is a reference found by ListRefs.
+type ListedRef struct {
+	*Reference
+
+	// Resolved is the id the reference points to after following
+	// symbolic references. It is zero for dangling symbolic references.
+	Resolved sha1
+}
+
+// ListRefs returns the references, loose and packed, matching the given
+// options, including remote-tracking branches, notes and any custom
+// namespace below refs/.
+func (repo *Repository) ListRefs(opts RefListOptions) ([]*ListedRef, error) {
+	refs, err := repo.matchingRefs(opts.Patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]*ListedRef, len(refs))
+	for i, ref := range refs {
+		listed[i] = &ListedRef{Reference: ref, Resolved: ref.Id}
+		if ref.IsSymbolic() {
+			resolved, err := repo.ResolveRef(ref.Target)
+			if err == nil {
+				listed[i].Resolved = resolved.Id
+			} else if err != ErrRefNotExist {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.Sort == RefSortByCommitterDate {
+		if err := repo.sortRefsByDate(listed); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Reverse {
+		for i, j := 0, len(listed)-1; i < j; i, j = i+1, j-1 {
+			listed[i], listed[j] = listed[j], listed[i]
+		}
+	}
+
+	if opts.Count > 0 && len(listed) > opts.Count {
+		listed = listed[:opts.Count]
+	}
+	return listed, nil
+}
+
+// matchingRefs returns the references matching any of patterns sorted by
+// name.
+func (repo *Repository) matchingRefs(patterns []string) ([]*Reference, error) {
+	if len(patterns) == 0 {
+		return repo.refs.Refs("")
+	}
+
+	seen := make(map[string]bool)
+	var matched []*Reference
+	for _, pattern := range patterns {
+		// only read the part of the namespace the pattern can match
+		prefix := pattern
+		if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+			prefix = pattern[:i]
+		}
+
+		refs, err := repo.refs.Refs(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			if !seen[ref.Name] && matchRefPattern(pattern, ref.Name) {
+				seen[ref.Name] = true
+				matched = append(matched, ref)
+			}
+		}
+	}
+
+	sortRefs(matched)
+	return matched, nil
+}
+
+func matchRefPattern(pattern, name string) bool {
+	if strings.HasPrefix(name, pattern) {
+		rest := name[len(pattern):]
+		return len(rest) == 0 || rest[0] == '/' || strings.HasSuffix(pattern, "/")
+	}
+
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+func (repo *Repository) sortRefsByDate(refs []*ListedRef) error {
+	dates := make(map[string]time.Time, len(refs))
+	for _, ref := range refs {
+		if ref.Resolved.IsZero() {
+			continue
+		}
+		commit, err := repo.peelToCommit(ref.Resolved)
+		if err != nil {
+			return err
+		}
+		if commit != nil {
+			dates[ref.Name] = commit.Committer.When
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		return dates[refs[i].Name].After(dates[refs[j].Name])
+	})
+	return nil
+}
+
+// peelToCommit follows annotated tags starting at id and returns the
+// commit they point to, nil if they point to another kind of object.
+func (repo *Repository) peelToCommit(id sha1) (*Commit, error) {
+	for {
+		typ, err := repo.objectType(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case ObjectCommit:
+			return repo.getCommit(id)
+		case ObjectTag:
+			tag, err := repo.getTag(id)
+			if err != nil {
+				return nil, err
+			}
+			id = tag.Object
+		default:
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// copyTestRepo copies testdata/test.git into a temporary directory so tests
+// can modify it.
+func copyTestRepo(t *testing.T) *Repository {
+	dst := filepath.Join(t.TempDir(), "test.git")
+	err := filepath.Walk("testdata/test.git", func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel("testdata/test.git", p)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenRepository(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestHead(t *testing.T) {
+	r := copyTestRepo(t)
+
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name != "refs/heads/master" || head.Id.String() != "c3ca89834257974d7375ac7915ed58d01afe7d4b" {
+		t.Fatalf("unexpected HEAD %+v", head)
+	}
+
+	if err := r.SetHead("refs/heads/main-bad"); err != nil {
+		t.Fatal(err)
+	}
+	head, err = r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name != "refs/heads/main-bad" {
+		t.Fatalf("HEAD not updated: %+v", head)
+	}
+
+	if err := r.SetHeadDetached("c3ca89834257974d7375ac7915ed58d01afe7d4b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetHead("refs/heads/main-alternate"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenameBranch("main-alternate", "alternate"); err != nil {
+		t.Fatal(err)
+	}
+
+	// every change of HEAD is logged, newest first
+	entries, err := r.Reflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{
+		"Branch: renamed refs/heads/main-alternate to refs/heads/alternate",
+		"checkout: moving from c3ca89834257974d7375ac7915ed58d01afe7d4b to main-alternate",
+		"checkout: moving from main-bad to c3ca89834257974d7375ac7915ed58d01afe7d4b",
+		"checkout: moving from master to main-bad",
+	}
+	if len(entries) != len(messages) {
+		t.Fatalf("expected %d entries, got %d", len(messages), len(entries))
+	}
+	for i, e := range entries {
+		if e.Message != messages[i] {
+			t.Errorf("entry %d: unexpected message %q", i, e.Message)
+		}
+	}
+	if e := entries[2]; e.Old.String() != "ee1fe129bc618ee9a4f59430da2ffcdee8918ef4" ||
+		e.New.String() != "c3ca89834257974d7375ac7915ed58d01afe7d4b" {
+		t.Errorf("unexpected ids in %+v", e)
+	}
+}
+
+func TestPackedAndLooseRefs(t *testing.T) {
+	r := copyTestRepo(t)
+
+	packed := "c08a875c2363d382d95f021c6de76f0b40366689 refs/heads/packed\n" +
+		"ee1fe129bc618ee9a4f59430da2ffcdee8918ef4 refs/heads/master\n"
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.IsBranchExist("packed") {
+		t.Fatal("packed branch not found")
+	}
+
+	branches, err := r.GetBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 6 {
+		t.Fatalf("expected 6 branches, got %v", branches)
+	}
+
+	// the loose ref shadows the packed one
+	id, err := r.GetCommitIdOfBranch("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "c3ca89834257974d7375ac7915ed58d01afe7d4b" {
+		t.Fatalf("packed ref shadowed loose ref: %s", id)
+	}
+}
+
+func TestSymbolicRefLoop(t *testing.T) {
+	r := copyTestRepo(t)
+
+	r.refs.SetRef(&Reference{Name: "refs/heads/a", Target: "refs/heads/b"})
+	r.refs.SetRef(&Reference{Name: "refs/heads/b", Target: "refs/heads/a"})
+
+	if _, err := r.ResolveRef("refs/heads/a"); err != ErrRefLoop {
+		t.Fatalf("expected ErrRefLoop, got %v", err)
+	}
+}
+
+func TestPackedRefsLookup(t *testing.T) {
+	data := []byte("# pack-refs with: peeled fully-peeled sorted \n" +
+		"c08a875c2363d382d95f021c6de76f0b40366689 refs/heads/main-old\n" +
+		"ee1fe129bc618ee9a4f59430da2ffcdee8918ef4 refs/tags/v1\n" +
+		"^0db89028be407852110616025d1459e19050196f\n" +
+		"c3ca89834257974d7375ac7915ed58d01afe7d4b refs/tags/v2\n")
+
+	for _, name := range []string{"refs/heads/main-old", "refs/tags/v1", "refs/tags/v2"} {
+		p, err := parsePackedRefsHeader(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := p.ref(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref == nil || ref.Name != name {
+			t.Fatalf("lookup of %s returned %+v", name, ref)
+		}
+		if (name == "refs/tags/v1") != ref.IsPeeled() {
+			t.Fatalf("wrong peeled id for %s: %s", name, ref.Peeled)
+		}
+	}
+
+	p, _ := parsePackedRefsHeader(data)
+	if ref, err := p.ref("refs/heads/main"); ref != nil || err != nil {
+		t.Fatalf("prefix lookup matched %+v, %v", ref, err)
+	}
+}
+
+func TestRefTransaction(t *testing.T) {
+	r := copyTestRepo(t)
+
+	master, _ := NewIdFromString("c3ca89834257974d7375ac7915ed58d01afe7d4b")
+	bad, _ := NewIdFromString("ee1fe129bc618ee9a4f59430da2ffcdee8918ef4")
+	packedId, _ := NewIdFromString("c08a875c2363d382d95f021c6de76f0b40366689")
+
+	// the second update has a stale old value, so nothing may change
+	err := r.NewRefTransaction().
+		Create("refs/heads/new", master).
+		Update("refs/heads/main-bad", master, master).
+		Commit()
+	if err != ErrRefChanged {
+		t.Fatalf("expected ErrRefChanged, got %v", err)
+	}
+	if r.IsBranchExist("new") {
+		t.Fatal("transaction was applied partially")
+	}
+
+	err = r.NewRefTransaction().
+		Create("refs/heads/new", master).
+		Update("refs/heads/main-bad", master, bad).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := r.GetCommitIdOfBranch("main-bad"); id != master.String() {
+		t.Fatalf("main-bad not updated: %s", id)
+	}
+
+	// a left over lock file blocks updates
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "refs/heads/new.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.NewRefTransaction().DeleteIf("refs/heads/new", master).Commit(); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	if err := r.NewRefTransaction().Create("refs/heads/new/sub", master).Commit(); err == nil {
+		t.Fatal("created reference below existing reference")
+	}
+
+	// the directory in the way of "blocked" makes the last update fail
+	// after the others were written, which has to be undone
+	packed := "c08a875c2363d382d95f021c6de76f0b40366689 refs/heads/packed\n"
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(r.Path, "refs/heads/blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = r.NewRefTransaction().
+		DeleteIf("refs/heads/packed", packedId).
+		Update("refs/heads/main-bad", bad, master).
+		Create("refs/heads/blocked", master).
+		Commit()
+	if err == nil {
+		t.Fatal("transaction with blocked reference succeeded")
+	}
+	if !r.IsBranchExist("packed") {
+		t.Fatal("packed reference was deleted by failed transaction")
+	}
+	if id, _ := r.GetCommitIdOfBranch("main-bad"); id != master.String() {
+		t.Fatalf("main-bad changed by failed transaction: %s", id)
+	}
+
+	if err := r.NewRefTransaction().Delete("refs/heads/packed").Delete("refs/heads/main-bad").Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if r.IsBranchExist("packed") || r.IsBranchExist("main-bad") {
+		t.Fatal("references not deleted")
+	}
+}
+
+func TestDeleteAndRenameBranch(t *testing.T) {
+	r := copyTestRepo(t)
+
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		"c08a875c2363d382d95f021c6de76f0b40366689 refs/heads/feature/packed\n" +
+		"c3ca89834257974d7375ac7915ed58d01afe7d4b refs/tags/v1\n"
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.DeleteBranch("master"); err != ErrBranchIsHead {
+		t.Fatalf("expected ErrBranchIsHead, got %v", err)
+	}
+
+	if err := r.DeleteBranch("feature/packed"); err != nil {
+		t.Fatal(err)
+	}
+	if r.IsBranchExist("feature/packed") {
+		t.Fatal("packed branch still exists")
+	}
+	if err := r.DeleteTag("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if r.IsTagExist("v1") {
+		t.Fatal("packed tag still exists")
+	}
+
+	if err := r.RenameBranch("master", "feature/main"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name != "refs/heads/feature/main" || head.Id.String() != "c3ca89834257974d7375ac7915ed58d01afe7d4b" {
+		t.Fatalf("HEAD did not follow rename: %+v", head)
+	}
+
+	if err := r.RenameBranch("feature/main", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.Path, "refs/heads/feature")); !os.IsNotExist(err) {
+		t.Fatal("empty directory was not removed")
+	}
+
+	// the new name takes the place of the old one as a directory
+	if err := r.CreateBranch("zz", "c3ca89834257974d7375ac7915ed58d01afe7d4b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenameBranch("zz", "zz/sub"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := r.Reflog("refs/heads/zz/sub")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("log did not move: %v, %v", entries, err)
+	}
+
+	// a failed rename leaves everything as it was
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "refs/heads/yy.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenameBranch("zz/sub", "yy"); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if !r.IsBranchExist("zz/sub") {
+		t.Fatal("failed rename removed the branch")
+	}
+	if entries, err := r.Reflog("refs/heads/zz/sub"); err != nil || len(entries) != 2 {
+		t.Fatalf("failed rename lost the log: %v, %v", entries, err)
+	}
+	if stray, _ := filepath.Glob(filepath.Join(r.Path, "logs", "tmp-*")); len(stray) != 0 {
+		t.Fatalf("failed rename left files behind: %v", stray)
+	}
+}
+
+func TestReflog(t *testing.T) {
+	r := copyTestRepo(t)
+
+	master, _ := NewIdFromString("c3ca89834257974d7375ac7915ed58d01afe7d4b")
+	bad, _ := NewIdFromString("ee1fe129bc618ee9a4f59430da2ffcdee8918ef4")
+
+	if err := r.CreateBranch("logged", bad.String()); err != nil {
+		t.Fatal(err)
+	}
+	tx := r.NewRefTransaction()
+	tx.Committer = &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1453752573, 0).In(time.FixedZone("", 3*3600))}
+	tx.Message = "reset: moving to master"
+	if err := tx.Update("refs/heads/logged", master, bad).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenameBranch("logged", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := r.Reflog("refs/heads/renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if e := entries[1]; e.Old != bad || e.New != master || e.Message != "reset: moving to master" ||
+		e.Committer.When.Format("-0700") != "+0300" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+
+	id, err := r.ResolveReflog("renamed@{2}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != bad.String() {
+		t.Fatalf("renamed@{2} is %s", id)
+	}
+
+	id, err = r.ResolveReflog("renamed@{2016-01-25 21:00:00 +0000}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != master.String() {
+		t.Fatalf("renamed@{date} is %s", id)
+	}
+}
+
+func TestListRefs(t *testing.T) {
+	r := copyTestRepo(t)
+
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		"8d7869631c72d85780d39ecbe0ae8e50a9997f09 refs/pull/1/head\n" +
+		"c08a875c2363d382d95f021c6de76f0b40366689 refs/remotes/origin/main\n"
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.refs.SetRef(&Reference{Name: "refs/remotes/origin/HEAD", Target: "refs/remotes/origin/main"}); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := r.ListRefs(RefListOptions{Patterns: []string{"refs/remotes/origin", "refs/pull/*/head"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	if strings.Join(names, " ") != "refs/pull/1/head refs/remotes/origin/HEAD refs/remotes/origin/main" {
+		t.Fatalf("unexpected refs %v", names)
+	}
+	if refs[1].Resolved.String() != "c08a875c2363d382d95f021c6de76f0b40366689" {
+		t.Fatalf("symbolic ref resolved to %v", refs[1].Resolved)
+	}
+
+	refs, err = r.ListRefs(RefListOptions{Patterns: []string{"refs/heads/main*"}, Sort: RefSortByCommitterDate, Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Name != "refs/heads/main-bad" || refs[1].Name != "refs/heads/main-conflict" {
+		t.Fatalf("unexpected order %v %v", refs[0].Name, refs[1].Name)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	r := copyTestRepo(t)
+
+	if _, err := r.Namespace("bad..name"); err == nil {
+		t.Fatal("invalid namespace accepted")
+	}
+	ns, err := r.Namespace("tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if branches, err := ns.GetBranches(); err != nil || len(branches) != 0 {
+		t.Fatalf("new namespace has branches %v, %v", branches, err)
+	}
+	if err := ns.CreateBranch("main", "c3ca89834257974d7375ac7915ed58d01afe7d4b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.CreateTag("v1", "c3ca89834257974d7375ac7915ed58d01afe7d4b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.SetHead("refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := ns.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name != "refs/heads/main" {
+		t.Fatalf("namespace HEAD resolved to %q", head.Name)
+	}
+	if !isRefExist(r.refs, "refs/namespaces/tenant/refs/heads/main") {
+		t.Fatal("branch not stored below the namespace")
+	}
+	if r.IsBranchExist("main") {
+		t.Fatal("namespaced branch visible outside")
+	}
+
+	// the namespace's HEAD logs the updates of its branch
+	before, err := ns.Reflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewIdFromString("c08a875c2363d382d95f021c6de76f0b40366689")
+	if err := ns.NewRefTransaction().Set("refs/heads/main", other).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ns.Reflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)+1 || !after[0].New.Equal(other) {
+		t.Fatalf("namespace HEAD log not updated: %+v", after)
+	}
+
+	// symbolic references leading out of the namespace stay hidden
+	escape := &Reference{Name: "refs/namespaces/tenant/refs/heads/escape", Target: "refs/heads/master"}
+	if err := r.refs.SetRef(escape); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Ref("refs/heads/escape"); err != ErrRefNotExist {
+		t.Fatalf("reference outside the namespace visible: %v", err)
+	}
+
+	refs, err := ns.ListRefs(RefListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Name != "refs/heads/main" || refs[1].Name != "refs/tags/v1" {
+		t.Fatalf("unexpected refs in namespace %v", refs)
+	}
+
+	nested, err := ns.Namespace("inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.CreateBranch("x", "c3ca89834257974d7375ac7915ed58d01afe7d4b"); err != nil {
+		t.Fatal(err)
+	}
+	if !isRefExist(r.refs, "refs/namespaces/tenant/refs/namespaces/inner/refs/heads/x") {
+		t.Fatal("nested namespace not stored below its parent")
+	}
+}
+
+func TestConcurrentRefLookups(t *testing.T) {
+	r := copyTestRepo(t)
+	packed := "c08a875c2363d382d95f021c6de76f0b40366689 refs/heads/packed\n"
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Ref("refs/heads/packed"); err != nil {
+				t.Error(err)
+			}
+			if _, err := r.Refs("refs/heads/"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
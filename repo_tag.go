@@ -7,8 +7,7 @@ import (
 )
 
 func (repo *Repository) IsTagExist(tagName string) bool {
-	tagPath := filepath.Join(repo.Path, "refs/tags", tagName)
-	return isFile(tagPath)
+	return isRefExist(repo.refs, "refs/tags/"+tagName)
 }
 
 func (repo *Repository) TagPath(tagName string) string {
@@ -17,11 +16,16 @@ func (repo *Repository) TagPath(tagName string) string {
 
 // GetTags returns all tags of given repository.
 func (repo *Repository) GetTags() ([]string, error) {
-	return repo.readRefDir("refs/tags", "")
+	return repo.refNames("refs/tags/")
 }
 
 func (repo *Repository) CreateTag(tagName, idStr string) error {
-	return repo.createRef("tags", tagName, idStr)
+	return createRef(repo.refs, repo.signature(), "tags", tagName, idStr)
+}
+
+// DeleteTag removes the tag, whether it is stored loose or packed.
+func (repo *Repository) DeleteTag(tagName string) error {
+	return deleteRef(repo.refs, "refs/tags/"+tagName)
 }
 
 func CreateTag(repoPath, tagName, id string) error {
@@ -29,17 +33,12 @@ func CreateTag(repoPath, tagName, id string) error {
 }
 
 func (repo *Repository) GetTag(tagName string) (*Tag, error) {
-	d, err := ioutil.ReadFile(repo.TagPath(tagName))
-	if err != nil {
-		return nil, err
-	}
-
-	id, err := NewIdFromString(string(d))
+	ref, err := repo.ResolveRef("refs/tags/" + tagName)
 	if err != nil {
 		return nil, err
 	}
 
-	tag, err := repo.getTag(id)
+	tag, err := repo.getTag(ref.Id)
 	if err != nil {
 		return nil, err
 	}
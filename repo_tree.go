@@ -12,7 +12,7 @@ func (repo *Repository) GetTree(idStr string) (*Tree, error) {
 }
 
 func (repo *Repository) getTree(id sha1) (*Tree, error) {
-	treePath := filepathFromSHA1(repo.Path, id.String())
+	treePath := filepathFromSHA1(repo.CommonDir, id.String())
 	if !isFile(treePath) {
 		m := false
 		for _, indexfile := range repo.indexfiles {
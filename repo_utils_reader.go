@@ -126,6 +126,15 @@ func readerLittleEndianBase128Number(r io.Reader) (int64, int) {
 	return length, zpos
 }
 
+// truncatedDelta returns err or, at the end of the delta, an error for the
+// missing data.
+func truncatedDelta(err error) error {
+	if err == nil {
+		return errors.New("[readerApplyDelta] truncated delta")
+	}
+	return err
+}
+
 func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte, err error) {
 	var (
 		resultpos uint64
@@ -169,6 +178,9 @@ func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte
 		// resulting object
 
 		if !read(dr) {
+			if err == nil && resultpos != uint64(resultLen) {
+				err = errors.New("[readerApplyDelta] result size mismatch")
+			}
 			return
 		}
 		opcode := buf[0]
@@ -181,7 +193,7 @@ func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte
 			for i := 0; i < 4; i++ {
 				if opcode&0x01 > 0 {
 					if !read(dr) {
-						return
+						return nil, truncatedDelta(err)
 					}
 					copy_offset |= uint64(buf[0]) << shift
 				}
@@ -193,7 +205,7 @@ func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte
 			for i := 0; i < 3; i++ {
 				if opcode&0x01 > 0 {
 					if !read(dr) {
-						return
+						return nil, truncatedDelta(err)
 					}
 					copy_length |= uint64(buf[0]) << shift
 				}
@@ -203,11 +215,17 @@ func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte
 			if copy_length == 0 {
 				copy_length = 1 << 16
 			}
+			if resultpos+copy_length > uint64(resultLen) {
+				return nil, errors.New("[readerApplyDelta] result too long")
+			}
 
 			brOffset := int64(copy_offset)
 			for i := uint64(0); i < copy_length; i++ {
 				if !readAt(br, brOffset) {
-					return
+					if err == nil {
+						err = errors.New("[readerApplyDelta] copy out of base")
+					}
+					return nil, err
 				}
 				res[resultpos] = buf[0]
 				resultpos++
@@ -215,9 +233,12 @@ func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte
 			}
 		} else if opcode > 0 {
 			// insert n bytes at the end of the resulting object. n==opcode
+			if resultpos+uint64(opcode) > uint64(resultLen) {
+				return nil, errors.New("[readerApplyDelta] result too long")
+			}
 			for i := 0; i < int(opcode); i++ {
 				if !read(dr) {
-					return
+					return nil, truncatedDelta(err)
 				}
 				res[resultpos] = buf[0]
 				resultpos++
@@ -226,6 +247,4 @@ func readerApplyDelta(br io.ReaderAt, dr io.Reader, resultLen int64) (res []byte
 			return nil, errors.New("[readerApplyDelta] opcode == 0")
 		}
 	}
-	// TODO: check if resultlen == resultpos
-	return
 }
@@ -90,3 +90,9 @@ func NewId(b []byte) (sha1, error) {
 	}
 	return id, nil
 }
+
+// Return true if all bytes of the id are zero, which git uses for a
+// missing object.
+func (s sha1) IsZero() bool {
+	return s == sha1{}
+}
@@ -2,6 +2,9 @@ package git
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"os/user"
 	"strconv"
 	"time"
 )
@@ -24,20 +27,79 @@ func (s Signature) String() string {
 //     author Patrick Gundlach <gundlach@speedata.de> 1378823654 +0200
 // but without the "author " at the beginning (this method should)
 // be used for author and committer.
-//
-// FIXME: include timezone!
 func newSignatureFromCommitline(line []byte) (*Signature, error) {
 	sig := new(Signature)
 	emailstart := bytes.IndexByte(line, '<')
-	sig.Name = string(line[:emailstart-1])
 	emailstop := bytes.IndexByte(line, '>')
+	if emailstart < 1 || emailstop < emailstart || emailstop+2 > len(line) {
+		return nil, fmt.Errorf("malformed signature %q", line)
+	}
+	sig.Name = string(line[:emailstart-1])
 	sig.Email = string(line[emailstart+1 : emailstop])
-	timestop := bytes.IndexByte(line[emailstop+2:], ' ')
-	timestring := string(line[emailstop+2 : emailstop+2+timestop])
-	seconds, err := strconv.ParseInt(timestring, 10, 64)
+
+	fields := bytes.Fields(line[emailstop+1:])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("malformed signature %q", line)
+	}
+	seconds, err := strconv.ParseInt(string(fields[0]), 10, 64)
 	if err != nil {
 		return nil, err
 	}
 	sig.When = time.Unix(seconds, 0)
+
+	if len(fields) > 1 {
+		loc, err := parseTimezone(string(fields[1]))
+		if err != nil {
+			return nil, err
+		}
+		sig.When = sig.When.In(loc)
+	}
 	return sig, nil
 }
+
+// parseTimezone parses a git timezone offset like "+0200".
+func parseTimezone(tz string) (*time.Location, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return nil, fmt.Errorf("malformed timezone %q", tz)
+	}
+	hhmm, err := strconv.Atoi(tz[1:])
+	if err != nil {
+		return nil, err
+	}
+	offset := (hhmm/100*60 + hhmm%100) * 60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(tz, offset), nil
+}
+
+// encode returns the signature as used in commit headers and reflogs:
+// "Name <email> 1378823654 +0200"
+func (s *Signature) encode() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), s.When.Format("-0700"))
+}
+
+// defaultSignature returns the identity git would use for the current
+// user, taken from the GIT_COMMITTER_* environment or the system.
+func defaultSignature() *Signature {
+	sig := &Signature{
+		Name:  os.Getenv("GIT_COMMITTER_NAME"),
+		Email: os.Getenv("GIT_COMMITTER_EMAIL"),
+		When:  time.Now(),
+	}
+
+	if len(sig.Name) == 0 || len(sig.Email) == 0 {
+		name := "unknown"
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		if len(sig.Name) == 0 {
+			sig.Name = name
+		}
+		if len(sig.Email) == 0 {
+			host, _ := os.Hostname()
+			sig.Email = name + "@" + host
+		}
+	}
+	return sig
+}
@@ -0,0 +1,572 @@
+package git
+
+import (
+	libsha1 "crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBareRepo = errors.New("repository has no working tree")
+)
+
+// StatusCode is a letter of git status --short.
+type StatusCode byte
+
+const (
+	StatusUnmodified  StatusCode = ' '
+	StatusModified    StatusCode = 'M'
+	StatusTypeChanged StatusCode = 'T'
+	StatusAdded       StatusCode = 'A'
+	StatusDeleted     StatusCode = 'D'
+	StatusRenamed     StatusCode = 'R'
+	StatusUnmerged    StatusCode = 'U'
+	StatusUntracked   StatusCode = '?'
+	StatusIgnored     StatusCode = '!'
+)
+
+// FileStatus is the state of a path. Staging compares the index with HEAD,
+// Worktree the working tree with the index. For conflicts both codes
+// describe which side changed the path, e.g. "UU" if both modified it or
+// "AU" if it was only added by us.
+type FileStatus struct {
+	Path     string // a trailing slash marks a directory
+	OrigPath string // source of a rename
+	Staging  StatusCode
+	Worktree StatusCode
+}
+
+// IsConflicted reports whether the path has unresolved conflicts.
+func (fs *FileStatus) IsConflicted() bool {
+	return fs.Staging == StatusUnmerged || fs.Worktree == StatusUnmerged ||
+		fs.Staging == StatusAdded && fs.Worktree == StatusAdded ||
+		fs.Staging == StatusDeleted && fs.Worktree == StatusDeleted
+}
+
+// String returns the line git status --short would print.
+func (fs *FileStatus) String() string {
+	if len(fs.OrigPath) > 0 {
+		return fmt.Sprintf("%c%c %s -> %s", fs.Staging, fs.Worktree, fs.OrigPath, fs.Path)
+	}
+	return fmt.Sprintf("%c%c %s", fs.Staging, fs.Worktree, fs.Path)
+}
+
+// Status lists the paths which are not unmodified, sorted by path.
+type Status []*FileStatus
+
+// IsClean reports whether there is nothing to commit and nothing untracked.
+func (s Status) IsClean() bool {
+	for _, fs := range s {
+		if fs.Staging != StatusIgnored {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Status) String() string {
+	var buf strings.Builder
+	for _, fs := range s {
+		buf.WriteString(fs.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+type UntrackedMode int
+
+const (
+	// list untracked files, but only the directory if none of its files
+	// is tracked
+	UntrackedNormal UntrackedMode = iota
+	UntrackedAll
+	UntrackedNo
+)
+
+// StatusOptions configures Status.
+type StatusOptions struct {
+	Untracked UntrackedMode
+
+	// Ignored also lists ignored files and directories.
+	Ignored bool
+}
+
+// Status compares HEAD, the index and the working tree like git status.
+//
+// Files whose size and modification time match the index are not read.
+// Content filters like core.autocrlf are not applied.
+func (repo *Repository) Status(opts StatusOptions) (Status, error) {
+	if repo.IsBare() {
+		return nil, ErrBareRepo
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := repo.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &statusBuilder{
+		repo:     repo,
+		opts:     opts,
+		idx:      idx,
+		byPath:   make(map[string]*FileStatus),
+		fileMode: true,
+	}
+	if fileMode, err := cfg.GetBool("core.fileMode"); err == nil {
+		s.fileMode = fileMode
+	}
+	if fi, err := os.Stat(filepath.Join(repo.Path, "index")); err == nil {
+		s.indexMTime = fi.ModTime()
+	}
+
+	if err := s.compareHead(); err != nil {
+		return nil, err
+	}
+	if err := s.compareWorktree(); err != nil {
+		return nil, err
+	}
+	if opts.Untracked != UntrackedNo || opts.Ignored {
+		if err := s.walkUntracked("", repo.newIgnoreMatcher(cfg), false); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make(Status, 0, len(s.byPath)+len(s.untracked))
+	for _, fs := range s.byPath {
+		status = append(status, fs)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Path < status[j].Path
+	})
+
+	// untracked files come before ignored ones
+	sort.SliceStable(s.untracked, func(i, j int) bool {
+		if s.untracked[i].Staging != s.untracked[j].Staging {
+			return s.untracked[i].Staging == StatusUntracked
+		}
+		return s.untracked[i].Path < s.untracked[j].Path
+	})
+	return append(status, s.untracked...), nil
+}
+
+type statusBuilder struct {
+	repo       *Repository
+	opts       StatusOptions
+	idx        *Index
+	byPath     map[string]*FileStatus
+	untracked  []*FileStatus // and ignored, in order
+	fileMode   bool
+	indexMTime time.Time
+}
+
+func (s *statusBuilder) get(path string) *FileStatus {
+	fs := s.byPath[path]
+	if fs == nil {
+		fs = &FileStatus{Path: path, Staging: StatusUnmodified, Worktree: StatusUnmodified}
+		s.byPath[path] = fs
+	}
+	return fs
+}
+
+type headFile struct {
+	mode EntryMode
+	id   sha1
+}
+
+// compareHead finds the staged changes and conflicts.
+func (s *statusBuilder) compareHead() error {
+	head := make(map[string]headFile)
+	if ref, err := s.repo.Head(); err == nil {
+		commit, err := s.repo.getCommit(ref.Id)
+		if err != nil {
+			return err
+		}
+		err = commit.Tree.walkFiles("", func(path string, te *TreeEntry) error {
+			head[path] = headFile{te.mode, te.Id}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if err != ErrRefNotExist {
+		return err
+	}
+
+	var added, deleted []*FileStatus
+	stages := make(map[string]int)
+	for _, e := range s.idx.Entries {
+		if e.Stage > 0 {
+			stages[e.Name] |= 1 << uint(e.Stage-1)
+			continue
+		}
+
+		h, ok := head[e.Name]
+		switch {
+		case e.IntentToAdd:
+			// shown as added in the working tree
+		case !ok:
+			fs := s.get(e.Name)
+			fs.Staging = StatusAdded
+			added = append(added, fs)
+		case h.mode != e.Mode && modeType(h.mode) != modeType(e.Mode):
+			s.get(e.Name).Staging = StatusTypeChanged
+		case h.mode != e.Mode || h.id != e.Id:
+			s.get(e.Name).Staging = StatusModified
+		}
+	}
+
+	for path, h := range head {
+		if s.idx.Entry(path, 0) == nil && stages[path] == 0 {
+			fs := s.get(path)
+			fs.Staging = StatusDeleted
+			if h.mode != ModeCommit {
+				deleted = append(deleted, fs)
+			}
+		}
+	}
+	s.findRenames(head, added, deleted)
+
+	// codes for stages 1 (base), 2 (ours) and 3 (theirs) as in git status
+	conflictCodes := map[int]string{
+		1: "DD", 2: "AU", 3: "UD", 4: "UA", 5: "DU", 6: "AA", 7: "UU",
+	}
+	for path, mask := range stages {
+		fs := s.get(path)
+		code := conflictCodes[mask]
+		fs.Staging, fs.Worktree = StatusCode(code[0]), StatusCode(code[1])
+	}
+	return nil
+}
+
+// findRenames pairs added and deleted paths with identical content.
+func (s *statusBuilder) findRenames(head map[string]headFile, added, deleted []*FileStatus) {
+	if len(added) == 0 || len(deleted) == 0 {
+		return
+	}
+
+	sources := make(map[sha1][]*FileStatus)
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].Path < deleted[j].Path })
+	for _, fs := range deleted {
+		id := head[fs.Path].id
+		sources[id] = append(sources[id], fs)
+	}
+
+	sort.Slice(added, func(i, j int) bool { return added[i].Path < added[j].Path })
+	for _, fs := range added {
+		id := s.idx.Entry(fs.Path, 0).Id
+		candidates := sources[id]
+		if len(candidates) == 0 {
+			continue
+		}
+		src := candidates[0]
+		sources[id] = candidates[1:]
+
+		fs.Staging = StatusRenamed
+		fs.OrigPath = src.Path
+		delete(s.byPath, src.Path)
+	}
+}
+
+// modeType returns the kind of file a mode stands for, ignoring the
+// executable bit.
+func modeType(mode EntryMode) EntryMode {
+	if mode == ModeExec {
+		return ModeBlob
+	}
+	return mode
+}
+
+// compareWorktree finds the changes in the working tree which are not
+// staged.
+func (s *statusBuilder) compareWorktree() error {
+	for _, e := range s.idx.Entries {
+		if e.Stage > 0 || e.SkipWorktree {
+			continue
+		}
+
+		code, err := s.worktreeChange(e)
+		if err != nil {
+			return err
+		}
+		if e.IntentToAdd && code != StatusDeleted {
+			code = StatusAdded
+		}
+		if code != StatusUnmodified {
+			s.get(e.Name).Worktree = code
+		}
+	}
+	return nil
+}
+
+func (s *statusBuilder) worktreeChange(e *IndexEntry) (StatusCode, error) {
+	path := filepath.Join(s.repo.WorkTree, filepath.FromSlash(e.Name))
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) || isNotDir(err) {
+			return StatusDeleted, nil
+		}
+		return 0, err
+	}
+
+	if e.Mode == ModeCommit {
+		if !fi.IsDir() {
+			return StatusTypeChanged, nil
+		}
+		return s.submoduleChange(path, e.Id), nil
+	}
+
+	var mode EntryMode
+	switch {
+	case fi.IsDir():
+		return StatusDeleted, nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		mode = ModeSymlink
+	case !fi.Mode().IsRegular():
+		return StatusTypeChanged, nil
+	case fi.Mode()&0111 != 0 && s.fileMode:
+		mode = ModeExec
+	default:
+		mode = ModeBlob
+		if !s.fileMode && e.Mode == ModeExec {
+			mode = ModeExec
+		}
+	}
+
+	if modeType(mode) != modeType(e.Mode) {
+		return StatusTypeChanged, nil
+	}
+	if mode != e.Mode {
+		return StatusModified, nil
+	}
+	if e.IntentToAdd {
+		return StatusUnmodified, nil
+	}
+
+	// the stat data can only be trusted if the file was not changed in
+	// the same instant the index was written
+	if uint32(fi.Size()) != e.Size {
+		return StatusModified, nil
+	}
+	racy := !e.MTime.Before(s.indexMTime)
+	if fi.ModTime().Equal(e.MTime) && !racy {
+		return StatusUnmodified, nil
+	}
+
+	id, err := hashWorktreeFile(path, fi)
+	if err != nil {
+		return 0, err
+	}
+	if id != e.Id {
+		return StatusModified, nil
+	}
+	return StatusUnmodified, nil
+}
+
+// submoduleChange compares the checked out commit of a submodule with the
+// recorded one. Submodules which are not checked out are unmodified.
+func (s *statusBuilder) submoduleChange(path string, id sha1) StatusCode {
+	gitDir, err := readGitDirLink(filepath.Join(path, ".git"))
+	if err != nil {
+		return StatusUnmodified
+	}
+	head, err := resolveRef(openRefStore(gitDir), "HEAD")
+	if err != nil || head.Id == id {
+		return StatusUnmodified
+	}
+	return StatusModified
+}
+
+// hashWorktreeFile returns the blob id of a file, or of the target of a
+// symbolic link.
+func hashWorktreeFile(path string, fi os.FileInfo) (sha1, error) {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return sha1{}, err
+		}
+		return hashObject(ObjectBlob, int64(len(target)), strings.NewReader(target))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sha1{}, err
+	}
+	defer f.Close()
+	return hashObject(ObjectBlob, fi.Size(), f)
+}
+
+// hashObject returns the id of an object of size bytes read from r.
+func hashObject(objectType ObjectType, size int64, r io.Reader) (sha1, error) {
+	hash := libsha1.New()
+	fmt.Fprintf(hash, "%s %d\x00", objectType, size)
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return sha1{}, err
+	}
+	if n != size {
+		return sha1{}, fmt.Errorf("object changed size while hashing")
+	}
+	return NewId(hash.Sum(nil))
+}
+
+// isTrackedDir reports whether the index has entries below the directory
+// dir, which ends in a slash.
+func (s *statusBuilder) isTrackedDir(dir string) bool {
+	i := sort.Search(len(s.idx.Entries), func(i int) bool {
+		return s.idx.Entries[i].Name >= dir
+	})
+	return i < len(s.idx.Entries) && strings.HasPrefix(s.idx.Entries[i].Name, dir)
+}
+
+func (s *statusBuilder) isTracked(name string) bool {
+	i, _ := s.idx.find(name, 0)
+	return i < len(s.idx.Entries) && s.idx.Entries[i].Name == name
+}
+
+func (s *statusBuilder) report(path string, code StatusCode) {
+	if code == StatusIgnored && !s.opts.Ignored || code == StatusUntracked && s.opts.Untracked == UntrackedNo {
+		return
+	}
+	s.untracked = append(s.untracked, &FileStatus{Path: path, Staging: code, Worktree: code})
+}
+
+// readWorktreeDir lists the directory dir of the work tree, without .git.
+func (s *statusBuilder) readWorktreeDir(dir string) ([]os.FileInfo, error) {
+	f, err := os.Open(filepath.Join(s.repo.WorkTree, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+	fis, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for _, fi := range fis {
+		if fi.Name() != ".git" {
+			fis[n] = fi
+			n++
+		}
+	}
+	fis = fis[:n]
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+// walkUntracked reports the untracked and ignored files in the directory
+// dir, "" or ending in a slash, which contains tracked files. Everything
+// below an ignored directory is ignored.
+func (s *statusBuilder) walkUntracked(dir string, ignore ignoreMatcher, ignored bool) error {
+	ignore = ignore.with(filepath.Join(s.repo.WorkTree, filepath.FromSlash(dir), ".gitignore"), dir)
+	fis, err := s.readWorktreeDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		name := dir + fi.Name()
+		if !fi.IsDir() {
+			if s.isTracked(name) {
+				continue
+			}
+			if ignored || ignore.Ignored(name, false) {
+				s.report(name, StatusIgnored)
+			} else {
+				s.report(name, StatusUntracked)
+			}
+			continue
+		}
+
+		if e := s.idx.Entry(name, 0); e != nil && e.Mode == ModeCommit {
+			continue
+		}
+		dirIgnored := ignored || ignore.Ignored(name, true)
+		if s.isTrackedDir(name + "/") {
+			if err := s.walkUntracked(name+"/", ignore, dirIgnored); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if dirIgnored {
+			s.report(name+"/", StatusIgnored)
+			continue
+		}
+		if isDir(filepath.Join(s.repo.WorkTree, filepath.FromSlash(name), ".git")) || isFile(filepath.Join(s.repo.WorkTree, filepath.FromSlash(name), ".git")) {
+			// another repository
+			s.report(name+"/", StatusUntracked)
+			continue
+		}
+		if err := s.walkUntrackedDir(name+"/", ignore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkUntrackedDir reports the content of the directory dir, which has no
+// tracked files. Unless all untracked files are requested, the directory is
+// reported instead of its untracked files.
+func (s *statusBuilder) walkUntrackedDir(dir string, ignore ignoreMatcher) error {
+	if s.opts.Untracked == UntrackedAll {
+		return s.walkUntracked(dir, ignore, false)
+	}
+
+	untracked, ignored, err := s.scanUntrackedDir(dir, ignore)
+	if err != nil {
+		return err
+	}
+	if !untracked {
+		if len(ignored) > 0 {
+			s.report(dir, StatusIgnored)
+		}
+		return nil
+	}
+
+	s.report(dir, StatusUntracked)
+	for _, path := range ignored {
+		s.report(path, StatusIgnored)
+	}
+	return nil
+}
+
+// scanUntrackedDir returns whether there are untracked files below dir and
+// the ignored files and directories.
+func (s *statusBuilder) scanUntrackedDir(dir string, ignore ignoreMatcher) (untracked bool, ignored []string, err error) {
+	ignore = ignore.with(filepath.Join(s.repo.WorkTree, filepath.FromSlash(dir), ".gitignore"), dir)
+	fis, err := s.readWorktreeDir(dir)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for _, fi := range fis {
+		name := dir + fi.Name()
+		switch {
+		case !fi.IsDir() && ignore.Ignored(name, false):
+			ignored = append(ignored, name)
+		case !fi.IsDir():
+			untracked = true
+		case ignore.Ignored(name, true):
+			ignored = append(ignored, name+"/")
+		default:
+			u, i, err := s.scanUntrackedDir(name+"/", ignore)
+			if err != nil {
+				return false, nil, err
+			}
+			untracked = untracked || u
+			ignored = append(ignored, i...)
+		}
+	}
+	return untracked, ignored, nil
+}
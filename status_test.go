@@ -0,0 +1,107 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	dir := t.TempDir()
+	r, err := InitRepository(dir, false, InitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a":            "a\n",
+		"b":            "b\n",
+		".gitignore":   "*.log\nvendor/\n",
+		"c.log":        "ignored\n",
+		"d":            "untracked\n",
+		"dir/e":        "untracked\n",
+		"vendor/.keep": "",
+		"vendor/new":   "ignored with its directory\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := NewIndex()
+	for _, name := range []string{"a", "b", "vendor/.keep"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := hashWorktreeFile(path, fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx.Add(&IndexEntry{Name: name, Id: id, Mode: ModeBlob, MTime: fi.ModTime(), Size: uint32(fi.Size())})
+	}
+	if err := r.WriteIndex(idx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b"), []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := r.Status(StatusOptions{Ignored: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"A  a",
+		"AM b",
+		"A  vendor/.keep",
+		"?? .gitignore",
+		"?? d",
+		"?? dir/",
+		"!! c.log",
+		"!! vendor/new",
+	}, "\n") + "\n"
+	if status.String() != expected {
+		t.Fatalf("unexpected status:\n%s", status)
+	}
+	if status.IsClean() {
+		t.Fatal("status is clean")
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	m := ignoreMatcher(parseIgnorePatterns([]byte("# comment\n*.o\n!keep.o\n/root-only\nbuild/\ndocs/**/*.tmp\n\\#hash\n"), ""))
+	m = append(m, parseIgnorePatterns([]byte("local\n"), "sub/")...)
+
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"x.o", false, true},
+		{"deep/x.o", false, true},
+		{"keep.o", false, false},
+		{"root-only", false, true},
+		{"sub/root-only", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"docs/a/b/c.tmp", false, true},
+		{"docs/c.tmp", false, true},
+		{"#hash", false, true},
+		{"sub/local", false, true},
+		{"local", false, false},
+	}
+	for _, c := range cases {
+		if got := m.Ignored(c.path, c.isDir); got != c.want {
+			t.Errorf("Ignored(%q, %v) = %v", c.path, c.isDir, got)
+		}
+	}
+}
@@ -131,3 +131,49 @@ func (t *Tree) Scanner() (*TreeScanner, error) {
 	}
 	return NewTreeScanner(t, r), nil
 }
+
+// readEntries returns the entries of the tree, unlike ListEntries reporting
+// errors.
+func (t *Tree) readEntries() (Entries, error) {
+	scanner, err := t.Scanner()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries Entries
+	for scanner.Scan() {
+		entries = append(entries, scanner.TreeEntry())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// walkFiles calls fn for every entry below the tree which is not a tree
+// itself, with its slash separated path below prefix.
+func (t *Tree) walkFiles(prefix string, fn func(path string, te *TreeEntry) error) error {
+	entries, err := t.readEntries()
+	if err != nil {
+		return err
+	}
+
+	for _, te := range entries {
+		p := prefix + te.name
+		if !te.IsDir() {
+			if err := fn(p, te); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sub, err := t.repo.getTree(te.Id)
+		if err != nil {
+			return err
+		}
+		if err := sub.walkFiles(p+"/", fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -14,6 +14,14 @@ func isFile(filePath string) bool {
 	return !f.IsDir()
 }
 
+func isDir(dirPath string) bool {
+	f, e := os.Stat(dirPath)
+	if e != nil {
+		return false
+	}
+	return f.IsDir()
+}
+
 func RefEndName(refStr string) string {
 	index := strings.LastIndex(refStr, "/")
 	if index != -1 {
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"path"
+	"strings"
+)
+
+// wildmatch matches name against a glob pattern the way git matches paths:
+// "*" and "?" do not match "/", while "**" matches across directories.
+// "a/**/b" matches "a/b", "a/x/b" and "a/x/y/b"; a trailing "/**" matches
+// everything inside a directory.
+func wildmatch(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "**") && (len(pattern) == 2 || pattern[2] == '/'):
+			rest := strings.TrimPrefix(pattern[2:], "/")
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if (i == 0 || name[i-1] == '/') && wildmatch(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case pattern[0] == '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			for i := 0; i <= len(name); i++ {
+				if wildmatch(pattern, name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' {
+					break
+				}
+			}
+			return false
+
+		case len(name) == 0:
+			return false
+
+		case pattern[0] == '?':
+			if name[0] == '/' {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+
+		case pattern[0] == '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false
+			}
+			class := pattern[:end+2]
+			if class[1] == '^' {
+				class = "[!" + class[2:]
+			}
+			if ok, err := path.Match(class, name[:1]); err != nil || !ok || name[0] == '/' {
+				return false
+			}
+			pattern, name = pattern[end+2:], name[1:]
+
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] != name[0] {
+				return false
+			}
+			pattern, name = pattern[2:], name[1:]
+
+		default:
+			if pattern[0] != name[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return len(name) == 0
+}